@@ -5,6 +5,8 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"rm-importer/backend"
 	"time"
 
@@ -14,22 +16,24 @@ import (
 // App struct
 type App struct {
 	ctx         context.Context
-	rm_reader   backend.RmReader
-	ssh_reader  *backend.SSHReader
+	reader      backend.TabletReader // lists the files, nil until connected
 	ssh_conn    *backend.SSHConnection
 	tablet_addr string
 	selection   backend.FileSelection
 
-	rm_export      backend.RmExport
-	ssh_export     backend.SSHExport
+	transport      backend.ExportTransport // downloads the files, set together with the reader
+	exporter       backend.Exporter
 	export_options backend.RmExportOptions
 
 	// SSH connection details
 	ssh_host     string
 	ssh_username string
-	ssh_password string
+	ssh_auth     backend.SSHAuthOptions
+	known_hosts  *backend.KnownHosts
 	safe_mode    bool
-	hybrid_mode  bool
+
+	session        *backend.ImportSession // changes made over SSH, nil without a connection
+	restart_policy backend.RestartPolicy
 }
 
 //go:embed wails.json
@@ -38,7 +42,8 @@ var wailsJSON string
 // NewApp creates a new App application struct
 func NewApp() *App {
 	return &App{
-		safe_mode: true, // Safe mode enabled by default
+		safe_mode:      true, // Safe mode enabled by default
+		restart_policy: backend.RestartNow,
 	}
 }
 
@@ -46,6 +51,21 @@ func NewApp() *App {
 // so we can call the runtime methods
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
+
+	path, err := backend.DefaultKnownHostsPath()
+	if err != nil {
+		path = filepath.Join(os.TempDir(), "rm-importer-known_hosts")
+		runtime.LogWarningf(a.ctx, "[APP] Config directory is unknown (%v), using %s for known hosts", err, path)
+	}
+	a.known_hosts = backend.NewKnownHosts(path)
+}
+
+/* shutdown is called when the app quits, the tablet gets the changes that are still pending. */
+func (a *App) shutdown(ctx context.Context) {
+	err := a.DisconnectSSH()
+	if err != nil {
+		runtime.LogErrorf(a.ctx, "[APP] Disconnecting failed: %v", err)
+	}
 }
 
 func (a *App) GetAppVersion() string {
@@ -61,116 +81,183 @@ func (a *App) IsIpValid(s string) bool {
 	return backend.IsIpValid(s)
 }
 
-func (a *App) ReadDocs(tablet_addr string) error {
-	a.tablet_addr = tablet_addr
-
-	err := a.rm_reader.Read(tablet_addr)
+/* Reads all the items with the reader and uses it for the file selection, the transport is used for exports. */
+func (a *App) setReader(reader backend.TabletReader, transport backend.ExportTransport) error {
+	err := reader.Read()
 	if err != nil {
 		return err
 	}
 
-	a.selection = backend.NewFileSelection(a.rm_reader.GetChildrenMap())
+	a.reader = reader
+	a.transport = transport
+	a.selection = backend.NewFileSelection(reader.GetChildrenMap())
 	return nil
 }
 
-func (a *App) ConnectSSH(host, username, password string) error {
-	runtime.LogInfof(a.ctx, "[APP] ConnectSSH called with host=%s, username=%s, password_length=%d", host, username, len(password))
+func (a *App) ReadDocs(tablet_addr string) error {
+	a.tablet_addr = tablet_addr
+	return a.setReader(backend.NewRmReader(tablet_addr), backend.NewHTTPTransport(a.ctx, tablet_addr))
+}
+
+func (a *App) ConnectSSH(host, username string, auth backend.SSHAuthOptions) error {
+	runtime.LogInfof(a.ctx, "[APP] ConnectSSH called with host=%s, username=%s, auth=%s", host, username, auth.Method)
 
 	a.ssh_host = host
 	a.ssh_username = username
-	a.ssh_password = password
+	a.ssh_auth = auth
+
+	// Close the connection left from a previous attempt
+	a.DisconnectSSH()
 
 	// Create SSH connection
 	runtime.LogInfo(a.ctx, "[APP] Creating SSH connection...")
-	a.ssh_conn = backend.NewSSHConnection(host, username, password, a.ctx)
+	connection := backend.NewSSHConnection(host, username, auth, a.known_hosts, a.ctx)
+	session, err := backend.NewImportSession(connection, a.restart_policy)
+	if err != nil {
+		return err
+	}
 
 	runtime.LogInfo(a.ctx, "[APP] Attempting SSH connection...")
-	err := a.ssh_conn.Connect()
+	err = connection.Connect()
 	if err != nil {
 		runtime.LogErrorf(a.ctx, "[APP] SSH connection failed: %v", err)
 		return err
 	}
+	/* Only a working connection switches the app to SSH mode */
+	a.ssh_conn, a.session = connection, session
 
-	// Create SSH reader
-	runtime.LogInfo(a.ctx, "[APP] Creating SSH reader...")
-	a.ssh_reader = backend.NewSSHReader(a.ssh_conn)
+	err = a.ssh_conn.RemoveStaleStaging()
+	if err != nil {
+		runtime.LogWarningf(a.ctx, "[APP] %v", err)
+	}
 
 	runtime.LogInfo(a.ctx, "[APP] Reading documents from SSH...")
-	err = a.ssh_reader.Read()
+	err = a.setReader(backend.NewSSHReader(a.ssh_conn), backend.NewSSHTransport(a.ssh_conn))
 	if err != nil {
 		runtime.LogErrorf(a.ctx, "[APP] SSH read failed: %v", err)
 		return err
 	}
 
-	// Create selection from SSH reader
-	runtime.LogInfo(a.ctx, "[APP] Creating file selection...")
-	a.selection = backend.NewFileSelection(a.ssh_reader.GetChildrenMap())
-
 	runtime.LogInfo(a.ctx, "[APP] SSH connection and setup completed successfully!")
 	return nil
 }
 
-func (a *App) ConnectSSHForUploads(host, username, password string) error {
-	runtime.LogInfof(a.ctx, "[APP] ConnectSSHForUploads called with host=%s, username=%s, password_length=%d", host, username, len(password))
+func (a *App) ConnectSSHForUploads(host, username string, auth backend.SSHAuthOptions) error {
+	runtime.LogInfof(a.ctx, "[APP] ConnectSSHForUploads called with host=%s, username=%s, auth=%s", host, username, auth.Method)
 
 	a.ssh_host = host
 	a.ssh_username = username
-	a.ssh_password = password
+	a.ssh_auth = auth
+
+	// Close the connection left from a previous attempt
+	a.DisconnectSSH()
 
 	// Create SSH connection for uploads only (no file reading)
 	runtime.LogInfo(a.ctx, "[APP] Creating SSH connection for uploads...")
-	a.ssh_conn = backend.NewSSHConnection(host, username, password, a.ctx)
+	connection := backend.NewSSHConnection(host, username, auth, a.known_hosts, a.ctx)
+	session, err := backend.NewImportSession(connection, a.restart_policy)
+	if err != nil {
+		return err
+	}
 
 	runtime.LogInfo(a.ctx, "[APP] Attempting SSH connection...")
-	err := a.ssh_conn.Connect()
+	err = connection.Connect()
 	if err != nil {
 		runtime.LogErrorf(a.ctx, "[APP] SSH connection failed: %v", err)
 		return err
 	}
+	/* Only a working connection switches the app to SSH mode */
+	a.ssh_conn, a.session = connection, session
 
-	// Create SSH reader for upload functionality only
-	// Note: We don't call Read() here because we're using HTTP for file listing
-	runtime.LogInfo(a.ctx, "[APP] Creating SSH reader for uploads...")
-	a.ssh_reader = backend.NewSSHReader(a.ssh_conn)
+	err = a.ssh_conn.RemoveStaleStaging()
+	if err != nil {
+		runtime.LogWarningf(a.ctx, "[APP] %v", err)
+	}
 
+	// Note: The reader isn't replaced here because we're using HTTP for file listing
 	runtime.LogInfo(a.ctx, "[APP] SSH connection for uploads completed successfully!")
 	return nil
 }
 
 func (a *App) DisconnectSSH() error {
-	if a.ssh_conn != nil {
-		return a.ssh_conn.Close()
+	if a.ssh_conn == nil {
+		return nil
 	}
-	return nil
+
+	/* With the "later" policy the pending changes are shown now */
+	sessionErr := a.session.Close()
+	if sessionErr != nil {
+		runtime.LogErrorf(a.ctx, "[APP] Restarting xochitl failed: %v", sessionErr)
+	}
+
+	err := a.ssh_conn.Close()
+	if _, ok := a.reader.(*backend.SSHReader); ok {
+		a.reader = nil
+		a.transport = nil
+	}
+	a.ssh_conn = nil
+	a.session = nil
+	if err != nil {
+		return err
+	}
+	return sessionErr
 }
 
 func (a *App) SetSafeMode(enabled bool) {
 	a.safe_mode = enabled
 }
 
-func (a *App) SetHybridMode(enabled bool) {
-	a.hybrid_mode = enabled
-}
-
 func (a *App) GetSafeMode() bool {
 	return a.safe_mode
 }
 
+/* When xochitl is restarted after uploads and other changes: "now", "later" or "never". */
+func (a *App) SetRestartPolicy(policy backend.RestartPolicy) error {
+	if err := backend.ValidateRestartPolicy(policy); err != nil {
+		return err
+	}
+	a.restart_policy = policy
+
+	if a.session != nil {
+		err := a.session.SetPolicy(policy)
+		a.emitPendingChanges()
+		return err
+	}
+	return nil
+}
+
+func (a *App) GetRestartPolicy() backend.RestartPolicy {
+	return a.restart_policy
+}
+
+/* Number of changes the tablet shows after xochitl is restarted. */
+func (a *App) GetPendingChanges() int {
+	if a.session == nil {
+		return 0
+	}
+	return a.session.Pending()
+}
+
+func (a *App) emitPendingChanges() {
+	runtime.EventsEmit(a.ctx, "pending-changes", a.GetPendingChanges())
+}
+
+/* True if the SSH connection is available for uploads, regardless of how the files are listed. */
 func (a *App) IsSSHMode() bool {
-	return a.ssh_reader != nil
+	return a.ssh_conn != nil
+}
+
+/* True if the files are listed over SSH, which the trash and the changes to the tablet's files need. */
+func (a *App) IsListedOverSSH() bool {
+	_, ok := a.reader.(*backend.SSHReader)
+	return ok
 }
 
 func (a *App) GetFolder(id backend.DocId) []backend.DocInfo {
-	// In hybrid mode, always use HTTP for file listing
-	if a.hybrid_mode {
-		return a.rm_reader.GetFolder(id)
-	}
-	// In pure SSH mode, use SSH
-	if a.ssh_reader != nil {
-		return a.ssh_reader.GetFolder(id)
+	if a.reader == nil {
+		return []backend.DocInfo{}
 	}
-	// Fallback to HTTP
-	return a.rm_reader.GetFolder(id)
+	return a.reader.GetFolder(id)
 }
 
 func (a *App) GetFolderSelection(id backend.DocId) []backend.SelectionInfo {
@@ -203,15 +290,7 @@ func (a *App) GetExportOptions() backend.RmExportOptions {
 }
 
 func (a *App) InitExport() {
-	if a.ssh_reader != nil {
-		// Use SSH export
-		runtime.LogInfo(a.ctx, "[APP] Initializing SSH export")
-		a.ssh_export = backend.InitSSHExport(a.ctx, a.export_options, a.GetCheckedFiles(), a.ssh_conn)
-	} else {
-		// Use HTTP export
-		runtime.LogInfo(a.ctx, "[APP] Initializing HTTP export")
-		a.rm_export = backend.InitExport(a.ctx, a.export_options, a.GetCheckedFiles(), a.tablet_addr)
-	}
+	a.exporter = backend.InitExport(a.ctx, a.export_options, a.GetCheckedFiles(), a.transport)
 }
 
 func (a *App) Export() {
@@ -230,23 +309,15 @@ func (a *App) Export() {
 		runtime.EventsEmit(a.ctx, "failed", item.Id, err.Error())
 	}
 
-	if a.ssh_reader != nil {
-		// Use SSH export
-		runtime.LogInfo(a.ctx, "[APP] Starting SSH export")
-		a.ssh_export.Export(started, finished, failed)
-	} else {
-		// Use HTTP export
-		runtime.LogInfo(a.ctx, "[APP] Starting HTTP export")
-		a.rm_export.Export(started, finished, failed)
-	}
+	a.exporter.Export(started, finished, failed)
 }
 
 /* Includes path for every checked file */
 func (a *App) GetCheckedFiles() []backend.DocInfo {
-	if a.ssh_reader != nil {
-		return a.ssh_reader.GetCheckedFiles(&a.selection)
+	if a.reader == nil {
+		return []backend.DocInfo{}
 	}
-	return a.rm_reader.GetCheckedFiles(&a.selection)
+	return a.reader.GetCheckedFiles(&a.selection)
 }
 
 func (a *App) DirectoryDialog() string {
@@ -257,14 +328,51 @@ func (a *App) DirectoryDialog() string {
 	return dir
 }
 
+/* Files that can be uploaded to the tablet */
+var documentFilters = []runtime.FileFilter{
+	{
+		DisplayName: "Documents (*.pdf, *.epub, *.rmdoc)",
+		Pattern:     "*.pdf;*.epub;*.rmdoc",
+	},
+	{
+		DisplayName: "PDF Files",
+		Pattern:     "*.pdf",
+	},
+	{
+		DisplayName: "EPUB Files",
+		Pattern:     "*.epub",
+	},
+	{
+		DisplayName: "reMarkable Documents",
+		Pattern:     "*.rmdoc",
+	},
+}
+
 func (a *App) FileDialog() string {
 	file, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
-		Filters: []runtime.FileFilter{
-			{
-				DisplayName: "PDF Files",
-				Pattern:     "*.pdf",
-			},
-		},
+		Filters: documentFilters,
+	})
+	if err != nil {
+		return ""
+	}
+	return file
+}
+
+func (a *App) FilesDialog() []string {
+	files, err := runtime.OpenMultipleFilesDialog(a.ctx, runtime.OpenDialogOptions{
+		Filters: documentFilters,
+	})
+	if err != nil {
+		return []string{}
+	}
+	return files
+}
+
+func (a *App) KeyFileDialog() string {
+	file, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:                "Select SSH private key",
+		ShowHiddenFiles:      true,
+		CanCreateDirectories: false,
 	})
 	if err != nil {
 		return ""
@@ -272,59 +380,291 @@ func (a *App) FileDialog() string {
 	return file
 }
 
-func (a *App) UploadFileSSH(localPath, fileName, parentId string) (string, error) {
-	runtime.LogInfof(a.ctx, "[APP] UploadFileSSH called: localPath=%s, fileName=%s, parentId=%s", localPath, fileName, parentId)
+/* Uploads a file, the policy ("skip", "replace" or "copy") decides what happens to a duplicate. */
+func (a *App) UploadFileSSH(localPath, fileName, parentId string, policy backend.DuplicatePolicy) (backend.UploadResult, error) {
+	runtime.LogInfof(a.ctx, "[APP] UploadFileSSH called: localPath=%s, fileName=%s, parentId=%s, policy=%s", localPath, fileName, parentId, policy)
 
-	if a.ssh_reader == nil {
+	if a.ssh_conn == nil {
 		runtime.LogError(a.ctx, "[APP] SSH connection not established")
-		return "", fmt.Errorf("SSH connection not established")
+		return backend.UploadResult{}, fmt.Errorf("SSH connection not established")
 	}
 
 	if a.safe_mode {
 		runtime.LogError(a.ctx, "[APP] Upload blocked: safe mode is enabled")
-		return "", fmt.Errorf("upload blocked: safe mode is enabled")
+		return backend.UploadResult{}, fmt.Errorf("upload blocked: safe mode is enabled")
 	}
 
 	runtime.LogInfo(a.ctx, "[APP] Starting file upload...")
-	uuid, err := a.ssh_reader.UploadFile(localPath, fileName, parentId)
+	result, err := a.session.UploadFile(localPath, fileName, parentId, policy)
+	a.emitPendingChanges()
 	if err != nil {
 		runtime.LogErrorf(a.ctx, "[APP] Upload failed: %v", err)
-		return "", err
+		return backend.UploadResult{}, err
 	}
 
-	runtime.LogInfof(a.ctx, "[APP] Upload completed successfully with UUID: %s", uuid)
-	return uuid, nil
+	runtime.LogInfof(a.ctx, "[APP] Upload completed: %s, UUID: %s", result.Action, result.Id)
+	return result, nil
 }
 
-func (a *App) CreateFolderSSH(folderName, parentId string) error {
-	if a.ssh_reader == nil {
-		return fmt.Errorf("SSH connection not established")
+/*
+Puts a document exported as .rmdoc on the tablet. With keepId the document keeps its UUID,
+e.g. to move a notebook between tablets, otherwise it's imported as a copy.
+*/
+func (a *App) ImportRmdocSSH(localPath, parentId string, keepId bool) (string, error) {
+	if a.ssh_conn == nil {
+		return "", fmt.Errorf("SSH connection not established")
 	}
 
 	if a.safe_mode {
-		return fmt.Errorf("folder creation blocked: safe mode is enabled")
+		return "", fmt.Errorf("import blocked: safe mode is enabled")
 	}
 
-	return a.ssh_reader.CreateFolder(folderName, parentId)
+	options := backend.RmdocImportOptions{ParentId: parentId, KeepId: keepId}
+	defer a.emitPendingChanges()
+	return a.session.ImportRmdoc(localPath, options)
 }
 
-func (a *App) RestartXochitlSSH() error {
-	if a.ssh_reader == nil {
+func (a *App) CreateFolderSSH(folderName, parentId string) (string, error) {
+	if a.ssh_conn == nil {
+		return "", fmt.Errorf("SSH connection not established")
+	}
+
+	if a.safe_mode {
+		return "", fmt.Errorf("folder creation blocked: safe mode is enabled")
+	}
+
+	defer a.emitPendingChanges()
+	return a.session.CreateFolder(folderName, parentId)
+}
+
+/*
+Uploads local files and whole folder trees into the parent folder, xochitl is restarted at most once at the end.
+Emits "upload-planned" with all the items, then for every item
+"upload-started", "upload-finished" (with the UploadResult) or "upload-failed" (with the error).
+The policy ("skip", "replace" or "copy") decides what happens to duplicates.
+*/
+func (a *App) UploadBatchSSH(paths []string, parentId string, policy backend.DuplicatePolicy) error {
+	if a.ssh_conn == nil {
 		return fmt.Errorf("SSH connection not established")
 	}
 
 	if a.safe_mode {
-		return fmt.Errorf("restart blocked: safe mode is enabled")
+		return fmt.Errorf("upload blocked: safe mode is enabled")
+	}
+
+	items, err := backend.PlanUpload(paths)
+	if err != nil {
+		return err
+	}
+	runtime.EventsEmit(a.ctx, "upload-planned", items)
+
+	started := func(item backend.UploadItem) {
+		runtime.LogInfof(a.ctx, "[%v] Started upload %v", time.Now().UTC(), item.LocalPath)
+		runtime.EventsEmit(a.ctx, "upload-started", item.LocalPath)
+	}
+
+	finished := func(item backend.UploadItem, result backend.UploadResult) {
+		runtime.LogInfof(a.ctx, "[%v] Finished upload %v, %v, id=%v", time.Now().UTC(), item.LocalPath, result.Action, result.Id)
+		runtime.EventsEmit(a.ctx, "upload-finished", item.LocalPath, result)
+	}
+
+	failed := func(item backend.UploadItem, err error) {
+		runtime.LogInfof(a.ctx, "[%v] Failed upload %v, error: %v", time.Now().UTC(), item.LocalPath, err)
+		runtime.EventsEmit(a.ctx, "upload-failed", item.LocalPath, err.Error())
+	}
+
+	defer a.emitPendingChanges()
+	return a.session.UploadBatch(items, parentId, policy, started, finished, failed)
+}
+
+/* Cancels the upload in progress, the files that were already uploaded stay on the tablet. */
+func (a *App) CancelUploadSSH() {
+	if a.session != nil {
+		a.session.Cancel()
+	}
+}
+
+/* Restarts xochitl so that the tablet shows the pending changes. Returns false if xochitl isn't running. */
+func (a *App) RestartXochitlSSH() (bool, error) {
+	if a.ssh_conn == nil {
+		return false, fmt.Errorf("SSH connection not established")
+	}
+
+	if a.safe_mode {
+		return false, fmt.Errorf("restart blocked: safe mode is enabled")
+	}
+
+	defer a.emitPendingChanges()
+	restarted, err := a.session.Restart()
+	if err == nil && !restarted {
+		runtime.LogWarning(a.ctx, "[APP] xochitl isn't running, it wasn't restarted")
+	}
+	return restarted, err
+}
+
+/* The trash and the changes to the tablet's files are only available when the files are listed over SSH. */
+func (a *App) sshReader() (*backend.SSHReader, error) {
+	reader, ok := a.reader.(*backend.SSHReader)
+	if !ok {
+		return nil, fmt.Errorf("changing the tablet's files is only available when the files are listed over SSH")
+	}
+	return reader, nil
+}
+
+/*
+Runs a change on the tablet's files, then reads the files again; xochitl is restarted according to the policy.
+The change returns how many items it changed, a failure halfway through still shows the first ones.
+*/
+func (a *App) changeTablet(change func(reader *backend.SSHReader) (int, error)) error {
+	if a.safe_mode {
+		return fmt.Errorf("changes blocked: safe mode is enabled")
+	}
+
+	reader, err := a.sshReader()
+	if err != nil {
+		return err
+	}
+
+	count, err := change(reader)
+	if count == 0 {
+		return err
+	}
+
+	changeErr := a.session.Changed(count)
+	a.emitPendingChanges()
+
+	/* The checked files that still exist stay checked */
+	checked := a.selection.GetCheckedItems()
+	readErr := a.setReader(reader, a.transport)
+	if readErr == nil {
+		for _, id := range checked {
+			if _, exists := reader.GetDocById(id); exists {
+				a.selection.Select(id, true)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if changeErr != nil {
+		return changeErr
+	}
+	return readErr
+}
+
+/* Applies the change to the items in order, stopping at the first failure. Returns how many were changed. */
+func eachItem(ids []backend.DocId, change func(id backend.DocId) error) (int, error) {
+	for n, id := range ids {
+		err := change(id)
+		if err != nil {
+			return n, err
+		}
+	}
+	return len(ids), nil
+}
+
+func (a *App) RestoreFromTrash(id backend.DocId) error {
+	runtime.LogInfof(a.ctx, "[APP] Restoring %s from the trash", id)
+	return a.changeTablet(func(reader *backend.SSHReader) (int, error) {
+		return eachItem([]backend.DocId{id}, reader.RestoreFromTrash)
+	})
+}
+
+func (a *App) PurgeFromTrash(id backend.DocId) error {
+	runtime.LogWarningf(a.ctx, "[APP] Permanently removing %s from the trash", id)
+	return a.changeTablet(func(reader *backend.SSHReader) (int, error) {
+		return eachItem([]backend.DocId{id}, reader.PurgeFromTrash)
+	})
+}
+
+func (a *App) RenameItemSSH(id backend.DocId, name string) error {
+	runtime.LogInfof(a.ctx, "[APP] Renaming %s to %q", id, name)
+	return a.changeTablet(func(reader *backend.SSHReader) (int, error) {
+		return eachItem([]backend.DocId{id}, func(id backend.DocId) error {
+			return reader.Rename(id, name)
+		})
+	})
+}
+
+/* Moves the items into the folder, "" for the root. */
+func (a *App) MoveItemsSSH(ids []backend.DocId, parentId backend.DocId) error {
+	runtime.LogInfof(a.ctx, "[APP] Moving %v into %q", ids, parentId)
+	return a.changeTablet(func(reader *backend.SSHReader) (int, error) {
+		return eachItem(ids, func(id backend.DocId) error {
+			return reader.Move(id, parentId)
+		})
+	})
+}
+
+func (a *App) TrashItemsSSH(ids []backend.DocId) error {
+	runtime.LogInfof(a.ctx, "[APP] Moving %v to the trash", ids)
+	return a.changeTablet(func(reader *backend.SSHReader) (int, error) {
+		return eachItem(ids, reader.MoveToTrash)
+	})
+}
+
+/* Permanently removes the items, without going through the trash. */
+func (a *App) DeleteItemsSSH(ids []backend.DocId) error {
+	runtime.LogWarningf(a.ctx, "[APP] Permanently removing %v", ids)
+	return a.changeTablet(func(reader *backend.SSHReader) (int, error) {
+		return eachItem(ids, reader.Delete)
+	})
+}
+
+/* IDs of the checked documents, for the changes made to all of them at once. */
+func (a *App) checkedIds() []backend.DocId {
+	ids := []backend.DocId{}
+	for _, doc := range a.GetCheckedFiles() {
+		ids = append(ids, doc.Id)
+	}
+	return ids
+}
+
+/* Pins the checked documents to the tablet's favorites, or unpins them. */
+func (a *App) SetPinnedSSH(pinned bool) error {
+	ids := a.checkedIds()
+	runtime.LogInfof(a.ctx, "[APP] Setting pinned=%v on %v", pinned, ids)
+	return a.changeTablet(func(reader *backend.SSHReader) (int, error) {
+		return eachItem(ids, func(id backend.DocId) error {
+			return reader.SetPinned(id, pinned)
+		})
+	})
+}
+
+/* Adds the tag to the checked documents, or removes it from them and from their pages. */
+func (a *App) SetTagSSH(tag string, tagged bool) error {
+	ids := a.checkedIds()
+	runtime.LogInfof(a.ctx, "[APP] Setting tag %q=%v on %v", tag, tagged, ids)
+	return a.changeTablet(func(reader *backend.SSHReader) (int, error) {
+		return eachItem(ids, func(id backend.DocId) error {
+			return reader.SetTag(id, tag, tagged)
+		})
+	})
+}
+
+/* Returns the tags of the documents and pages on the tablet, none unless the files are listed over SSH. */
+func (a *App) GetTagsSSH() []string {
+	reader, err := a.sshReader()
+	if err != nil {
+		return []string{}
 	}
+	return reader.GetTags()
+}
 
-	return a.ssh_reader.RestartXochitl()
+/*
+Removes the stored host key of the tablet after the user confirmed that the key change is expected
+(e.g. after a factory reset). The next connection trusts the new key.
+*/
+func (a *App) ForgetHostKey(host string) error {
+	runtime.LogWarningf(a.ctx, "[APP] Forgetting the host key of %s", host)
+	return a.known_hosts.Forget(host)
 }
 
-func (a *App) TestSSHConnection(host, username, password string) error {
-	runtime.LogInfof(a.ctx, "[APP] Testing SSH connection to %s with user %s", host, username)
+func (a *App) TestSSHConnection(host, username string, auth backend.SSHAuthOptions) error {
+	runtime.LogInfof(a.ctx, "[APP] Testing SSH connection to %s with user %s (auth: %s)", host, username, auth.Method)
 
 	// Create a temporary SSH connection for testing
-	testConn := backend.NewSSHConnection(host, username, password, a.ctx)
+	testConn := backend.NewSSHConnection(host, username, auth, a.known_hosts, a.ctx)
 
 	// Test SSH connection
 	err := testConn.Connect()
@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"slices"
+	"strings"
+)
+
+/*
+Reads the documents and folders from the tablet.
+Implemented for every way of talking to the tablet (USB web interface, SSH).
+*/
+type TabletReader interface {
+	/* Reads all the items from the tablet and stores them.
+	   Past items are cleared in case Read() was called previously. */
+	Read() error
+
+	GetFolder(id DocId) []DocInfo
+	GetDocById(id DocId) (DocInfo, bool)
+	GetChildrenMap() map[DocId][]DocInfo
+	/* Includes path for every checked file */
+	GetCheckedFiles(selection *FileSelection) []DocInfo
+}
+
+/* Items of the tablet indexed by ID and by parent, shared by the readers. */
+type docTree struct {
+	/* For an collection with DocId 'id', map[id] stores elements in that folder.
+	   For a root element the 'id' is empty
+	*/
+	children map[DocId][]DocInfo
+
+	docById map[DocId]DocInfo
+}
+
+func newDocTree(docs []DocInfo) docTree {
+	t := docTree{
+		children: make(map[DocId][]DocInfo),
+		docById:  make(map[DocId]DocInfo),
+	}
+
+	for _, doc := range docs {
+		t.children[doc.ParentId] = append(t.children[doc.ParentId], doc)
+		t.docById[doc.Id] = doc
+	}
+
+	return t
+}
+
+func (t *docTree) GetFolder(id DocId) []DocInfo {
+	if items, ok := t.children[id]; ok {
+		return items
+	}
+	return []DocInfo{}
+}
+
+func (t *docTree) GetDocById(id DocId) (DocInfo, bool) {
+	doc, exists := t.docById[id]
+	return doc, exists
+}
+
+func (t *docTree) GetChildrenMap() map[DocId][]DocInfo {
+	return t.children
+}
+
+func (t *docTree) GetCheckedFiles(selection *FileSelection) []DocInfo {
+	ids := selection.GetCheckedItems()
+	files := t.getElementsByIds(ids)
+	t.fillPaths(files)
+
+	slices.SortFunc(files, func(i, j DocInfo) int {
+		c := strings.Compare(*i.DisplayPath, *j.DisplayPath)
+		if c != 0 {
+			return c
+		}
+		return strings.Compare(i.Id, j.Id)
+	})
+	return files
+}
+
+/* Returns the IDs of the item and everything inside it, children before their parents. */
+func (t *docTree) getSubtree(id DocId) []DocId {
+	result := []DocId{}
+	for _, child := range t.children[id] {
+		result = append(result, t.getSubtree(child.Id)...)
+	}
+	return append(result, id)
+}
+
+func (t *docTree) getElementsByIds(ids []DocId) []DocInfo {
+	result := []DocInfo{}
+	for _, id := range ids {
+		if doc, exists := t.docById[id]; exists {
+			result = append(result, doc)
+		}
+	}
+	return result
+}
+
+func (t *docTree) fillPaths(items []DocInfo) {
+	for i, item := range items {
+		p := t.getDisplayPath(item)
+		items[i].DisplayPath = &p
+		items[i].TabletPath = t.getTabletPath(item)
+	}
+}
+
+func (t *docTree) getTabletPath(item DocInfo) []string {
+	id := item.Id
+
+	l := []string{}
+	for id != "" {
+		item, exists := t.docById[id]
+		if !exists {
+			break
+		}
+		l = append(l, item.Name)
+		id = item.ParentId
+	}
+	slices.Reverse(l)
+
+	return l
+}
+
+func (t *docTree) getDisplayPath(item DocInfo) string {
+	tabletPath := t.getTabletPath(item)
+	for i, x := range tabletPath {
+		if strings.Contains(x, "/") {
+			tabletPath[i] = "'" + x + "'"
+		}
+	}
+
+	return strings.Join(tabletPath, "/")
+}
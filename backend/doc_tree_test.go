@@ -0,0 +1,48 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDocTreeCheckedFilesHavePaths(t *testing.T) {
+	tree := newDocTree([]DocInfo{
+		{Id: "dir1", ParentId: "", IsFolder: true, Name: "a/b"},
+		{Id: "f1", ParentId: "dir1", Name: "file1"},
+		{Id: "f2", ParentId: "", Name: "file2"},
+		{Id: "orphan", ParentId: "missing", Name: "orphan"},
+	})
+
+	selection := NewFileSelection(tree.GetChildrenMap())
+	selection.Select("", true)
+
+	files := tree.GetCheckedFiles(&selection)
+	got := [][]string{}
+	display := []string{}
+	for _, f := range files {
+		got = append(got, f.TabletPath)
+		display = append(display, *f.DisplayPath)
+	}
+
+	if diff := cmp.Diff([][]string{{"a/b", "file1"}, {"file2"}}, got); diff != "" {
+		t.Errorf("Tablet paths mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"'a/b'/file1", "file2"}, display); diff != "" {
+		t.Errorf("Display paths mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDocTreeUnknownFolder(t *testing.T) {
+	tree := newDocTree(nil)
+	if items := tree.GetFolder("nothing"); items == nil || len(items) != 0 {
+		t.Errorf("Expected an empty folder, got %v", items)
+	}
+	if _, ok := tree.GetDocById("nothing"); ok {
+		t.Errorf("Expected no document")
+	}
+}
+
+/* Both readers have to satisfy the interface */
+var _ TabletReader = (*RmReader)(nil)
+var _ TabletReader = (*SSHReader)(nil)
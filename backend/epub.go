@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+)
+
+const epubMimeType = "application/epub+zip"
+
+/*
+Returns an error unless the file is an EPUB container:
+a zip archive whose first entry is an uncompressed "mimetype" file with "application/epub+zip".
+xochitl can't open anything else and would show a broken document.
+*/
+func validateEpub(path string) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("not an EPUB file, it isn't a zip archive: %v", err)
+	}
+	defer archive.Close()
+
+	if len(archive.File) == 0 || archive.File[0].Name != "mimetype" {
+		return fmt.Errorf("not an EPUB file, the archive doesn't start with a mimetype entry")
+	}
+
+	mimetype := archive.File[0]
+	if mimetype.Method != zip.Store {
+		return fmt.Errorf("not an EPUB file, the mimetype entry is compressed")
+	}
+
+	r, err := mimetype.Open()
+	if err != nil {
+		return fmt.Errorf("not an EPUB file, failed to read the mimetype: %v", err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(io.LimitReader(r, 64))
+	if err != nil {
+		return fmt.Errorf("not an EPUB file, failed to read the mimetype: %v", err)
+	}
+	if string(data) != epubMimeType {
+		return fmt.Errorf("not an EPUB file, the mimetype is %q", data)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package backend
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type zipEntry struct {
+	name   string
+	data   string
+	method uint16
+}
+
+func writeZip(t *testing.T, entries []zipEntry) string {
+	path := filepath.Join(t.TempDir(), "book.epub")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for _, e := range entries {
+		entry, err := w.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(e.data))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateEpub(t *testing.T) {
+	container := zipEntry{"META-INF/container.xml", "<container/>", zip.Deflate}
+
+	valid := writeZip(t, []zipEntry{{"mimetype", epubMimeType, zip.Store}, container})
+	if err := validateEpub(valid); err != nil {
+		t.Errorf("valid EPUB is rejected: %v", err)
+	}
+
+	invalid := map[string]string{
+		"compressed mimetype": writeZip(t, []zipEntry{{"mimetype", epubMimeType, zip.Deflate}, container}),
+		"wrong mimetype":      writeZip(t, []zipEntry{{"mimetype", "application/zip", zip.Store}, container}),
+		"mimetype not first":  writeZip(t, []zipEntry{container, {"mimetype", epubMimeType, zip.Store}}),
+		"empty archive":       writeZip(t, nil),
+	}
+
+	notZip := filepath.Join(t.TempDir(), "book.epub")
+	os.WriteFile(notZip, []byte("%PDF-1.7"), 0644)
+	invalid["not a zip"] = notZip
+
+	for name, path := range invalid {
+		if err := validateEpub(path); err == nil {
+			t.Errorf("%s: invalid EPUB is accepted", name)
+		}
+	}
+}
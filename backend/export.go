@@ -0,0 +1,163 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+type RmExportOptions struct {
+	Pdf      bool
+	Rmdoc    bool
+	Location string // path to the folder to export
+}
+
+/* Exports the checked items to the local disk. */
+type Exporter interface {
+	/* Calls the callbacks when an item started downloading, has finished or has failed. */
+	Export(started, finished func(item DocInfo), failed func(item DocInfo, err error))
+}
+
+/*
+Downloads a single item from the tablet (USB web interface, SSH).
+The export engine decides where the files go, the transport only fetches them.
+*/
+type ExportTransport interface {
+	/* Downloads the item in the format ("pdf" or "rmdoc") to a local file at dest, whose directory exists.
+	   Returns ErrFormatUnavailable if the tablet can't provide the item in the format. */
+	Download(item DocInfo, format string, dest string) error
+}
+
+/* The item can't be exported in the requested format, it's skipped instead of failing the export. */
+var ErrFormatUnavailable = errors.New("format is not available")
+
+type RmExport struct {
+	Options RmExportOptions
+
+	items       []DocInfo
+	export_from int // index of the first item to be exported
+
+	transport          ExportTransport
+	wrappingFolderName string
+	ctx                context.Context
+	paths              Paths
+	destinations       map[string]string // local path by item ID and format, reused on retries
+}
+
+func InitExport(ctx context.Context, options RmExportOptions, items []DocInfo, transport ExportTransport) *RmExport {
+	t := time.Now().Format(time.DateTime)
+	folderName := "rM Export (" + t + ")"
+
+	return &RmExport{
+		Options:            options,
+		items:              items,
+		export_from:        0,
+		transport:          transport,
+		wrappingFolderName: folderName,
+		ctx:                ctx,
+		paths:              initPaths(),
+		destinations:       map[string]string{},
+	}
+}
+
+/*
+Exports all items passed in Init() method.
+Calls the callbacks when:
+* item started downloading;
+* item download has finished;
+* item download has failed.
+
+Supports retries.
+In case the last export succeeded on all items, it starts the export again from the first item;
+otherwise, the export starts from the first failed item.
+*/
+func (r *RmExport) Export(started, finished func(item DocInfo), failed func(item DocInfo, err error)) {
+	formats := []string{}
+	if r.Options.Rmdoc {
+		formats = append(formats, "rmdoc")
+	}
+	if r.Options.Pdf {
+		formats = append(formats, "pdf")
+	}
+
+	runtime.LogInfof(r.ctx, "[%v] Export formats: %v", time.Now().UTC(), formats)
+	runtime.LogInfof(r.ctx, "[%v] In export location, using a wrapper folder with a name: %v", time.Now().UTC(), r.wrappingFolderName)
+
+	for i := r.export_from; i < len(r.items); i++ {
+		item := r.items[i]
+		started(item)
+
+		for _, format := range formats {
+			err := r.exportOne(item, format)
+			if err != nil {
+				r.export_from = i
+				failed(item, err)
+				return
+			}
+		}
+
+		finished(item)
+	}
+	r.export_from = 0
+}
+
+func (r *RmExport) exportOne(item DocInfo, format string) error {
+	if item.IsFolder {
+		return nil
+	}
+	runtime.LogInfof(r.ctx, "[%v] downloading an item, id=%v", time.Now().UTC(), item.Id)
+
+	path, err := r.createDir(item, format)
+	if err != nil {
+		return err
+	}
+
+	err = r.transport.Download(item, format, path)
+	if errors.Is(err, ErrFormatUnavailable) {
+		runtime.LogWarningf(r.ctx, "[%v] skipping an item, id=%v (%v)", time.Now().UTC(), item.Id, err)
+		return nil
+	}
+	if err != nil {
+		/* Don't leave a partially downloaded file behind */
+		os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+/*
+Returns a unique local path for the item and creates its directory.
+A retried item gets the same path, so that it doesn't end up with a "-1" suffix.
+*/
+func (r *RmExport) createDir(item DocInfo, format string) (string, error) {
+	key := item.Id + "." + format
+	path, ok := r.destinations[key]
+	if !ok {
+		var err error
+		path, err = r.paths.getFilePathUnique(r.Options.Location, r.wrappingFolderName, item.TabletPath, format)
+		if err != nil {
+			return "", fmt.Errorf("failed to find a path, id=%v, (%v)", item.Id, err.Error())
+		}
+		r.destinations[key] = path
+	}
+
+	runtime.LogDebugf(r.ctx, "[%v] exporting to path %v, id=%v", time.Now().UTC(), path, item.Id)
+
+	path = filepath.FromSlash(path)
+	dir, _ := filepath.Split(path)
+
+	/* Permission 0755: The owner can read, write, execute.
+	   Everyone else can read and execute but not modify the file.*/
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
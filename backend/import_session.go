@@ -0,0 +1,245 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+/* When xochitl is restarted to show the changes made over SSH. */
+type RestartPolicy string
+
+const (
+	RestartNow   RestartPolicy = "now"   // after every change
+	RestartLater RestartPolicy = "later" // when the user asks for it, or when the session ends
+	RestartNever RestartPolicy = "never" // only when the user asks for it
+)
+
+/* Returns an error for a policy other than "now", "later" and "never". */
+func ValidateRestartPolicy(policy RestartPolicy) error {
+	switch policy {
+	case RestartNow, RestartLater, RestartNever:
+		return nil
+	}
+	return fmt.Errorf("unknown restart policy: %q", policy)
+}
+
+/* The xochitl service of the tablet, SSHConnection in the app. */
+type xochitlService interface {
+	XochitlState() (string, error)
+	RestartXochitl() error
+}
+
+/*
+Groups the changes made to the tablet over SSH, so that xochitl picks them up with a single restart.
+xochitl only reads its files when it starts, but a restart closes whatever the user has open on the tablet,
+so the policy decides when it happens.
+*/
+type ImportSession struct {
+	importer *SSHImporter
+	service  xochitlService
+
+	mu      sync.Mutex
+	policy  RestartPolicy
+	pending int                // changes xochitl doesn't show yet
+	cancel  context.CancelFunc // cancels the import in progress, nil if there is none
+}
+
+func NewImportSession(connection *SSHConnection, policy RestartPolicy) (*ImportSession, error) {
+	if err := ValidateRestartPolicy(policy); err != nil {
+		return nil, err
+	}
+	return &ImportSession{importer: NewSSHImporter(connection), service: connection, policy: policy}, nil
+}
+
+func (s *ImportSession) Policy() RestartPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy
+}
+
+/* Changes the policy, switching to "now" restarts xochitl for the pending changes. */
+func (s *ImportSession) SetPolicy(policy RestartPolicy) error {
+	if err := ValidateRestartPolicy(policy); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.policy = policy
+	s.mu.Unlock()
+	return s.Changed(0)
+}
+
+/* Returns the number of changes that xochitl doesn't show until it's restarted. */
+func (s *ImportSession) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+/* Records changes made to the tablet's files and restarts xochitl if the policy says so. */
+func (s *ImportSession) Changed(count int) error {
+	s.mu.Lock()
+	s.pending += count
+	restart := s.policy == RestartNow && s.pending > 0
+	s.mu.Unlock()
+
+	if !restart {
+		return nil
+	}
+	_, err := s.Restart()
+	return err
+}
+
+/*
+Restarts xochitl so that it shows the pending changes. It isn't started if it's not running:
+it reads the files anyway when it starts, and another launcher may have stopped it on purpose.
+Returns false if xochitl wasn't restarted for this reason.
+*/
+func (s *ImportSession) Restart() (bool, error) {
+	/* The remote commands run without the lock, the changes made meanwhile stay pending */
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = 0
+	s.mu.Unlock()
+
+	restarted, err := s.restart()
+	if err != nil {
+		s.mu.Lock()
+		s.pending += pending
+		s.mu.Unlock()
+	}
+	return restarted, err
+}
+
+func (s *ImportSession) restart() (bool, error) {
+	state, err := s.service.XochitlState()
+	if err != nil {
+		return false, err
+	}
+
+	/* "activating" and "reloading" are about to be "active" */
+	if state != "active" && state != "activating" && state != "reloading" {
+		return false, nil
+	}
+
+	err = s.service.RestartXochitl()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+/*
+Records the changes of an import that succeeded. A failed restart doesn't fail the import,
+the changes stay pending until xochitl is restarted.
+*/
+func (s *ImportSession) imported(count int) {
+	if err := s.Changed(count); err != nil {
+		s.importer.logWarningf("[SSH_IMPORT] Failed to restart xochitl, %d changes are pending: %v", s.Pending(), err)
+	}
+}
+
+/* Ends the session: with the "later" policy, restarts xochitl for the pending changes. */
+func (s *ImportSession) Close() error {
+	if s.Policy() != RestartLater || s.Pending() == 0 {
+		return nil
+	}
+	_, err := s.Restart()
+	return err
+}
+
+/* Returns the context of a new import, which Cancel() cancels, and the function that ends the import. */
+func (s *ImportSession) begin() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	return ctx, func() {
+		cancel()
+		s.mu.Lock()
+		s.cancel = nil
+		s.mu.Unlock()
+	}
+}
+
+/*
+Cancels the import in progress. The staged files of the current item are removed,
+the items that were already imported stay on the tablet.
+*/
+func (s *ImportSession) Cancel() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+/* See SSHImporter.uploadFile */
+func (s *ImportSession) UploadFile(localPath, fileName, parentId string, policy DuplicatePolicy) (UploadResult, error) {
+	ctx, end := s.begin()
+	defer end()
+
+	index, err := s.importer.loadIndex()
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	result, err := s.importer.uploadFile(ctx, localPath, fileName, parentId, policy, index)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	if result.Action != UploadSkipped {
+		s.imported(1)
+	}
+	return result, nil
+}
+
+/* Puts a document from an .rmdoc archive on the tablet and returns its ID. */
+func (s *ImportSession) ImportRmdoc(localPath string, options RmdocImportOptions) (string, error) {
+	ctx, end := s.begin()
+	defer end()
+
+	id, err := s.importer.importRmdoc(ctx, localPath, options)
+	if err != nil {
+		return "", err
+	}
+	s.imported(1)
+	return id, nil
+}
+
+func (s *ImportSession) CreateFolder(folderName, parentId string) (string, error) {
+	ctx, end := s.begin()
+	defer end()
+
+	id, err := s.importer.createFolder(ctx, folderName, parentId)
+	if err != nil {
+		return "", err
+	}
+	s.imported(1)
+	return id, nil
+}
+
+/* See SSHImporter.UploadBatch, xochitl is restarted at most once at the end. */
+func (s *ImportSession) UploadBatch(items []UploadItem, parentId string, policy DuplicatePolicy,
+	started func(item UploadItem), finished func(item UploadItem, result UploadResult), failed func(item UploadItem, err error)) error {
+	ctx, end := s.begin()
+	defer end()
+
+	changed := 0
+	count := func(item UploadItem, result UploadResult) {
+		if result.Action != UploadSkipped {
+			changed++
+		}
+		finished(item, result)
+	}
+
+	err := s.importer.UploadBatch(ctx, items, parentId, policy, started, count, failed)
+
+	/* Whatever was uploaded before an error still has to show up */
+	s.imported(changed)
+	return err
+}
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeXochitl struct {
+	state     string
+	restarts  int
+	err       error
+	onRestart func() // called while xochitl restarts
+}
+
+func (f *fakeXochitl) XochitlState() (string, error) {
+	return f.state, nil
+}
+
+func (f *fakeXochitl) RestartXochitl() error {
+	if f.onRestart != nil {
+		f.onRestart()
+	}
+	if f.err != nil {
+		return f.err
+	}
+	f.restarts++
+	return nil
+}
+
+func TestImportSessionRestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy          RestartPolicy
+		restartsChanged int // after two changes and an unchanged upload
+		restartsClosed  int
+	}{
+		{RestartNow, 2, 2},
+		{RestartLater, 0, 1},
+		{RestartNever, 0, 0},
+	}
+
+	for _, test := range tests {
+		service := &fakeXochitl{state: "active"}
+		session := &ImportSession{service: service, policy: test.policy}
+
+		for _, count := range []int{1, 0, 2} {
+			if err := session.Changed(count); err != nil {
+				t.Fatalf("%s: %v", test.policy, err)
+			}
+		}
+		if service.restarts != test.restartsChanged {
+			t.Errorf("%s: expected %d restarts, got %d", test.policy, test.restartsChanged, service.restarts)
+		}
+
+		if err := session.Close(); err != nil {
+			t.Fatalf("%s: %v", test.policy, err)
+		}
+		if service.restarts != test.restartsClosed {
+			t.Errorf("%s: expected %d restarts after closing, got %d", test.policy, test.restartsClosed, service.restarts)
+		}
+	}
+}
+
+func TestImportSessionRestart(t *testing.T) {
+	service := &fakeXochitl{state: "inactive"}
+	session := &ImportSession{service: service, policy: RestartNever}
+	session.Changed(3)
+
+	/* Another launcher may have stopped xochitl, it isn't started */
+	restarted, err := session.Restart()
+	if err != nil || restarted || service.restarts != 0 || session.Pending() != 0 {
+		t.Errorf("xochitl was restarted while it isn't running: %v, %v, %d", restarted, err, service.restarts)
+	}
+
+	/* A failed restart keeps the changes pending */
+	service.state = "active"
+	service.err = errors.New("failed")
+	session.Changed(1)
+	if _, err := session.Restart(); err == nil || session.Pending() != 1 {
+		t.Errorf("expected a failure with a pending change, got %v, %d", err, session.Pending())
+	}
+
+	/* Switching to "now" shows the pending changes */
+	service.err = nil
+	if err := session.SetPolicy(RestartNow); err != nil {
+		t.Fatal(err)
+	}
+	if service.restarts != 1 || session.Pending() != 0 {
+		t.Errorf("pending changes weren't restarted: %d, %d", service.restarts, session.Pending())
+	}
+	if session.SetPolicy("sometimes") == nil {
+		t.Errorf("unknown policy was accepted")
+	}
+}
+
+func TestImportSessionRestartFailed(t *testing.T) {
+	service := &fakeXochitl{state: "active", err: errors.New("failed")}
+	target := &fakeImportTarget{fakeStagingTarget: newFakeStagingTarget(map[string]string{})}
+	session := &ImportSession{importer: NewSSHImporter(target), service: service, policy: RestartNow}
+
+	/* The folder is on the tablet, only the restart failed */
+	id, err := session.CreateFolder("Books", "")
+	if err != nil || id == "" || target.files[xochitlPath(id+".metadata")] == "" {
+		t.Fatalf("the folder creation failed with the restart: %q, %v", id, err)
+	}
+	if session.Pending() != 1 {
+		t.Errorf("expected a pending change, got %d", session.Pending())
+	}
+}
+
+func TestImportSessionRestartUnlocked(t *testing.T) {
+	service := &fakeXochitl{state: "active"}
+	session := &ImportSession{service: service, policy: RestartNever}
+	session.Changed(2)
+
+	/* The session answers while the remote commands run, a change made meanwhile stays pending */
+	service.onRestart = func() {
+		done := make(chan struct{})
+		go func() {
+			session.Cancel()
+			session.Policy()
+			session.Changed(1)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("the session is locked during the restart")
+		}
+	}
+	if _, err := session.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if session.Pending() != 1 {
+		t.Errorf("expected the change made during the restart, got %d pending", session.Pending())
+	}
+}
@@ -0,0 +1,896 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"unicode/utf16"
+)
+
+/*
+Reads what the importer needs to know about a PDF: the pages and the document info.
+
+This isn't a full PDF parser, it only understands the object structure of the file
+(cross-reference tables and streams, object streams, the page tree and the info dictionary).
+Page contents, fonts and images are never read.
+*/
+
+/* What the tablet shows about a PDF, read from the file itself. */
+type PdfInfo struct {
+	PageSizes []PdfPageSize // in points, with the page rotation applied
+	Title     string
+	Author    string
+}
+
+type PdfPageSize struct {
+	Width  float64
+	Height float64
+}
+
+func (i *PdfInfo) PageCount() int {
+	return len(i.PageSizes)
+}
+
+/* The file isn't a PDF at all, as opposed to a PDF this parser doesn't understand. */
+var errNotPdf = errors.New("not a PDF file")
+
+/* Reads the page sizes and the document info of a local PDF file. */
+func ReadPdfInfo(path string) (*PdfInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	pdf, err := openPdf(f, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	return pdf.info()
+}
+
+/* Objects of the file, as returned by the parser */
+type pdfName string
+type pdfString []byte
+type pdfArray []any
+type pdfDict map[pdfName]any
+type pdfRef struct {
+	num, gen int
+}
+type pdfStream struct {
+	dict   pdfDict
+	offset int64 // of the stream data in the file
+}
+
+type pdfKeyword string
+
+/* Limit for the nesting of arrays and dictionaries, and for chains of references */
+const pdfMaxDepth = 64
+
+/* Where to find an object: at an offset of the file, or inside an object stream. */
+type pdfXrefEntry struct {
+	offset    int64
+	streamNum int // object stream that contains the object, 0 if it's at the offset
+	index     int // index of the object in the object stream
+}
+
+type pdfFile struct {
+	r    io.ReaderAt
+	size int64
+
+	xref    map[int]pdfXrefEntry
+	trailer pdfDict
+
+	objectStreams map[int]map[int]any // parsed object streams: objects by their numbers
+	encrypted     bool
+
+	startxref int64 // offset of the last cross-reference section
+	repaired  bool  // the objects were found by scanning the file, startxref is wrong
+}
+
+func openPdf(r io.ReaderAt, size int64) (*pdfFile, error) {
+	header := make([]byte, min(1024, size))
+	_, err := r.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.Contains(header, []byte("%PDF-")) {
+		return nil, errNotPdf
+	}
+
+	pdf := &pdfFile{
+		r:             r,
+		size:          size,
+		xref:          map[int]pdfXrefEntry{},
+		objectStreams: map[int]map[int]any{},
+	}
+
+	err = pdf.loadXref()
+	if err != nil || !pdf.hasCatalog() {
+		/* Damaged or incrementally updated files with wrong offsets are common, the tablet opens them anyway */
+		pdf.xref = map[int]pdfXrefEntry{}
+		pdf.trailer = nil
+		pdf.repaired = true
+		err = pdf.reconstructXref()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, pdf.encrypted = pdf.trailer["Encrypt"]
+	return pdf, nil
+}
+
+func (p *pdfFile) hasCatalog() bool {
+	_, ok := p.resolve(p.trailer["Root"]).(pdfDict)
+	return ok
+}
+
+/* Follows "startxref" at the end of the file and all the previous cross-reference sections. */
+func (p *pdfFile) loadXref() error {
+	tailSize := min(4096, p.size)
+	tail := make([]byte, tailSize)
+	_, err := p.r.ReadAt(tail, p.size-tailSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	i := bytes.LastIndex(tail, []byte("startxref"))
+	if i == -1 {
+		return fmt.Errorf("startxref not found")
+	}
+	fields := bytes.Fields(tail[i+len("startxref"):])
+	if len(fields) == 0 {
+		return fmt.Errorf("startxref has no offset")
+	}
+	offset, err := strconv.ParseInt(string(fields[0]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid startxref offset: %v", err)
+	}
+	p.startxref = offset
+
+	visited := map[int64]bool{}
+	for offset > 0 && !visited[offset] {
+		visited[offset] = true
+
+		trailer, err := p.loadXrefSection(offset)
+		if err != nil {
+			return err
+		}
+		if p.trailer == nil {
+			p.trailer = trailer
+		}
+
+		/* Hybrid files have the objects of the object streams in a separate xref stream */
+		if stm, ok := trailer["XRefStm"].(int64); ok && !visited[stm] {
+			visited[stm] = true
+			_, err := p.loadXrefSection(stm)
+			if err != nil {
+				return err
+			}
+		}
+
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			break
+		}
+		offset = prev
+	}
+
+	if p.trailer == nil {
+		return fmt.Errorf("trailer not found")
+	}
+	return nil
+}
+
+/* Reads an xref table with its trailer or an xref stream, entries that are already known are newer. */
+func (p *pdfFile) loadXrefSection(offset int64) (pdfDict, error) {
+	lex := p.lexerAt(offset)
+	tok, err := lex.token()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok == pdfKeyword("xref") {
+		return p.loadXrefTable(lex)
+	}
+
+	lex.unread(tok)
+	_, obj, err := lex.indirectObject()
+	if err != nil {
+		return nil, fmt.Errorf("invalid xref at %d: %v", offset, err)
+	}
+	stream, ok := obj.(*pdfStream)
+	if !ok || stream.dict["Type"] != pdfName("XRef") {
+		return nil, fmt.Errorf("invalid xref at %d", offset)
+	}
+	return stream.dict, p.loadXrefStream(stream)
+}
+
+func (p *pdfFile) loadXrefTable(lex *pdfLexer) (pdfDict, error) {
+	for {
+		tok, err := lex.token()
+		if err != nil {
+			return nil, err
+		}
+		if tok == pdfKeyword("trailer") {
+			obj, err := lex.object(0)
+			if err != nil {
+				return nil, err
+			}
+			trailer, ok := obj.(pdfDict)
+			if !ok {
+				return nil, fmt.Errorf("invalid trailer")
+			}
+			return trailer, nil
+		}
+
+		start, ok := tok.(int64)
+		if !ok {
+			return nil, fmt.Errorf("invalid xref subsection")
+		}
+		count, err := lex.int()
+		if err != nil {
+			return nil, err
+		}
+
+		for num := int(start); num < int(start+count); num++ {
+			offset, err := lex.int()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := lex.int(); err != nil {
+				return nil, err
+			}
+			kind, err := lex.token()
+			if err != nil {
+				return nil, err
+			}
+
+			if _, known := p.xref[num]; known || kind != pdfKeyword("n") {
+				continue
+			}
+			p.xref[num] = pdfXrefEntry{offset: offset}
+		}
+	}
+}
+
+func (p *pdfFile) loadXrefStream(stream *pdfStream) error {
+	data, err := p.streamData(stream)
+	if err != nil {
+		return err
+	}
+
+	widths, ok := p.resolve(stream.dict["W"]).(pdfArray)
+	if !ok || len(widths) != 3 {
+		return fmt.Errorf("invalid xref stream widths")
+	}
+	w := [3]int{}
+	for i := range w {
+		n, ok := widths[i].(int64)
+		if !ok || n < 0 || n > 8 {
+			return fmt.Errorf("invalid xref stream widths")
+		}
+		w[i] = int(n)
+	}
+
+	size, _ := stream.dict["Size"].(int64)
+	index := pdfArray{int64(0), size}
+	if i, ok := p.resolve(stream.dict["Index"]).(pdfArray); ok {
+		index = i
+	}
+
+	entrySize := w[0] + w[1] + w[2]
+	if entrySize == 0 {
+		return fmt.Errorf("invalid xref stream widths")
+	}
+
+	field := func(data []byte, width int, def int64) int64 {
+		if width == 0 {
+			return def
+		}
+		var v int64
+		for _, b := range data[:width] {
+			v = v<<8 | int64(b)
+		}
+		return v
+	}
+
+	for i := 0; i+1 < len(index); i += 2 {
+		start, _ := index[i].(int64)
+		count, _ := index[i+1].(int64)
+		for num := int(start); num < int(start+count); num++ {
+			if len(data) < entrySize {
+				return fmt.Errorf("xref stream is too short")
+			}
+			entry := data[:entrySize]
+			data = data[entrySize:]
+
+			kind := field(entry, w[0], 1)
+			second := field(entry[w[0]:], w[1], 0)
+			third := field(entry[w[0]+w[1]:], w[2], 0)
+
+			if _, known := p.xref[num]; known {
+				continue
+			}
+			switch kind {
+			case 1:
+				p.xref[num] = pdfXrefEntry{offset: second}
+			case 2:
+				p.xref[num] = pdfXrefEntry{streamNum: int(second), index: int(third)}
+			}
+		}
+	}
+	return nil
+}
+
+var pdfObjectHeader = regexp.MustCompile(`(?:^|[^0-9])(\d{1,10})[ \t\r\n\f\x00]+(\d{1,5})[ \t\r\n\f\x00]+obj\b`)
+
+/*
+Finds the objects by scanning the whole file for "<num> <gen> obj", when the cross-reference
+information is missing or wrong. The last definition of an object wins, like in an update.
+*/
+func (p *pdfFile) reconstructXref() error {
+	const chunkSize = 1 << 20
+	const overlap = 32
+
+	buf := make([]byte, chunkSize+overlap)
+	for start := int64(0); start < p.size; start += chunkSize {
+		n, err := p.r.ReadAt(buf, start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		chunk := buf[:n]
+
+		for _, m := range pdfObjectHeader.FindAllSubmatchIndex(chunk, -1) {
+			/* Matches in the overlap are found again in the next chunk */
+			if m[2] >= chunkSize {
+				continue
+			}
+			num, _ := strconv.Atoi(string(chunk[m[2]:m[3]]))
+			p.xref[num] = pdfXrefEntry{offset: start + int64(m[2])}
+		}
+	}
+
+	/* Objects inside object streams, unless they are defined directly */
+	nums := []int{}
+	for num := range p.xref {
+		nums = append(nums, num)
+	}
+	slices.Sort(nums)
+
+	for _, num := range nums {
+		stream, ok := p.object(num).(*pdfStream)
+		if !ok {
+			continue
+		}
+
+		switch stream.dict["Type"] {
+		case pdfName("ObjStm"):
+			objects, err := p.parseObjectStream(num, stream)
+			if err != nil {
+				continue
+			}
+			for i, n := range objects.order {
+				if _, known := p.xref[n]; !known {
+					p.xref[n] = pdfXrefEntry{streamNum: num, index: i}
+				}
+			}
+		case pdfName("XRef"):
+			if _, ok := stream.dict["Root"]; ok {
+				p.trailer = stream.dict
+			}
+		}
+	}
+
+	/* The last trailer dictionary of the file is the newest one */
+	if p.trailer == nil {
+		p.trailer = p.findLastTrailer()
+	}
+
+	if !p.hasCatalog() {
+		for _, num := range nums {
+			dict, ok := p.object(num).(pdfDict)
+			if ok && dict["Type"] == pdfName("Catalog") {
+				p.trailer = pdfDict{"Root": pdfRef{num, 0}}
+				break
+			}
+		}
+	}
+
+	if !p.hasCatalog() {
+		return fmt.Errorf("PDF document catalog not found")
+	}
+	return nil
+}
+
+func (p *pdfFile) findLastTrailer() pdfDict {
+	const chunkSize = 1 << 20
+
+	var last pdfDict
+	buf := make([]byte, chunkSize+len("trailer"))
+	for start := int64(0); start < p.size; start += chunkSize {
+		n, err := p.r.ReadAt(buf, start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return last
+		}
+
+		chunk := buf[:n]
+		for i := 0; ; {
+			j := bytes.Index(chunk[i:], []byte("trailer"))
+			if j == -1 || i+j >= chunkSize {
+				break
+			}
+			offset := start + int64(i+j+len("trailer"))
+			i += j + 1
+
+			obj, err := p.lexerAt(offset).object(0)
+			if dict, ok := obj.(pdfDict); err == nil && ok && dict["Root"] != nil {
+				last = dict
+			}
+		}
+	}
+	return last
+}
+
+func (p *pdfFile) lexerAt(offset int64) *pdfLexer {
+	r := io.NewSectionReader(p.r, offset, p.size-offset)
+	return &pdfLexer{r: bufio.NewReader(r), pos: offset}
+}
+
+/* Returns the object with the number, or nil if it doesn't exist or can't be read. */
+func (p *pdfFile) object(num int) any {
+	entry, ok := p.xref[num]
+	if !ok {
+		return nil
+	}
+
+	if entry.streamNum != 0 {
+		objects, ok := p.objectStreams[entry.streamNum]
+		if !ok {
+			stream, isStream := p.object(entry.streamNum).(*pdfStream)
+			if !isStream || entry.streamNum == num {
+				return nil
+			}
+			parsed, err := p.parseObjectStream(entry.streamNum, stream)
+			if err != nil {
+				p.objectStreams[entry.streamNum] = map[int]any{}
+				return nil
+			}
+			objects = parsed.objects
+			p.objectStreams[entry.streamNum] = objects
+		}
+		return objects[num]
+	}
+
+	lex := p.lexerAt(entry.offset)
+	objNum, obj, err := lex.indirectObject()
+	if err != nil || objNum != num {
+		return nil
+	}
+	return obj
+}
+
+/* Follows the references until a direct object. */
+func (p *pdfFile) resolve(obj any) any {
+	for range pdfMaxDepth {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = p.object(ref.num)
+	}
+	return nil
+}
+
+type pdfObjectStream struct {
+	objects map[int]any
+	order   []int // object numbers in the order of the stream
+}
+
+func (p *pdfFile) parseObjectStream(num int, stream *pdfStream) (*pdfObjectStream, error) {
+	if p.encrypted {
+		return nil, fmt.Errorf("object stream %d is encrypted", num)
+	}
+
+	data, err := p.streamData(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	n, _ := p.resolve(stream.dict["N"]).(int64)
+	first, _ := p.resolve(stream.dict["First"]).(int64)
+	if n < 0 || first < 0 || first > int64(len(data)) {
+		return nil, fmt.Errorf("invalid object stream %d", num)
+	}
+
+	header := newPdfLexer(data[:first])
+	result := &pdfObjectStream{objects: map[int]any{}}
+	offsets := []int64{}
+	for range n {
+		objNum, err := header.int()
+		if err != nil {
+			return nil, err
+		}
+		offset, err := header.int()
+		if err != nil {
+			return nil, err
+		}
+		result.order = append(result.order, int(objNum))
+		offsets = append(offsets, offset)
+	}
+
+	for i, objNum := range result.order {
+		start := first + offsets[i]
+		if start < 0 || start > int64(len(data)) {
+			continue
+		}
+		obj, err := newPdfLexer(data[start:]).object(0)
+		if err == nil {
+			result.objects[objNum] = obj
+		}
+	}
+	return result, nil
+}
+
+/* Limit for decoded streams, the parser only decodes xref and object streams */
+const pdfMaxStreamSize = 256 << 20
+
+/* Returns the decoded data of a stream. Only FlateDecode is supported. */
+func (p *pdfFile) streamData(stream *pdfStream) ([]byte, error) {
+	length, ok := p.resolve(stream.dict["Length"]).(int64)
+	if !ok || length < 0 || stream.offset+length > p.size {
+		/* A wrong length is a common defect, the data ends at "endstream" */
+		var err error
+		length, err = p.findEndstream(stream.offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if length > pdfMaxStreamSize {
+		return nil, fmt.Errorf("stream is too large: %d bytes", length)
+	}
+
+	data := make([]byte, length)
+	_, err := p.r.ReadAt(data, stream.offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	filters := []any{}
+	switch f := p.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		filters = append(filters, f)
+	case pdfArray:
+		filters = f
+	}
+
+	params := []any{}
+	switch d := p.resolve(stream.dict["DecodeParms"]).(type) {
+	case pdfDict:
+		params = append(params, d)
+	case pdfArray:
+		params = d
+	}
+
+	for i, f := range filters {
+		if p.resolve(f) != pdfName("FlateDecode") {
+			return nil, fmt.Errorf("unsupported stream filter: %v", f)
+		}
+
+		z, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(io.LimitReader(z, pdfMaxStreamSize))
+		/* Some writers produce streams without a proper end, the data is still usable */
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+
+		if i < len(params) {
+			if d, ok := p.resolve(params[i]).(pdfDict); ok {
+				data, err = pdfUnpredict(data, d)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+func (p *pdfFile) findEndstream(offset int64) (int64, error) {
+	const chunkSize = 64 << 10
+
+	buf := make([]byte, chunkSize+len("endstream"))
+	for start := offset; start < p.size && start-offset <= pdfMaxStreamSize; start += chunkSize {
+		n, err := p.r.ReadAt(buf, start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if i := bytes.Index(buf[:n], []byte("endstream")); i != -1 {
+			length := start - offset + int64(i)
+			return length, nil
+		}
+	}
+	return 0, fmt.Errorf("endstream not found")
+}
+
+/* Limit for the /Columns of a predictor, far above the widths of xref and object streams */
+const maxPdfPredictorColumns = 1 << 20
+
+/* Reverses the PNG predictors of the xref and object streams. */
+func pdfUnpredict(data []byte, params pdfDict) ([]byte, error) {
+	predictor, _ := params["Predictor"].(int64)
+	if predictor < 10 {
+		if predictor > 1 {
+			return nil, fmt.Errorf("unsupported predictor %d", predictor)
+		}
+		return data, nil
+	}
+
+	columns, ok := params["Columns"].(int64)
+	if !ok {
+		columns = 1
+	}
+	colors, ok := params["Colors"].(int64)
+	if !ok {
+		colors = 1
+	}
+	bpc, ok := params["BitsPerComponent"].(int64)
+	if !ok {
+		bpc = 8
+	}
+
+	/* Checked before the arithmetic: a crafted stream mustn't overflow the row size or make it huge */
+	if columns < 1 || columns > maxPdfPredictorColumns || colors < 1 || colors > 32 || !slices.Contains([]int64{1, 2, 4, 8, 16}, bpc) {
+		return nil, fmt.Errorf("invalid predictor parameters: columns %d, colors %d, bits per component %d", columns, colors, bpc)
+	}
+	bpp := max(int(colors*bpc+7)/8, 1)
+	rowSize := int(columns*colors*bpc+7) / 8
+	if rowSize+1 > len(data) {
+		return nil, fmt.Errorf("predicted stream is shorter than a row: %d bytes, rows of %d", len(data), rowSize+1)
+	}
+
+	result := []byte{}
+	prev := make([]byte, rowSize)
+	for len(data) > 0 {
+		if len(data) < rowSize+1 {
+			break
+		}
+		filter, row := data[0], slices.Clone(data[1:rowSize+1])
+		data = data[rowSize+1:]
+
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+
+			switch filter {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			default:
+				return nil, fmt.Errorf("invalid PNG filter %d", filter)
+			}
+		}
+
+		result = append(result, row...)
+		prev = row
+	}
+	return result, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+/* Reads the page tree and the info dictionary. */
+func (p *pdfFile) info() (*PdfInfo, error) {
+	catalog, ok := p.resolve(p.trailer["Root"]).(pdfDict)
+	if !ok {
+		return nil, fmt.Errorf("PDF document catalog not found")
+	}
+
+	pages, ok := p.resolve(catalog["Pages"]).(pdfDict)
+	if !ok {
+		return nil, fmt.Errorf("PDF page tree not found")
+	}
+
+	info := &PdfInfo{}
+	p.walkPages(catalog["Pages"], pages, pdfPageAttributes{}, map[any]bool{}, 0, func(page pdfPage) {
+		info.PageSizes = append(info.PageSizes, p.pageSize(page.attributes))
+	})
+	if len(info.PageSizes) == 0 {
+		return nil, fmt.Errorf("PDF has no pages")
+	}
+
+	/* Strings are encrypted together with the file */
+	if dict, ok := p.resolve(p.trailer["Info"]).(pdfDict); ok && !p.encrypted {
+		info.Title = p.textString(dict["Title"])
+		info.Author = p.textString(dict["Author"])
+	}
+
+	return info, nil
+}
+
+/* Attributes of a page that it inherits from its ancestors in the page tree. */
+type pdfPageAttributes struct {
+	mediaBox  any
+	cropBox   any
+	rotate    any
+	resources any
+}
+
+/* A leaf of the page tree */
+type pdfPage struct {
+	obj        any // the reference to the page, or the dictionary in a broken tree
+	dict       pdfDict
+	attributes pdfPageAttributes
+}
+
+/* US Letter, in case a page has no MediaBox at all */
+var defaultPdfPageSize = PdfPageSize{Width: 612, Height: 792}
+
+/* Calls 'visit' for the pages under the node, in order. 'obj' is the node as its parent lists it. */
+func (p *pdfFile) walkPages(obj any, node pdfDict, inherited pdfPageAttributes, visited map[any]bool, depth int, visit func(page pdfPage)) {
+	if depth > pdfMaxDepth {
+		return
+	}
+
+	if box, ok := node["MediaBox"]; ok {
+		inherited.mediaBox = box
+	}
+	if box, ok := node["CropBox"]; ok {
+		inherited.cropBox = box
+	}
+	if rotate, ok := node["Rotate"]; ok {
+		inherited.rotate = rotate
+	}
+	if resources, ok := node["Resources"]; ok {
+		inherited.resources = resources
+	}
+
+	kids, isTree := p.resolve(node["Kids"]).(pdfArray)
+	if node["Type"] == pdfName("Page") || !isTree {
+		visit(pdfPage{obj: obj, dict: node, attributes: inherited})
+		return
+	}
+
+	for _, kid := range kids {
+		/* A broken tree may list a node twice or contain a cycle */
+		if ref, ok := kid.(pdfRef); ok {
+			if visited[ref] {
+				continue
+			}
+			visited[ref] = true
+		}
+
+		if dict, ok := p.resolve(kid).(pdfDict); ok {
+			p.walkPages(kid, dict, inherited, visited, depth+1, visit)
+		}
+	}
+}
+
+func (p *pdfFile) pageSize(attributes pdfPageAttributes) PdfPageSize {
+	size, ok := p.rectangleSize(attributes.cropBox)
+	if !ok {
+		size, ok = p.rectangleSize(attributes.mediaBox)
+	}
+	if !ok {
+		size = defaultPdfPageSize
+	}
+
+	rotate, _ := p.number(attributes.rotate)
+	if r := int(rotate) % 180; r == 90 || r == -90 {
+		size.Width, size.Height = size.Height, size.Width
+	}
+	return size
+}
+
+func (p *pdfFile) rectangleSize(obj any) (PdfPageSize, bool) {
+	rect, ok := p.resolve(obj).(pdfArray)
+	if !ok || len(rect) != 4 {
+		return PdfPageSize{}, false
+	}
+
+	values := [4]float64{}
+	for i := range values {
+		v, ok := p.number(rect[i])
+		if !ok {
+			return PdfPageSize{}, false
+		}
+		values[i] = v
+	}
+
+	size := PdfPageSize{Width: math.Abs(values[2] - values[0]), Height: math.Abs(values[3] - values[1])}
+	if size.Width == 0 || size.Height == 0 {
+		return PdfPageSize{}, false
+	}
+	return size, true
+}
+
+func (p *pdfFile) number(obj any) (float64, bool) {
+	switch v := p.resolve(obj).(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+/* Decodes a text string: UTF-16BE or UTF-8 with a byte order mark, PDFDocEncoding otherwise. */
+func (p *pdfFile) textString(obj any) string {
+	s, ok := p.resolve(obj).(pdfString)
+	if !ok {
+		return ""
+	}
+
+	if bytes.HasPrefix(s, []byte{0xFE, 0xFF}) {
+		units := []uint16{}
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	if bytes.HasPrefix(s, []byte{0xEF, 0xBB, 0xBF}) {
+		return string(s[3:])
+	}
+
+	runes := []rune{}
+	for _, b := range s {
+		if r, ok := pdfDocEncoding[b]; ok {
+			runes = append(runes, r)
+		} else {
+			runes = append(runes, rune(b))
+		}
+	}
+	return string(runes)
+}
+
+/* Characters of PDFDocEncoding that differ from Latin-1 */
+var pdfDocEncoding = map[byte]rune{
+	0x18: '˘', 0x19: 'ˇ', 0x1A: 'ˆ', 0x1B: '˙', 0x1C: '˝', 0x1D: '˛', 0x1E: '˚', 0x1F: '˜',
+	0x80: '•', 0x81: '†', 0x82: '‡', 0x83: '…', 0x84: '—', 0x85: '–', 0x86: 'ƒ', 0x87: '⁄',
+	0x88: '‹', 0x89: '›', 0x8A: '−', 0x8B: '‰', 0x8C: '„', 0x8D: '“', 0x8E: '”', 0x8F: '‘',
+	0x90: '’', 0x91: '‚', 0x92: '™', 0x93: 'ﬁ', 0x94: 'ﬂ', 0x95: 'Ł', 0x96: 'Œ', 0x97: 'Š',
+	0x98: 'Ÿ', 0x99: 'Ž', 0x9A: 'ı', 0x9B: 'ł', 0x9C: 'œ', 0x9D: 'š', 0x9E: 'ž', 0xA0: '€',
+}
@@ -0,0 +1,295 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+/* The PDF is exported without the annotations */
+var errPdfNotAnnotated = errors.New("the annotations can't be written into the PDF")
+
+/* Writing into an encrypted PDF would need its password */
+var errPdfEncrypted = errors.New("PDF is encrypted")
+
+/* A page of an annotated PDF, in the order the tablet shows the pages */
+type rmAnnotatedPage struct {
+	Redirect int         // index of the page of the PDF, -1 for a page added on the tablet
+	Page     *RmPage     // the annotations, nil if there are none
+	Template image.Image // background of a page added on the tablet
+}
+
+/* Name of the annotations' form XObject in the pages' resources, a number is added if the page has one already */
+const rmOverlayName = "RmAnnotations"
+
+/* Returns the rectangle as [llx lly urx ury]. */
+func (p *pdfFile) box(obj any) ([4]float64, bool) {
+	rect, ok := p.resolve(obj).(pdfArray)
+	if !ok || len(rect) != 4 {
+		return [4]float64{}, false
+	}
+	var v [4]float64
+	for i := range v {
+		if v[i], ok = p.number(rect[i]); !ok {
+			return [4]float64{}, false
+		}
+	}
+	box := [4]float64{math.Min(v[0], v[2]), math.Min(v[1], v[3]), math.Max(v[0], v[2]), math.Max(v[1], v[3])}
+	if box[2]-box[0] == 0 || box[3]-box[1] == 0 {
+		return [4]float64{}, false
+	}
+	return box, true
+}
+
+/* The visible area of a page and its rotation in degrees clockwise, one of 0, 90, 180 and 270 */
+func (p *pdfFile) pageBox(page pdfPage) ([4]float64, int) {
+	box, ok := p.box(page.attributes.cropBox)
+	if !ok {
+		box, ok = p.box(page.attributes.mediaBox)
+	}
+	if !ok {
+		box = [4]float64{0, 0, defaultPdfPageSize.Width, defaultPdfPageSize.Height}
+	}
+	rotate, _ := p.number(page.attributes.rotate)
+	return box, ((int(rotate)%360)/90*90 + 360) % 360
+}
+
+/* Multiplies transformation matrices [a b c d e f], the result applies m first, then n. */
+func pdfMultiply(m, n [6]float64) [6]float64 {
+	return [6]float64{
+		m[0]*n[0] + m[1]*n[2], m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2], m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4], m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+/*
+Returns the matrix from the tablet's screen pixels to the user space of a PDF page. The tablet shows
+the page, rotated, as large as it fits on the screen: centered horizontally and at the top.
+*/
+func rmOverlayMatrix(box [4]float64, rotate int) [6]float64 {
+	width, height := box[2]-box[0], box[3]-box[1]
+	if rotate == 90 || rotate == 270 {
+		width, height = height, width
+	}
+	scale := math.Min(rmScreenWidth/width, rmScreenHeight/height)
+	left := (rmScreenWidth - width*scale) / 2
+
+	/* Screen pixels to points from the top left corner of the page as shown */
+	shown := [6]float64{1 / scale, 0, 0, 1 / scale, -left / scale, 0}
+
+	/* Points as shown to the user space, y pointing up */
+	var unrotate [6]float64
+	switch rotate {
+	case 90:
+		unrotate = [6]float64{0, 1, 1, 0, box[0], box[1]}
+	case 180:
+		unrotate = [6]float64{-1, 0, 0, 1, box[2], box[1]}
+	case 270:
+		unrotate = [6]float64{0, -1, -1, 0, box[2], box[3]}
+	default:
+		unrotate = [6]float64{1, 0, 0, -1, box[0], box[3]}
+	}
+	return pdfMultiply(shown, unrotate)
+}
+
+/* Writes a form XObject with the page's drawing and the template, mapped onto a page of the PDF. */
+func writeRmOverlay(w *pdfWriter, page rmAnnotatedPage, box [4]float64, rotate int, templates map[image.Image]int) int {
+	m := rmOverlayMatrix(box, rotate)
+	content := newRmPdfContent()
+	content.printf("q\n%s cm\n", pdfMatrix(m[0], m[1], m[2], m[3], m[4], m[5]))
+
+	xobjects := ""
+	if page.Template != nil {
+		num, ok := templates[page.Template]
+		if !ok {
+			num = writePdfImage(w, page.Template)
+			templates[page.Template] = num
+		}
+		xobjects = fmt.Sprintf("/XObject << /Template %d 0 R >> ", num)
+		content.printf("q %d 0 0 %d 0 %d cm /Template Do Q\n", rmScreenWidth, -rmScreenHeight, rmScreenHeight)
+	}
+	if page.Page != nil {
+		content.drawPage(page.Page)
+	}
+	content.printf("Q\n")
+
+	return w.addStream(fmt.Sprintf("/Type /XObject /Subtype /Form /BBox [%s %s %s %s] /Resources << %s%s>>",
+		pdfNumber(box[0]), pdfNumber(box[1]), pdfNumber(box[2]), pdfNumber(box[3]), content.resources(), xobjects),
+		content.buf.Bytes())
+}
+
+/*
+Writes the annotations onto a PDF and arranges its pages like the tablet shows them: pages deleted
+on the tablet are left out, pages added on the tablet are inserted with the size of the page before.
+The changes are appended to the original file as an incremental update, the original objects stay
+as they are. Pages keep their object numbers, so that links and the outline still point to them.
+*/
+func annotatePdf(original []byte, pages []rmAnnotatedPage) ([]byte, error) {
+	pdf, err := openPdf(bytes.NewReader(original), int64(len(original)))
+	if err != nil {
+		return nil, err
+	}
+	if pdf.encrypted {
+		return nil, errPdfEncrypted
+	}
+
+	rootRef, ok := pdf.trailer["Root"].(pdfRef)
+	catalog, isDict := pdf.resolve(rootRef).(pdfDict)
+	if !ok || !isDict {
+		return nil, fmt.Errorf("PDF document catalog not found")
+	}
+	tree, ok := pdf.resolve(catalog["Pages"]).(pdfDict)
+	if !ok {
+		return nil, fmt.Errorf("PDF page tree not found")
+	}
+	originalPages := []pdfPage{}
+	pdf.walkPages(catalog["Pages"], tree, pdfPageAttributes{}, map[any]bool{}, 0, func(page pdfPage) {
+		originalPages = append(originalPages, page)
+	})
+
+	next := 1
+	if size, ok := pdf.trailer["Size"].(int64); ok {
+		next = int(size)
+	}
+	for num := range pdf.xref {
+		next = max(next, num+1)
+	}
+
+	/* The update starts on a new line */
+	prefix := original
+	if !bytes.HasSuffix(prefix, []byte("\n")) {
+		prefix = append(bytes.Clone(original), '\n')
+	}
+	w := newPdfUpdateWriter(int64(len(prefix)), next)
+	pageTree := w.reserve()
+	templates := map[image.Image]int{}
+	saveState := 0 // stream with "q", the original content is wrapped in q/Q to keep its state out of the overlay
+
+	kids := []string{}
+	written := map[pdfRef]bool{}
+	box, rotate := [4]float64{0, 0, defaultPdfPageSize.Width, defaultPdfPageSize.Height}, 0
+	if len(originalPages) > 0 {
+		box, rotate = pdf.pageBox(originalPages[0])
+	}
+
+	for _, page := range pages {
+		if page.Redirect < 0 || page.Redirect >= len(originalPages) {
+			/* A page added on the tablet, upright with the size of the page before */
+			if rotate == 90 || rotate == 270 {
+				box = [4]float64{0, 0, box[3] - box[1], box[2] - box[0]}
+			} else {
+				box = [4]float64{0, 0, box[2] - box[0], box[3] - box[1]}
+			}
+			rotate = 0
+
+			dict := fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s]", pageTree, pdfNumber(box[2]), pdfNumber(box[3]))
+			if page.Page != nil || page.Template != nil {
+				overlay := writeRmOverlay(w, page, box, rotate, templates)
+				contents := w.addStream("", []byte(fmt.Sprintf("/%s Do\n", rmOverlayName)))
+				dict += fmt.Sprintf(" /Resources << /XObject << /%s %d 0 R >> >> /Contents %d 0 R", rmOverlayName, overlay, contents)
+			}
+			kids = append(kids, fmt.Sprintf("%d 0 R", w.add(dict+" >>")))
+			continue
+		}
+
+		source := originalPages[page.Redirect]
+		box, rotate = pdf.pageBox(source)
+
+		/* The page is copied with the attributes it inherited, its parent is the new page tree */
+		dict := pdfDict{}
+		for key, value := range source.dict {
+			dict[key] = value
+		}
+		dict["Parent"] = pdfRef{num: pageTree}
+		for key, value := range map[pdfName]any{"MediaBox": source.attributes.mediaBox, "CropBox": source.attributes.cropBox,
+			"Rotate": source.attributes.rotate, "Resources": source.attributes.resources} {
+			if value != nil {
+				dict[key] = value
+			}
+		}
+
+		if page.Page != nil {
+			overlay := writeRmOverlay(w, page, box, rotate, templates)
+
+			resources := pdfDict{}
+			if r, ok := pdf.resolve(dict["Resources"]).(pdfDict); ok {
+				for key, value := range r {
+					resources[key] = value
+				}
+			}
+			xobjects := pdfDict{}
+			if x, ok := pdf.resolve(resources["XObject"]).(pdfDict); ok {
+				for key, value := range x {
+					xobjects[key] = value
+				}
+			}
+			name := pdfName(rmOverlayName)
+			for i := 1; xobjects[name] != nil; i++ {
+				name = pdfName(fmt.Sprintf("%s%d", rmOverlayName, i))
+			}
+			xobjects[name] = pdfRef{num: overlay}
+			resources["XObject"] = xobjects
+			dict["Resources"] = resources
+
+			if saveState == 0 {
+				saveState = w.addStream("", []byte("q\n"))
+			}
+			contents := pdfArray{pdfRef{num: saveState}}
+			switch c := dict["Contents"].(type) {
+			case pdfRef:
+				/* An array of streams may be stored as an indirect object */
+				if array, ok := pdf.resolve(c).(pdfArray); ok {
+					contents = append(contents, array...)
+				} else {
+					contents = append(contents, c)
+				}
+			case pdfArray:
+				contents = append(contents, c...)
+			}
+			restore := w.addStream("", []byte(fmt.Sprintf("\nQ\n%s Do\n", pdfNameString(name))))
+			dict["Contents"] = append(contents, pdfRef{num: restore})
+		}
+
+		/* A page shown twice or with a generation number is written as a new object */
+		ref, isRef := source.obj.(pdfRef)
+		if isRef && ref.gen == 0 && !written[ref] {
+			written[ref] = true
+			w.object(ref.num, pdfObject(dict))
+			kids = append(kids, fmt.Sprintf("%d 0 R", ref.num))
+		} else {
+			kids = append(kids, fmt.Sprintf("%d 0 R", w.add(pdfObject(dict))))
+		}
+	}
+	if len(kids) == 0 {
+		return nil, fmt.Errorf("the document has no pages")
+	}
+
+	w.object(pageTree, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	newCatalog := pdfDict{}
+	for key, value := range catalog {
+		newCatalog[key] = value
+	}
+	newCatalog["Pages"] = pdfRef{num: pageTree}
+	root := rootRef.num
+	if rootRef.gen == 0 {
+		w.object(root, pdfObject(newCatalog))
+	} else {
+		root = w.add(pdfObject(newCatalog))
+	}
+
+	trailer := fmt.Sprintf("/Root %d 0 R", root)
+	for _, key := range []pdfName{"Info", "ID"} {
+		if value, ok := pdf.trailer[key]; ok {
+			trailer += " " + pdfNameString(key) + " " + pdfObject(value)
+		}
+	}
+	/* Without the chain, readers find the objects by scanning, like the parser did */
+	if !pdf.repaired {
+		trailer += fmt.Sprintf(" /Prev %d", pdf.startxref)
+	}
+	return append(prefix[:len(prefix):len(prefix)], w.finish(trailer)...), nil
+}
@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRmOverlayMatrix(t *testing.T) {
+	apply := func(m [6]float64, x, y float64) [2]float64 {
+		p := [2]float64{x*m[0] + y*m[2] + m[4], x*m[1] + y*m[3] + m[5]}
+		return [2]float64{math.Round(p[0]*1000) / 1000, math.Round(p[1]*1000) / 1000}
+	}
+
+	/* The top and the bottom of the screen's center line, A4 fits the height of the screen */
+	tests := []struct {
+		box         [4]float64
+		rotate      int
+		top, bottom [2]float64
+	}{
+		{[4]float64{0, 0, 595, 842}, 0, [2]float64{297.5, 842}, [2]float64{297.5, 0}},
+		{[4]float64{10, 20, 605, 862}, 0, [2]float64{307.5, 862}, [2]float64{307.5, 20}},
+		{[4]float64{0, 0, 842, 595}, 90, [2]float64{0, 297.5}, [2]float64{842, 297.5}},
+		{[4]float64{0, 0, 595, 842}, 180, [2]float64{297.5, 0}, [2]float64{297.5, 842}},
+		{[4]float64{0, 0, 842, 595}, 270, [2]float64{842, 297.5}, [2]float64{0, 297.5}},
+	}
+	for _, tt := range tests {
+		m := rmOverlayMatrix(tt.box, tt.rotate)
+		if got := apply(m, rmScreenWidth/2, 0); got != tt.top {
+			t.Errorf("%v rotated %d: top at %v, want %v", tt.box, tt.rotate, got, tt.top)
+		}
+		if got := apply(m, rmScreenWidth/2, rmScreenHeight); got != tt.bottom {
+			t.Errorf("%v rotated %d: bottom at %v, want %v", tt.box, tt.rotate, got, tt.bottom)
+		}
+	}
+}
+
+func TestAnnotatePdf(t *testing.T) {
+	original, err := os.ReadFile(writePdf(t, testPdfObjects, "<< /Size 9 /Root 1 0 R /Info 7 0 R >>", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	drawn := &RmPage{Version: 6, Layers: []RmLayer{{Visible: true, Lines: []RmLine{testLine(PenBallpoint2, ColorBlack, 4)}}}}
+	template := image.NewGray(image.Rect(0, 0, 2, 2))
+
+	/* The first page is deleted, a page is added after the second one and the third one is drawn on */
+	data, err := annotatePdf(original, []rmAnnotatedPage{
+		{Redirect: 1, Page: drawn},
+		{Redirect: -1, Template: template},
+		{Redirect: 2, Page: drawn},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, original) {
+		t.Fatalf("the original file was changed")
+	}
+	pdf := openWrittenPdf(t, data)
+	if _, ok := pdf.trailer["Prev"]; !ok {
+		t.Errorf("the update has no /Prev: %v", pdf.trailer)
+	}
+
+	info, err := pdf.info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PdfPageSize{{Width: 400, Height: 300.5}, {Width: 400, Height: 300.5}, {Width: 595, Height: 842}}
+	if diff := cmp.Diff(want, info.PageSizes); diff != "" {
+		t.Errorf("page sizes mismatch (-want +got):\n%s", diff)
+	}
+	if info.Title != "Paper Ø" {
+		t.Errorf("the document info was lost: %q", info.Title)
+	}
+
+	/* Pages keep their object numbers */
+	catalog := pdf.resolve(pdf.trailer["Root"]).(pdfDict)
+	kids := pdf.resolve(catalog["Pages"]).(pdfDict)["Kids"].(pdfArray)
+	if kids[0] != (pdfRef{num: 5}) || kids[2] != (pdfRef{num: 6}) {
+		t.Errorf("unexpected kids: %v", kids)
+	}
+
+	pages := pdfPages(t, pdf)
+	for _, i := range []int{0, 2} {
+		xobjects, _ := pdf.resolve(pdf.resolve(pages[i]["Resources"]).(pdfDict)["XObject"]).(pdfDict)
+		if xobjects[rmOverlayName] == nil {
+			t.Errorf("page %d has no overlay: %v", i+1, pages[i])
+		}
+	}
+	content := pdfPageContent(t, pdf, pages[2])
+	if !strings.HasPrefix(content, "q\n") || !strings.Contains(content, "(Hello endobj) Tj") ||
+		!strings.HasSuffix(content, "\nQ\n/"+rmOverlayName+" Do\n") {
+		t.Errorf("unexpected content of the annotated page:\n%s", content)
+	}
+	if pages[0]["Rotate"] != int64(90) || pages[2]["Rotate"] != int64(0) {
+		t.Errorf("inherited rotation lost: %v, %v", pages[0]["Rotate"], pages[2]["Rotate"])
+	}
+
+	/* The added page draws the template through its overlay */
+	overlay := pdf.resolve(pdf.resolve(pages[1]["Resources"]).(pdfDict)["XObject"].(pdfDict)[rmOverlayName]).(*pdfStream)
+	form, err := pdf.streamData(overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(form), "/Template Do") {
+		t.Errorf("the added page has no template:\n%s", form)
+	}
+}
+
+func TestAnnotatePdfEncrypted(t *testing.T) {
+	original, err := os.ReadFile(writePdf(t, testPdfObjects, "<< /Size 9 /Root 1 0 R /Encrypt << /Filter /Standard >> >>", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := annotatePdf(original, []rmAnnotatedPage{{Redirect: 0, Page: &RmPage{Version: 6}}}); !errors.Is(err, errPdfEncrypted) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
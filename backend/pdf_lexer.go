@@ -0,0 +1,423 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+/* Splits PDF syntax into tokens and parses the objects made of them. */
+type pdfLexer struct {
+	r       *bufio.Reader
+	pos     int64 // offset of the next byte in the file
+	pending []any // tokens read ahead, the last one is returned first
+}
+
+func newPdfLexer(data []byte) *pdfLexer {
+	return &pdfLexer{r: bufio.NewReader(bytes.NewReader(data))}
+}
+
+func isPdfWhitespace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n' || b == '\f' || b == 0
+}
+
+func isPdfDelimiter(b byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), b) != -1
+}
+
+func (l *pdfLexer) readByte() (byte, error) {
+	b, err := l.r.ReadByte()
+	if err == nil {
+		l.pos++
+	}
+	return b, err
+}
+
+func (l *pdfLexer) unreadByte() {
+	if l.r.UnreadByte() == nil {
+		l.pos--
+	}
+}
+
+func (l *pdfLexer) unread(tok any) {
+	l.pending = append(l.pending, tok)
+}
+
+func (l *pdfLexer) skipWhitespace() error {
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			return err
+		}
+
+		if b == '%' {
+			for b != '\r' && b != '\n' {
+				b, err = l.readByte()
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !isPdfWhitespace(b) {
+			l.unreadByte()
+			return nil
+		}
+	}
+}
+
+/*
+Returns the next token: a number (int64 or float64), a name, a string,
+or a keyword, which also covers the delimiters of arrays and dictionaries.
+*/
+func (l *pdfLexer) token() (any, error) {
+	if n := len(l.pending); n > 0 {
+		tok := l.pending[n-1]
+		l.pending = l.pending[:n-1]
+		return tok, nil
+	}
+
+	if err := l.skipWhitespace(); err != nil {
+		return nil, err
+	}
+
+	b, err := l.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch b {
+	case '/':
+		return l.name()
+	case '(':
+		return l.literalString()
+	case '[', ']', '{', '}':
+		return pdfKeyword(b), nil
+	case '<':
+		next, err := l.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if next == '<' {
+			return pdfKeyword("<<"), nil
+		}
+		l.unreadByte()
+		return l.hexString()
+	case '>':
+		next, err := l.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if next == '>' {
+			return pdfKeyword(">>"), nil
+		}
+		return nil, fmt.Errorf("unexpected '>' at %d", l.pos-1)
+	case ')':
+		return nil, fmt.Errorf("unexpected ')' at %d", l.pos)
+	}
+
+	/* A number or a keyword: everything up to the next delimiter */
+	word := []byte{b}
+	for {
+		b, err := l.readByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isPdfWhitespace(b) || isPdfDelimiter(b) {
+			l.unreadByte()
+			break
+		}
+		word = append(word, b)
+	}
+
+	if n, err := strconv.ParseInt(string(word), 10, 64); err == nil {
+		return n, nil
+	}
+	if (word[0] >= '0' && word[0] <= '9') || word[0] == '-' || word[0] == '+' || word[0] == '.' {
+		if f, err := strconv.ParseFloat(string(word), 64); err == nil {
+			return f, nil
+		}
+	}
+	return pdfKeyword(word), nil
+}
+
+func (l *pdfLexer) name() (pdfName, error) {
+	name := []byte{}
+	for {
+		b, err := l.readByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if isPdfWhitespace(b) || isPdfDelimiter(b) {
+			l.unreadByte()
+			break
+		}
+
+		if b == '#' {
+			hex := make([]byte, 2)
+			if _, err := io.ReadFull(l.r, hex); err == nil {
+				if v, err := strconv.ParseUint(string(hex), 16, 8); err == nil {
+					l.pos += 2
+					name = append(name, byte(v))
+					continue
+				}
+				/* Not an escape, keep the characters as they are */
+				l.pos += 2
+				name = append(name, b)
+				name = append(name, hex...)
+				continue
+			}
+		}
+		name = append(name, b)
+	}
+	return pdfName(name), nil
+}
+
+func (l *pdfLexer) literalString() (pdfString, error) {
+	s := []byte{}
+	depth := 1
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch b {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s, nil
+			}
+		case '\r':
+			/* Any end of line in a string is a single '\n' */
+			if next, err := l.readByte(); err == nil && next != '\n' {
+				l.unreadByte()
+			}
+			b = '\n'
+		case '\\':
+			b, err = l.readByte()
+			if err != nil {
+				return nil, err
+			}
+
+			switch b {
+			case 'n':
+				b = '\n'
+			case 'r':
+				b = '\r'
+			case 't':
+				b = '\t'
+			case 'b':
+				b = '\b'
+			case 'f':
+				b = '\f'
+			case '\r', '\n':
+				/* A line continuation */
+				if b == '\r' {
+					if next, err := l.readByte(); err == nil && next != '\n' {
+						l.unreadByte()
+					}
+				}
+				continue
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				v := int(b - '0')
+				for range 2 {
+					next, err := l.readByte()
+					if err != nil {
+						break
+					}
+					if next < '0' || next > '7' {
+						l.unreadByte()
+						break
+					}
+					v = v*8 + int(next-'0')
+				}
+				b = byte(v)
+			}
+		}
+		s = append(s, b)
+	}
+}
+
+func (l *pdfLexer) hexString() (pdfString, error) {
+	digits := []byte{}
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '>' {
+			break
+		}
+		if !isPdfWhitespace(b) {
+			digits = append(digits, b)
+		}
+	}
+
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s := make([]byte, len(digits)/2)
+	for i := range s {
+		v, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex string")
+		}
+		s[i] = byte(v)
+	}
+	return s, nil
+}
+
+func (l *pdfLexer) int() (int64, error) {
+	tok, err := l.token()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := tok.(int64)
+	if !ok {
+		return 0, fmt.Errorf("expected an integer, got %v", tok)
+	}
+	return n, nil
+}
+
+/* Parses a direct object, references are returned as they are. */
+func (l *pdfLexer) object(depth int) (any, error) {
+	if depth > pdfMaxDepth {
+		return nil, fmt.Errorf("objects are nested too deep")
+	}
+
+	tok, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case pdfKeyword("true"):
+		return true, nil
+	case pdfKeyword("false"):
+		return false, nil
+	case pdfKeyword("null"):
+		return nil, nil
+	case pdfKeyword("["):
+		array := pdfArray{}
+		for {
+			tok, err := l.token()
+			if err != nil {
+				return nil, err
+			}
+			if tok == pdfKeyword("]") {
+				return array, nil
+			}
+			l.unread(tok)
+
+			obj, err := l.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, obj)
+		}
+	case pdfKeyword("<<"):
+		dict := pdfDict{}
+		for {
+			tok, err := l.token()
+			if err != nil {
+				return nil, err
+			}
+			if tok == pdfKeyword(">>") {
+				return dict, nil
+			}
+			key, ok := tok.(pdfName)
+			if !ok {
+				return nil, fmt.Errorf("expected a dictionary key, got %v", tok)
+			}
+
+			obj, err := l.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = obj
+		}
+	}
+
+	if num, ok := tok.(int64); ok {
+		return l.maybeRef(num)
+	}
+	if keyword, ok := tok.(pdfKeyword); ok {
+		return nil, fmt.Errorf("unexpected %q", string(keyword))
+	}
+	return tok, nil
+}
+
+/* "<num> <gen> R" is a reference, otherwise the number stands alone */
+func (l *pdfLexer) maybeRef(num int64) (any, error) {
+	gen, err := l.token()
+	if err != nil {
+		return num, nil
+	}
+	if _, ok := gen.(int64); !ok {
+		l.unread(gen)
+		return num, nil
+	}
+
+	r, err := l.token()
+	if err != nil {
+		l.unread(gen)
+		return num, nil
+	}
+	if r != pdfKeyword("R") {
+		l.unread(r)
+		l.unread(gen)
+		return num, nil
+	}
+	return pdfRef{num: int(num), gen: int(gen.(int64))}, nil
+}
+
+/* Parses "<num> <gen> obj <object> endobj", a stream is returned with the offset of its data. */
+func (l *pdfLexer) indirectObject() (int, any, error) {
+	num, err := l.int()
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := l.int(); err != nil {
+		return 0, nil, err
+	}
+	if tok, err := l.token(); err != nil || tok != pdfKeyword("obj") {
+		return 0, nil, fmt.Errorf("object %d has no \"obj\"", num)
+	}
+
+	obj, err := l.object(0)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	dict, ok := obj.(pdfDict)
+	if !ok {
+		return int(num), obj, nil
+	}
+
+	tok, err := l.token()
+	if err != nil || tok != pdfKeyword("stream") {
+		return int(num), dict, nil
+	}
+
+	/* The data starts after the end of line, which should be "\r\n" or "\n" */
+	b, err := l.readByte()
+	if err == nil && b == '\r' {
+		b, err = l.readByte()
+	}
+	if err == nil && b != '\n' {
+		l.unreadByte()
+	}
+	return int(num), &pdfStream{dict: dict, offset: l.pos}, nil
+}
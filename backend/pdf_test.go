@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+/* Writes a PDF with a classic xref table, objects are numbered from 1. */
+func writePdf(t *testing.T, objects []string, trailer string, breakOffsets bool) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := []int{}
+	for i, obj := range objects {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		if breakOffsets {
+			offset += 7
+		}
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n%s\nstartxref\n%d\n%%%%EOF\n", trailer, xref)
+
+	path := filepath.Join(t.TempDir(), "test.pdf")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var testPdfObjects = []string{
+	`<< /Type /Catalog /Pages 2 0 R >>`,
+	`<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 3 /MediaBox [0 0 595 842] >>`,
+	`<< /Type /Page /Parent 2 0 R >>`,
+	`<< /Type /Pages /Parent 2 0 R /Kids [5 0 R 6 0 R] /Count 2 /Rotate 90 >>`,
+	`<< /Type /Page /Parent 4 0 R /MediaBox [0 0 612 792] /CropBox [10 10 310.5 410] >>`,
+	`<< /Type /Page /Parent 4 0 R /Rotate 0 /Contents 8 0 R >>`,
+	`<< /Title <FEFF00500061007000650072002000D8> /Author (J\351r\364me \(ed.\)) /Producer (test) >>`,
+	"<< /Length 44 >>\nstream\nBT /F1 12 Tf 72 712 Td (Hello endobj) Tj ET\n\nendstream",
+}
+
+var testPdfPages = []PdfPageSize{
+	{Width: 595, Height: 842},
+	{Width: 400, Height: 300.5},
+	{Width: 595, Height: 842},
+}
+
+func TestReadPdfInfo(t *testing.T) {
+	for _, broken := range []bool{false, true} {
+		path := writePdf(t, testPdfObjects, "<< /Size 9 /Root 1 0 R /Info 7 0 R >>", broken)
+
+		info, err := ReadPdfInfo(path)
+		if err != nil {
+			t.Fatalf("broken=%v: %v", broken, err)
+		}
+		if diff := cmp.Diff(testPdfPages, info.PageSizes); diff != "" {
+			t.Errorf("broken=%v: page sizes mismatch (-want +got):\n%s", broken, diff)
+		}
+		if info.Title != "Paper Ø" || info.Author != "Jérôme (ed.)" {
+			t.Errorf("broken=%v: unexpected info: %q, %q", broken, info.Title, info.Author)
+		}
+	}
+}
+
+func TestReadPdfInfoEncrypted(t *testing.T) {
+	path := writePdf(t, testPdfObjects, "<< /Size 9 /Root 1 0 R /Info 7 0 R /Encrypt << /Filter /Standard >> >>", false)
+
+	info, err := ReadPdfInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PageCount() != 3 || info.Title != "" || info.Author != "" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+}
+
+func TestReadPdfInfoXrefStream(t *testing.T) {
+	deflate := func(data []byte) []byte {
+		var buf bytes.Buffer
+		z := zlib.NewWriter(&buf)
+		z.Write(data)
+		z.Close()
+		return buf.Bytes()
+	}
+
+	/* Objects 1-3 in an object stream (4), the xref stream (5) uses the "Up" PNG predictor */
+	objects := []string{
+		`<< /Type /Catalog /Pages 2 0 R >>`,
+		`<< /Type /Pages /Kids [3 0 R] /Count 1 >>`,
+		`<< /Type /Page /Parent 2 0 R /MediaBox [0 0 842 595] >>`,
+	}
+	var header, body bytes.Buffer
+	for i, obj := range objects {
+		fmt.Fprintf(&header, "%d %d ", i+1, body.Len())
+		body.WriteString(obj + "\n")
+	}
+	objStm := deflate(append(header.Bytes(), body.Bytes()...))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.5\n")
+	objStmOffset := buf.Len()
+	fmt.Fprintf(&buf, "4 0 obj\n<< /Type /ObjStm /N 3 /First %d /Filter /FlateDecode /Length %d >>\nstream\n", header.Len(), len(objStm))
+	buf.Write(objStm)
+	buf.WriteString("\nendstream\nendobj\n")
+	xrefOffset := buf.Len()
+
+	entries := [][]byte{
+		{0, 0, 0, 0},
+		{2, 0, 4, 0},
+		{2, 0, 4, 1},
+		{2, 0, 4, 2},
+		{1, byte(objStmOffset >> 8), byte(objStmOffset), 0},
+		{1, byte(xrefOffset >> 8), byte(xrefOffset), 0},
+	}
+	rows := []byte{}
+	prev := make([]byte, 4)
+	for _, entry := range entries {
+		rows = append(rows, 2)
+		for i := range entry {
+			rows = append(rows, entry[i]-prev[i])
+		}
+		prev = entry
+	}
+	xref := deflate(rows)
+
+	fmt.Fprintf(&buf, "5 0 obj\n<< /Type /XRef /Size 6 /W [1 2 1] /Root 1 0 R /Filter /FlateDecode "+
+		"/DecodeParms << /Predictor 12 /Columns 5 >> /Length %d >>\nstream\n", len(xref))
+	buf.Write(xref)
+	fmt.Fprintf(&buf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xrefOffset)
+
+	/* Wrong Columns make the xref stream unreadable, the objects are found by scanning the file */
+	fixed := bytes.Replace(buf.Bytes(), []byte("/Columns 5"), []byte("/Columns 4"), 1)
+	/* Huge Columns are rejected before the row buffer is allocated */
+	huge := bytes.Replace(buf.Bytes(), []byte("/Columns 5"), []byte("/Columns 1099511627776"), 1)
+	for name, data := range map[string][]byte{"valid": fixed, "reconstructed": buf.Bytes(), "huge columns": huge} {
+		path := filepath.Join(t.TempDir(), "test.pdf")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := ReadPdfInfo(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if diff := cmp.Diff([]PdfPageSize{{Width: 842, Height: 595}}, info.PageSizes); diff != "" {
+			t.Errorf("%s: page sizes mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+}
+
+func TestPdfUnpredictLimits(t *testing.T) {
+	invalid := []pdfDict{
+		{"Predictor": int64(12), "Columns": int64(1099511627776)},
+		{"Predictor": int64(12), "Columns": int64(-1)},
+		{"Predictor": int64(12), "Columns": int64(4), "Colors": int64(1 << 40)},
+		{"Predictor": int64(12), "Columns": int64(4), "BitsPerComponent": int64(1 << 40)},
+		{"Predictor": int64(12), "Columns": int64(100)},
+	}
+	for _, params := range invalid {
+		if _, err := pdfUnpredict(make([]byte, 10), params); err == nil {
+			t.Errorf("%v: no error", params)
+		}
+	}
+}
+
+func TestReadPdfInfoNotPdf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.pdf")
+	if err := os.WriteFile(path, []byte("PK\x03\x04 not a pdf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPdfInfo(path); err != errNotPdf {
+		t.Errorf("expected errNotPdf, got %v", err)
+	}
+}
+
+func TestNewPdfContent(t *testing.T) {
+	info := &PdfInfo{
+		PageSizes: []PdfPageSize{{Width: 842, Height: 595}, {Width: 595, Height: 842}},
+		Title:     "Paper",
+		Author:    "Someone",
+	}
+	content := newPdfContent(info, 1234)
+
+	if content.PageCount != 2 || content.OriginalPageCount != 2 || content.SizeInBytes != "1234" {
+		t.Errorf("unexpected content: %+v", content)
+	}
+	if len(content.Pages) != 2 || content.Pages[0] == content.Pages[1] || validateDocId(content.Pages[0]) != nil {
+		t.Errorf("unexpected pages: %v", content.Pages)
+	}
+	if diff := cmp.Diff([]int{0, 1}, content.RedirectionPageMap); diff != "" {
+		t.Errorf("redirection page map mismatch (-want +got):\n%s", diff)
+	}
+	if content.Orientation != "landscape" {
+		t.Errorf("unexpected orientation: %s", content.Orientation)
+	}
+	if diff := cmp.Diff(&SSHDocumentMetadata{Title: "Paper", Authors: []string{"Someone"}}, content.DocumentMetadata); diff != "" {
+		t.Errorf("document metadata mismatch (-want +got):\n%s", diff)
+	}
+	if content.Transform["m11"] != 1 || content.Transform["m12"] != 0 || content.Transform["m33"] != 1 {
+		t.Errorf("transform isn't the identity: %v", content.Transform)
+	}
+
+	unknown := newPdfContent(nil, 0)
+	if unknown.PageCount != 0 || unknown.Pages != nil || unknown.DocumentMetadata != nil {
+		t.Errorf("unexpected content without info: %+v", unknown)
+	}
+}
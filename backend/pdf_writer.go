@@ -0,0 +1,224 @@
+package backend
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+/*
+Writes the objects of a PDF file and its cross-reference table. The same writer appends
+an incremental update to an existing file: the new objects are numbered after the file's
+objects and the trailer points to the file's cross-reference section with /Prev.
+*/
+type pdfWriter struct {
+	buf     bytes.Buffer
+	base    int64 // size of the file the objects are appended to
+	next    int   // number of the next object
+	offsets map[int]int64
+}
+
+/* Starts a new file. */
+func newPdfWriter() *pdfWriter {
+	w := &pdfWriter{next: 1, offsets: map[int]int64{}}
+	/* The binary comment tells tools that the file isn't text */
+	w.buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	return w
+}
+
+/* Starts an update appended to a file of 'size' bytes, whose objects are numbered below 'next'. */
+func newPdfUpdateWriter(size int64, next int) *pdfWriter {
+	return &pdfWriter{base: size, next: next, offsets: map[int]int64{}}
+}
+
+/* Returns a number for an object that is written later. */
+func (w *pdfWriter) reserve() int {
+	num := w.next
+	w.next++
+	return num
+}
+
+/* Writes an object with a number from reserve(), or the number of an object the update replaces. */
+func (w *pdfWriter) object(num int, body string) {
+	w.offsets[num] = w.base + int64(w.buf.Len())
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+func (w *pdfWriter) add(body string) int {
+	num := w.reserve()
+	w.object(num, body)
+	return num
+}
+
+/* Writes a stream compressed with FlateDecode. 'dict' holds the other entries of the stream's dictionary. */
+func (w *pdfWriter) stream(num int, dict string, data []byte) {
+	var compressed bytes.Buffer
+	z := zlib.NewWriter(&compressed)
+	z.Write(data)
+	z.Close()
+
+	w.offsets[num] = w.base + int64(w.buf.Len())
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< %s /Filter /FlateDecode /Length %d >>\nstream\n", num, dict, compressed.Len())
+	w.buf.Write(compressed.Bytes())
+	w.buf.WriteString("\nendstream\nendobj\n")
+}
+
+func (w *pdfWriter) addStream(dict string, data []byte) int {
+	num := w.reserve()
+	w.stream(num, dict, data)
+	return num
+}
+
+/*
+Writes the cross-reference table and the trailer, and returns the written data.
+'trailer' holds the entries of the trailer besides /Size, e.g. "/Root 1 0 R".
+*/
+func (w *pdfWriter) finish(trailer string) []byte {
+	xref := w.base + int64(w.buf.Len())
+	nums := slices.Sorted(maps.Keys(w.offsets))
+	if w.base == 0 {
+		nums = append([]int{0}, nums...)
+	}
+
+	/* Subsections of consecutive object numbers */
+	w.buf.WriteString("xref\n")
+	for start := 0; start < len(nums); {
+		end := start + 1
+		for end < len(nums) && nums[end] == nums[end-1]+1 {
+			end++
+		}
+		fmt.Fprintf(&w.buf, "%d %d\n", nums[start], end-start)
+		for _, num := range nums[start:end] {
+			if num == 0 {
+				w.buf.WriteString("0000000000 65535 f\r\n")
+			} else {
+				fmt.Fprintf(&w.buf, "%010d 00000 n\r\n", w.offsets[num])
+			}
+		}
+		start = end
+	}
+
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", w.next, trailer, xref)
+	return w.buf.Bytes()
+}
+
+/* Formats a number for a content stream or a dictionary, with the precision the drawing needs. */
+func pdfNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1000)/1000+0, 'f', -1, 64)
+}
+
+/* Formats a transformation matrix, precisely enough for a scale to be exact across a page. */
+func pdfMatrix(a, b, c, d, e, f float64) string {
+	values := []string{}
+	for _, v := range []float64{a, b, c, d, e, f} {
+		values = append(values, strconv.FormatFloat(math.Round(v*1e6)/1e6+0, 'f', -1, 64))
+	}
+	return strings.Join(values, " ")
+}
+
+/*
+Writes an object read by the parser back in PDF syntax, for the objects an update copies.
+Keys are sorted, so that the same object is always written the same way.
+*/
+func pdfObject(obj any) string {
+	switch v := obj.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case pdfName:
+		return pdfNameString(v)
+	case pdfString:
+		return fmt.Sprintf("<%X>", []byte(v))
+	case pdfRef:
+		return fmt.Sprintf("%d %d R", v.num, v.gen)
+	case pdfArray:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = pdfObject(item)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case pdfDict:
+		var sb strings.Builder
+		sb.WriteString("<<")
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			sb.WriteString(" " + pdfNameString(key) + " " + pdfObject(v[key]))
+		}
+		sb.WriteString(" >>")
+		return sb.String()
+	}
+	/* Streams are always indirect objects, the parser returns nothing else */
+	return "null"
+}
+
+/* Writes a name, with "#xx" for the characters that can't be in a name. */
+func pdfNameString(name pdfName) string {
+	var sb strings.Builder
+	sb.WriteByte('/')
+	for _, b := range []byte(name) {
+		if b <= ' ' || b >= 0x7f || b == '#' || isPdfDelimiter(b) {
+			fmt.Fprintf(&sb, "#%02X", b)
+		} else {
+			sb.WriteByte(b)
+		}
+	}
+	return sb.String()
+}
+
+/* Writes a literal string, "(...)", of bytes. */
+func pdfLiteral(s []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('(')
+	for _, b := range s {
+		switch b {
+		case '(', ')', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case '\r':
+			sb.WriteString("\\r")
+		case '\n':
+			sb.WriteString("\\n")
+		default:
+			sb.WriteByte(b)
+		}
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+/* Writes a text string for the document info, UTF-16BE with a byte order mark. */
+func pdfTextString(s string) string {
+	var sb strings.Builder
+	sb.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&sb, "%04X", u)
+	}
+	sb.WriteByte('>')
+	return sb.String()
+}
+
+/* Encodes text for the standard fonts with WinAnsiEncoding, characters it doesn't have become "?". */
+func pdfWinAnsi(s string) []byte {
+	result := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			result = append(result, byte(r))
+		case r == '\t':
+			result = append(result, ' ')
+		default:
+			result = append(result, '?')
+		}
+	}
+	return result
+}
@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+/*
+Directory where xochitl (the tablet's UI) keeps the documents.
+The path is relative to the home directory, which is the working directory
+of SSH sessions and of sftp-server, so it doesn't depend on '~' expansion.
+*/
+const xochitlDir = ".local/share/remarkable/xochitl"
+
+/* The parent of the items in the trash */
+const trashId = "trash"
+
+/* Returns the path of a file in xochitl's directory. */
+func xochitlPath(name string) string {
+	return path.Join(xochitlDir, name)
+}
+
+/* Returns an error unless id is a lowercase UUID, the format xochitl uses for document IDs. */
+func validateDocId(id DocId) error {
+	parsed, err := uuid.Parse(id)
+	if err != nil || parsed.String() != id {
+		return fmt.Errorf("invalid document ID: %q", id)
+	}
+	return nil
+}
+
+/* A parent is either a document ID, the root (empty string) or the trash. */
+func validateParentId(id DocId) error {
+	if id == "" || id == trashId {
+		return nil
+	}
+	if err := validateDocId(id); err != nil {
+		return fmt.Errorf("invalid parent ID: %q", id)
+	}
+	return nil
+}
+
+/* Characters that never need quoting in a POSIX shell word. */
+func isShellSafe(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		strings.ContainsRune("_-.,/:+@%", c)
+}
+
+/*
+Quotes a string so that a POSIX shell treats it as a single literal word.
+Everything is wrapped in single quotes. A single quote inside the string
+closes the quoted part, is escaped with a backslash, and opens a new quoted part.
+*/
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(c rune) bool { return !isShellSafe(c) }) == -1 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+/*
+Builds a shell command line for the tablet.
+
+Every program name, argument and redirection target is quoted, so that
+file names and IDs can't change the meaning of the command.
+Only the operators added by the builder itself are left unquoted.
+
+	newRemoteCommand("mkdir", "-p").path(dir).and(newRemoteCommand("mv", "-f").path(from, to))
+*/
+type remoteCommand struct {
+	words []string
+}
+
+func newRemoteCommand(name string, args ...string) *remoteCommand {
+	c := &remoteCommand{}
+	c.words = append(c.words, shellQuote(name))
+	return c.arg(args...)
+}
+
+/* Appends arguments. */
+func (c *remoteCommand) arg(args ...string) *remoteCommand {
+	for _, a := range args {
+		c.words = append(c.words, shellQuote(a))
+	}
+	return c
+}
+
+/*
+Appends file paths. A path starting with '-' is prefixed with "./",
+so that it can't be mistaken for an option.
+*/
+func (c *remoteCommand) path(paths ...string) *remoteCommand {
+	for _, p := range paths {
+		c.words = append(c.words, shellQuote(safePath(p)))
+	}
+	return c
+}
+
+func safePath(p string) string {
+	if strings.HasPrefix(p, "-") {
+		return "./" + p
+	}
+	return p
+}
+
+/* Redirects the output to a file (">"). */
+func (c *remoteCommand) writeTo(file string) *remoteCommand {
+	c.words = append(c.words, ">", shellQuote(safePath(file)))
+	return c
+}
+
+/* Redirects the output to the end of a file (">>"). */
+func (c *remoteCommand) appendTo(file string) *remoteCommand {
+	c.words = append(c.words, ">>", shellQuote(safePath(file)))
+	return c
+}
+
+/* Discards the error output. */
+func (c *remoteCommand) quiet() *remoteCommand {
+	c.words = append(c.words, "2>/dev/null")
+	return c
+}
+
+func (c *remoteCommand) join(op string, next *remoteCommand) *remoteCommand {
+	c.words = append(c.words, op)
+	c.words = append(c.words, next.words...)
+	return c
+}
+
+/* Runs next only if the command succeeds. */
+func (c *remoteCommand) and(next *remoteCommand) *remoteCommand {
+	return c.join("&&", next)
+}
+
+/* Runs next only if the command fails. */
+func (c *remoteCommand) or(next *remoteCommand) *remoteCommand {
+	return c.join("||", next)
+}
+
+/* Pipes the output of the command into next. */
+func (c *remoteCommand) pipe(next *remoteCommand) *remoteCommand {
+	return c.join("|", next)
+}
+
+func (c *remoteCommand) String() string {
+	return strings.Join(c.words, " ")
+}
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+var hostileInputs = []string{
+	"",
+	"plain",
+	"with space",
+	"semi;colon; rm -rf ~",
+	"quote'inside",
+	`double"quote`,
+	"$(reboot)",
+	"`reboot`",
+	"$HOME",
+	"back\\slash",
+	"new\nline",
+	"glob*?[a]",
+	"~/.ssh",
+	"a && b || c | d > e < f",
+	"-rf",
+	"'; echo pwned; '",
+	"юнікод 文件",
+}
+
+func TestShellQuoteRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no POSIX shell available")
+	}
+
+	for _, input := range hostileInputs {
+		/* The marker makes sure trailing newlines aren't lost */
+		command := newRemoteCommand("printf", "%s|", input)
+		output, err := exec.Command(sh, "-c", command.String()).Output()
+		if err != nil {
+			t.Fatalf("command failed, input=%q, command=%v, err=%v", input, command, err)
+		}
+
+		if string(output) != input+"|" {
+			t.Fatalf("shell didn't see a literal argument, input=%q, command=%v, output=%q", input, command, output)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	inputs := []string{"abc", ".local/share/remarkable/xochitl", "", "a b", "it's", "$x", "a=b"}
+	expected := []string{"abc", ".local/share/remarkable/xochitl", "''", "'a b'", `'it'\''s'`, "'$x'", "'a=b'"}
+
+	for i, input := range inputs {
+		if shellQuote(input) != expected[i] {
+			t.Fatalf("shellQuote!=expected, input=%q, shellQuote=%v, expected=%v", input, shellQuote(input), expected[i])
+		}
+	}
+}
+
+func TestRemoteCommand(t *testing.T) {
+	commands := []*remoteCommand{
+		newRemoteCommand("mkdir", "-p").path("dir with space/x;y"),
+		newRemoteCommand("rm").path("-rf"),
+		newRemoteCommand("cat").writeTo("a b"),
+		newRemoteCommand("cat").appendTo("-x"),
+		newRemoteCommand("stat", "-c%s").path("f").quiet().or(newRemoteCommand("echo", "0")),
+		newRemoteCommand("mkdir", "-p").path("d").and(newRemoteCommand("mv", "-f").path("a", "$(b)")),
+		newRemoteCommand("find").path(".").pipe(newRemoteCommand("wc", "-l")),
+	}
+	expected := []string{
+		"mkdir -p 'dir with space/x;y'",
+		"rm ./-rf",
+		"cat > 'a b'",
+		"cat >> ./-x",
+		"stat -c%s f 2>/dev/null || echo 0",
+		"mkdir -p d && mv -f a '$(b)'",
+		"find . | wc -l",
+	}
+
+	for i, command := range commands {
+		if command.String() != expected[i] {
+			t.Fatalf("command!=expected, command=%v, expected=%v", command, expected[i])
+		}
+	}
+}
+
+func TestValidateDocId(t *testing.T) {
+	valid := []string{"0d2f3c8e-6a8e-4b9b-a7b5-1f2c3d4e5f60", "00000000-0000-0000-0000-000000000000"}
+	for _, id := range valid {
+		if err := validateDocId(id); err != nil {
+			t.Fatalf("validateDocId(%q) failed: %v", id, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"trash",
+		"0D2F3C8E-6A8E-4B9B-A7B5-1F2C3D4E5F60",
+		"{0d2f3c8e-6a8e-4b9b-a7b5-1f2c3d4e5f60}",
+		"urn:uuid:0d2f3c8e-6a8e-4b9b-a7b5-1f2c3d4e5f60",
+		"0d2f3c8e6a8e4b9ba7b51f2c3d4e5f60",
+		"0d2f3c8e-6a8e-4b9b-a7b5-1f2c3d4e5f60; rm -rf ~",
+		"../0d2f3c8e-6a8e-4b9b-a7b5-1f2c3d4e5f6",
+		"0d2f3c8e-6a8e-4b9b-a7b5-1f2c3d4e5f6 ",
+	}
+	invalid = append(invalid, hostileInputs...)
+
+	for _, id := range invalid {
+		if err := validateDocId(id); err == nil {
+			t.Fatalf("validateDocId(%q) accepted an invalid ID", id)
+		}
+	}
+}
+
+func TestValidateParentId(t *testing.T) {
+	for _, id := range []string{"", "trash", "0d2f3c8e-6a8e-4b9b-a7b5-1f2c3d4e5f60"} {
+		if err := validateParentId(id); err != nil {
+			t.Fatalf("validateParentId(%q) failed: %v", id, err)
+		}
+	}
+
+	for _, id := range []string{"Trash", "trash;reboot", "..", strings.Repeat("a", 36)} {
+		if err := validateParentId(id); err == nil {
+			t.Fatalf("validateParentId(%q) accepted an invalid ID", id)
+		}
+	}
+}
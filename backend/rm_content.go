@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/* A page of a document, in the order the tablet shows the pages */
+type rmContentPage struct {
+	Id       string
+	Template string // name of the background template, "" or "Blank" for none
+	Redirect int    // index of the page of the PDF or EPUB shown below, -1 for pages added on the tablet
+}
+
+/* A value of cPages with the time it was changed */
+type rmContentValue[T any] struct {
+	Value T `json:"value"`
+}
+
+/* The pages of a .content file of format version 2 (firmware 3.0 and later) */
+type rmContentPages struct {
+	Pages []rmContentPageEntry `json:"pages"`
+}
+
+type rmContentPageEntry struct {
+	Id       string                  `json:"id"`
+	Index    rmContentValue[string]  `json:"idx"`
+	Template *rmContentValue[string] `json:"template"`
+	Redirect *rmContentValue[int]    `json:"redir"`
+	Deleted  *rmContentValue[int]    `json:"deleted"`
+}
+
+/*
+Returns the pages of the document. Format version 2 lists the pages in cPages, ordered by their
+index strings, with deleted pages kept in the list; version 1 lists the page IDs in "pages",
+the original pages in "redirectionPageMap" and the templates in the .pagedata file, one per line.
+*/
+func (c *SSHContent) pageList(pagedata string) ([]rmContentPage, error) {
+	source := c.FileType == "pdf" || c.FileType == "epub"
+
+	if len(c.CPages) > 0 {
+		var cPages rmContentPages
+		if err := json.Unmarshal(c.CPages, &cPages); err != nil {
+			return nil, fmt.Errorf("invalid cPages: %w", err)
+		}
+		pages := slices.DeleteFunc(cPages.Pages, func(page rmContentPageEntry) bool {
+			return page.Deleted != nil && page.Deleted.Value != 0
+		})
+		slices.SortStableFunc(pages, func(a, b rmContentPageEntry) int {
+			return strings.Compare(a.Index.Value, b.Index.Value)
+		})
+
+		result := []rmContentPage{}
+		for _, page := range pages {
+			p := rmContentPage{Id: page.Id, Redirect: -1}
+			if page.Template != nil {
+				p.Template = page.Template.Value
+			}
+			if page.Redirect != nil {
+				p.Redirect = page.Redirect.Value
+			}
+			result = append(result, p)
+		}
+		return result, nil
+	}
+
+	templates := strings.Split(strings.TrimRight(pagedata, "\n"), "\n")
+	result := []rmContentPage{}
+	for i, id := range c.Pages {
+		p := rmContentPage{Id: id, Redirect: -1}
+		if i < len(templates) {
+			p.Template = strings.TrimSpace(templates[i])
+		}
+		switch {
+		case i < len(c.RedirectionPageMap):
+			p.Redirect = c.RedirectionPageMap[i]
+		case source && len(c.RedirectionPageMap) == 0:
+			p.Redirect = i
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
@@ -0,0 +1,209 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+)
+
+/* Points per pixel of the tablet's screen, which has 226 pixels per inch */
+const rmPdfScale = 72.0 / 226
+
+/*
+Draws pages as PDF content, in the tablet's pixels with y pointing down like in the .rm files.
+The caller maps them to the PDF page with a "cm" operator. The resources the content needs,
+the transparency levels and the font, are collected for the page's resource dictionary.
+*/
+type rmPdfContent struct {
+	buf      bytes.Buffer
+	alphas   []float64 // the ExtGState /GS<i> has the alpha at index i
+	alpha    float64   // current alpha
+	usesFont bool
+}
+
+func newRmPdfContent() *rmPdfContent {
+	return &rmPdfContent{alpha: 1}
+}
+
+func (c *rmPdfContent) printf(format string, args ...any) {
+	fmt.Fprintf(&c.buf, format, args...)
+}
+
+func (c *rmPdfContent) setAlpha(alpha float64) {
+	alpha = float64(int(alpha*100+0.5)) / 100
+	if alpha == c.alpha {
+		return
+	}
+	index := -1
+	for i, a := range c.alphas {
+		if a == alpha {
+			index = i
+		}
+	}
+	if index < 0 {
+		index = len(c.alphas)
+		c.alphas = append(c.alphas, alpha)
+	}
+	c.printf("/GS%d gs\n", index)
+	c.alpha = alpha
+}
+
+func pdfRGB(c rmRGB) string {
+	return pdfNumber(float64(c[0])/255) + " " + pdfNumber(float64(c[1])/255) + " " + pdfNumber(float64(c[2])/255)
+}
+
+/* Draws the typed text, the highlights and the lines of the page's visible layers. */
+func (c *rmPdfContent) drawPage(page *RmPage) {
+	dx := rmOffsetX(page)
+
+	if page.Text != nil {
+		c.setAlpha(1)
+		c.usesFont = true
+		c.printf("0 g\nBT\n/F1 %d Tf\n", rmTextFontSize)
+		for i, line := range rmTextLines(page.Text) {
+			if line == "" {
+				continue
+			}
+			/* The text matrix flips the glyphs back up */
+			c.printf("1 0 0 -1 %s %s Tm\n%s Tj\n", pdfNumber(page.Text.X+dx),
+				pdfNumber(page.Text.Y+float64(i+1)*rmTextLineHeight), pdfLiteral(pdfWinAnsi(line)))
+		}
+		c.printf("ET\n")
+	}
+
+	for _, layer := range page.Layers {
+		if !layer.Visible {
+			continue
+		}
+
+		for _, highlight := range layer.Highlights {
+			c.setAlpha(0.3)
+			c.printf("%s rg\n", pdfRGB(rmColor(highlight.Color, highlight.ARGB)))
+			for _, rect := range highlight.Rects {
+				c.printf("%s %s %s %s re f\n", pdfNumber(rect.X+dx), pdfNumber(rect.Y), pdfNumber(rect.Width), pdfNumber(rect.Height))
+			}
+		}
+
+		for _, line := range layer.Lines {
+			stroke, ok := rmLineStroke(line, dx)
+			if !ok {
+				continue
+			}
+			if stroke.Square {
+				c.printf("2 J 0 j\n")
+			} else {
+				c.printf("1 J 1 j\n")
+			}
+			c.printf("%s RG\n", pdfRGB(stroke.Color))
+			for _, segment := range stroke.Segments {
+				c.setAlpha(segment.Opacity)
+				c.printf("%s w\n", pdfNumber(segment.Width))
+				for i, p := range segment.Points {
+					op := "l"
+					if i == 0 {
+						op = "m"
+					}
+					c.printf("%s %s %s\n", pdfNumber(float64(p.X)), pdfNumber(float64(p.Y)), op)
+				}
+				c.printf("S\n")
+			}
+		}
+	}
+}
+
+/* Returns the entries of the resource dictionary the content needs, without the enclosing "<< >>". */
+func (c *rmPdfContent) resources() string {
+	var sb strings.Builder
+	if len(c.alphas) > 0 {
+		sb.WriteString("/ExtGState << ")
+		for i, alpha := range c.alphas {
+			fmt.Fprintf(&sb, "/GS%d << /Type /ExtGState /CA %s /ca %s >> ", i, pdfNumber(alpha), pdfNumber(alpha))
+		}
+		sb.WriteString(">> ")
+	}
+	if c.usesFont {
+		sb.WriteString("/Font << /F1 << /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >> >> ")
+	}
+	return sb.String()
+}
+
+/* Writes an image XObject, composited onto white: template PNGs may be transparent. */
+func writePdfImage(w *pdfWriter, img image.Image) int {
+	bounds := img.Bounds()
+	gray := true
+	pixels := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			white := 0xffff - a
+			r, g, b = (r+white)>>8, (g+white)>>8, (b+white)>>8
+			gray = gray && r == g && g == b
+			pixels = append(pixels, byte(r), byte(g), byte(b))
+		}
+	}
+
+	colorSpace := "/DeviceRGB"
+	if gray {
+		colorSpace = "/DeviceGray"
+		for i := range len(pixels) / 3 {
+			pixels[i] = pixels[i*3]
+		}
+		pixels = pixels[:len(pixels)/3]
+	}
+	return w.addStream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8",
+		bounds.Dx(), bounds.Dy(), colorSpace), pixels)
+}
+
+/* A page of a notebook to write as PDF */
+type rmPdfPage struct {
+	Page     *RmPage     // nil for a page that has never been drawn on
+	Template image.Image // nil for no background
+}
+
+/*
+Writes a notebook as PDF, like the tablet exports it: a page per notebook page with its template
+in the background, at the size of the screen or larger if the drawing goes beyond it.
+*/
+func buildRmPdf(title string, pages []rmPdfPage) []byte {
+	w := newPdfWriter()
+	catalog, pageTree := w.reserve(), w.reserve()
+	templates := map[image.Image]int{}
+
+	kids := []string{}
+	for _, p := range pages {
+		page := p.Page
+		if page == nil {
+			page = &RmPage{Version: 6}
+		}
+		minX, minY, maxX, maxY := rmPageBounds(page)
+
+		content := newRmPdfContent()
+		content.printf("q\n%s cm\n", pdfMatrix(rmPdfScale, 0, 0, -rmPdfScale, -minX*rmPdfScale, maxY*rmPdfScale))
+
+		xobjects := ""
+		if p.Template != nil {
+			num, ok := templates[p.Template]
+			if !ok {
+				num = writePdfImage(w, p.Template)
+				templates[p.Template] = num
+			}
+			xobjects = fmt.Sprintf("/XObject << /Template %d 0 R >> ", num)
+			/* The image is drawn upside down, to undo the flip of the page */
+			content.printf("q %d 0 0 %d 0 %d cm /Template Do Q\n", rmScreenWidth, -rmScreenHeight, rmScreenHeight)
+		}
+		content.drawPage(page)
+		content.printf("Q\n")
+
+		contents := w.addStream("", content.buf.Bytes())
+		kids = append(kids, fmt.Sprintf("%d 0 R", w.add(fmt.Sprintf(
+			"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << %s%s>> /Contents %d 0 R >>",
+			pageTree, pdfNumber((maxX-minX)*rmPdfScale), pdfNumber((maxY-minY)*rmPdfScale),
+			content.resources(), xobjects, contents))))
+	}
+
+	w.object(pageTree, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	w.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pageTree))
+	info := w.add(fmt.Sprintf("<< /Title %s /Producer (rm-importer) >>", pdfTextString(title)))
+	return w.finish(fmt.Sprintf("/Root %d 0 R /Info %d 0 R", catalog, info))
+}
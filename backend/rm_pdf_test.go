@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+/* Opens a written PDF with the parser, checking that its cross-reference table is right. */
+func openWrittenPdf(t *testing.T, data []byte) *pdfFile {
+	t.Helper()
+
+	pdf := &pdfFile{r: bytes.NewReader(data), size: int64(len(data)), xref: map[int]pdfXrefEntry{}, objectStreams: map[int]map[int]any{}}
+	if err := pdf.loadXref(); err != nil || !pdf.hasCatalog() {
+		t.Fatalf("invalid cross-reference table: %v", err)
+	}
+	return pdf
+}
+
+/* Returns the dictionaries of the pages in order, for a flat page tree. */
+func pdfPages(t *testing.T, pdf *pdfFile) []pdfDict {
+	t.Helper()
+
+	catalog := pdf.resolve(pdf.trailer["Root"]).(pdfDict)
+	pages := []pdfDict{}
+	for _, kid := range pdf.resolve(catalog["Pages"]).(pdfDict)["Kids"].(pdfArray) {
+		pages = append(pages, pdf.resolve(kid).(pdfDict))
+	}
+	return pages
+}
+
+/* Returns the decoded content of a page, its content streams joined. */
+func pdfPageContent(t *testing.T, pdf *pdfFile, page pdfDict) string {
+	t.Helper()
+
+	refs, ok := page["Contents"].(pdfArray)
+	if !ok {
+		refs = pdfArray{page["Contents"]}
+	}
+	var content strings.Builder
+	for _, ref := range refs {
+		data, err := pdf.streamData(pdf.resolve(ref).(*pdfStream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content.Write(data)
+	}
+	return content.String()
+}
+
+func TestBuildRmPdf(t *testing.T) {
+	template := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range template.Pix {
+		template.Pix[i] = 200
+	}
+	template.Set(1, 1, color.Gray{0})
+
+	highlighter := testLine(PenHighlighter2, ColorYellow, 3)
+	drawn := &RmPage{Version: 6,
+		Layers: []RmLayer{
+			{Visible: true, Lines: []RmLine{testLine(PenBallpoint2, ColorBlack, 12), highlighter}},
+			{Visible: false, Lines: []RmLine{testLine(PenMarker2, ColorRed, 3)}},
+		},
+		Text: &RmText{Items: []RmTextItem{{ItemId: CrdtId{1, 1}, Text: "(Café) ✓"}}, X: -468, Y: 234, Width: 936},
+	}
+	scrolled := &RmPage{Version: 5, Layers: []RmLayer{{Visible: true, Lines: []RmLine{testLine(PenFineliner1, ColorBlack, 2)}}}}
+	scrolled.Layers[0].Lines[0].Points[1].Y = 2500
+
+	data := buildRmPdf("Notes ✓", []rmPdfPage{{drawn, template}, {nil, template}, {scrolled, nil}})
+	pdf := openWrittenPdf(t, data)
+
+	info, err := pdf.info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PdfPageSize{{447.292, 596.389}, {447.292, 596.389}, {447.292, 796.46}}
+	if diff := cmp.Diff(want, info.PageSizes); diff != "" {
+		t.Errorf("page sizes mismatch (-want +got):\n%s", diff)
+	}
+	if info.Title != "Notes ✓" {
+		t.Errorf("unexpected title: %q", info.Title)
+	}
+
+	/* The template is stored once for both pages */
+	if n := bytes.Count(data, []byte("/Subtype /Image")); n != 1 {
+		t.Errorf("template stored %d times", n)
+	}
+
+	pages := pdfPages(t, pdf)
+	content := pdfPageContent(t, pdf, pages[0])
+	for _, s := range []string{"/Template Do", "(\\(Caf\xe9\\) ?) Tj", "2 J 0 j", "/GS0 gs"} {
+		if !strings.Contains(content, s) {
+			t.Errorf("page content has no %q:\n%s", s, content)
+		}
+	}
+	/* The hidden layer's red marker isn't drawn */
+	if strings.Contains(content, pdfRGB(rmColors[ColorRed])+" RG") {
+		t.Errorf("hidden layer was drawn")
+	}
+	resources := pages[0]["Resources"].(pdfDict)
+	if _, ok := resources["Font"]; !ok {
+		t.Errorf("page with text has no font: %v", resources)
+	}
+	if _, ok := pdfPages(t, pdf)[2]["Resources"].(pdfDict)["XObject"]; ok {
+		t.Errorf("page without template has an XObject")
+	}
+}
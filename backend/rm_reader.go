@@ -6,8 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"slices"
-	"strings"
+	"strconv"
 	"time"
 )
 
@@ -22,7 +21,11 @@ type DocInfo struct {
 	// Optional
 	Bookmarked   bool
 	LastModified *time.Time
-	FileType     *string
+	FileType     *string // "pdf", "epub" or "notebook"
+	PageCount    *int
+	Size         *int64   // in bytes, of the source file for PDFs and EPUBs
+	Tags         []string // of the document, only listed over SSH
+	PageTags     []string // of the document's pages, only listed over SSH
 	DisplayPath  *string
 	TabletPath   []string
 }
@@ -57,6 +60,22 @@ func parseDocsResponse(bytes []byte) ([]DocInfo, error) {
 			info.FileType = &t
 		}
 
+		if n, ok := item["pageCount"].(float64); ok {
+			pageCount := int(n)
+			info.PageCount = &pageCount
+		}
+
+		/* The size is a string in some firmware versions */
+		switch v := item["sizeInBytes"].(type) {
+		case float64:
+			size := int64(v)
+			info.Size = &size
+		case string:
+			if size, err := strconv.ParseInt(v, 10, 64); err == nil {
+				info.Size = &size
+			}
+		}
+
 		info.Bookmarked = item["Bookmarked"].(bool)
 
 		result = append(result, info)
@@ -117,102 +136,30 @@ func readDocs(tablet_addr string) ([]DocInfo, error) {
 	return result, nil
 }
 
+/* Reads the items through the USB web interface of the tablet. */
 type RmReader struct {
-	/* For an collection with DocId 'id', map[id] stores elements in that folder.
-	   For a root element the 'id' is empty
-	*/
-	children map[DocId][]DocInfo
+	docTree
 
-	docById map[DocId]DocInfo
+	tablet_addr string
+}
+
+func NewRmReader(tablet_addr string) *RmReader {
+	return &RmReader{
+		docTree:     newDocTree(nil),
+		tablet_addr: tablet_addr,
+	}
 }
 
 /*
 Reads all the items the rM tablet and stores them.
 Past items are cleared in case read() was called previously.
 */
-func (r *RmReader) Read(tablet_addr string) error {
-	r.children = make(map[DocId][]DocInfo)
-	r.docById = make(map[DocId]DocInfo)
-
-	docs, err := readDocs(tablet_addr)
+func (r *RmReader) Read() error {
+	docs, err := readDocs(r.tablet_addr)
 	if err != nil {
 		return err
 	}
 
-	for _, doc := range docs {
-		r.children[doc.ParentId] = append(r.children[doc.ParentId], doc)
-	}
-
-	for _, doc := range docs {
-		r.docById[doc.Id] = doc
-	}
-
+	r.docTree = newDocTree(docs)
 	return nil
 }
-
-func (r *RmReader) GetFolder(id DocId) []DocInfo {
-	if items, ok := r.children[id]; ok {
-		return items
-	}
-	return []DocInfo{}
-}
-
-func (r *RmReader) GetCheckedFiles(selection *FileSelection) []DocInfo {
-	ids := selection.GetCheckedItems()
-	files := r.getElementsByIds(ids)
-	r.fillPaths(files)
-
-	slices.SortFunc(files, func(i, j DocInfo) int {
-		c := strings.Compare(*i.DisplayPath, *j.DisplayPath)
-		if c != 0 {
-			return c
-		}
-		return strings.Compare(i.Id, j.Id)
-	})
-	return files
-}
-
-func (r *RmReader) getElementsByIds(ids []DocId) []DocInfo {
-	result := []DocInfo{}
-	for _, id := range ids {
-		result = append(result, r.docById[id])
-	}
-	return result
-}
-
-func (r *RmReader) GetChildrenMap() map[DocId][]DocInfo {
-	return r.children
-}
-
-func (r *RmReader) fillPaths(items []DocInfo) {
-	for i, item := range items {
-		p := r.getDisplayPath(item)
-		items[i].DisplayPath = &p
-		items[i].TabletPath = r.getTabletPath(item)
-	}
-}
-
-func (r *RmReader) getTabletPath(item DocInfo) []string {
-	id := item.Id
-
-	l := []string{}
-	for id != "" {
-		item := r.docById[id]
-		l = append(l, item.Name)
-		id = item.ParentId
-	}
-	slices.Reverse(l)
-
-	return l
-}
-
-func (r *RmReader) getDisplayPath(item DocInfo) string {
-	tabletPath := r.getTabletPath(item)
-	for i, x := range tabletPath {
-		if strings.Contains(x, "/") {
-			tabletPath[i] = "'" + x + "'"
-		}
-	}
-
-	return strings.Join(tabletPath, "/")
-}
@@ -0,0 +1,308 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/* Size of the tablet's screen in pixels, the size of a page that wasn't scrolled */
+const (
+	rmScreenWidth  = 1404
+	rmScreenHeight = 1872
+)
+
+type rmRGB [3]uint8
+
+/* Colors as the desktop app shows them */
+var rmColors = map[RmColor]rmRGB{
+	ColorBlack:       {0, 0, 0},
+	ColorGray:        {144, 144, 144},
+	ColorWhite:       {255, 255, 255},
+	ColorYellow:      {251, 247, 25},
+	ColorGreen:       {0, 255, 0},
+	ColorPink:        {255, 192, 203},
+	ColorBlue:        {78, 105, 201},
+	ColorRed:         {179, 62, 57},
+	ColorGrayOverlap: {125, 125, 125},
+	ColorHighlight:   {251, 247, 25},
+	ColorGreen2:      {161, 216, 125},
+	ColorCyan:        {139, 208, 229},
+	ColorMagenta:     {183, 130, 205},
+	ColorYellow2:     {247, 232, 81},
+}
+
+/* A part of a line drawn with the same width and opacity */
+type rmSegment struct {
+	Points  []RmPoint
+	Width   float64
+	Opacity float64
+}
+
+/* How a line is drawn, worked out from its pen. The points are in page coordinates. */
+type rmStroke struct {
+	Color    rmRGB
+	Square   bool // square line caps and joins instead of round ones
+	Segments []rmSegment
+}
+
+/* Returns the color of a line or a highlight, the ARGB value wins over the palette. */
+func rmColor(color RmColor, argb *uint32) rmRGB {
+	if argb != nil {
+		return rmRGB{uint8(*argb >> 16), uint8(*argb >> 8), uint8(*argb)}
+	}
+	if rgb, ok := rmColors[color]; ok {
+		return rgb
+	}
+	return rmColors[ColorBlack]
+}
+
+func clamp(v, low, high float64) float64 {
+	return math.Max(low, math.Min(high, v))
+}
+
+/*
+Works out the segments of a line from its pen, after the desktop app: the width of pens like the
+ballpoint or the brush follows the pressure, the speed and the tilt of the pencil at each point,
+the highlighter and the shader are transparent. dx moves the points to page coordinates.
+Eraser lines aren't drawn, the tablet has already cut the lines they erased.
+*/
+func rmLineStroke(line RmLine, dx float64) (rmStroke, bool) {
+	stroke := rmStroke{Color: rmColor(line.Color, line.ARGB)}
+	base := line.ThicknessScale
+
+	/* Returns the width and the opacity at a point; last is the width of the previous segment */
+	var style func(p RmPoint, last float64) (float64, float64)
+	every := 1 // points per segment, the style is computed again for each segment
+	constant := func(width, opacity float64) func(RmPoint, float64) (float64, float64) {
+		every = len(line.Points)
+		return func(RmPoint, float64) (float64, float64) { return width, opacity }
+	}
+
+	switch line.Pen {
+	case PenEraser, PenEraseArea:
+		return stroke, false
+	case PenBallpoint1, PenBallpoint2:
+		every = 5
+		style = func(p RmPoint, _ float64) (float64, float64) {
+			speed, pressure := float64(p.Speed), float64(p.Pressure)
+			return (0.5 + pressure) + float64(p.Width) - 0.5*(speed/50),
+				clamp(0.1*-(speed/35)+1.2*pressure+0.5, 0, 1)
+		}
+	case PenFineliner1, PenFineliner2:
+		style = constant(math.Pow(base, 2.1)*1.3, 1)
+	case PenMarker1, PenMarker2:
+		every = 3
+		style = func(p RmPoint, last float64) (float64, float64) {
+			return 0.9*(float64(p.Width)-0.4*float64(p.Direction)) + 0.1*last, 1
+		}
+	case PenPencil1, PenPencil2:
+		every = 2
+		style = func(p RmPoint, _ float64) (float64, float64) {
+			speed, pressure, tilt := float64(p.Speed), float64(p.Pressure), float64(p.Direction)
+			width := 0.7 * (((0.8*base)+(0.5*pressure))*float64(p.Width) - 0.25*math.Pow(tilt, 1.8) - 0.6*speed/50)
+			return math.Min(width, base*10), clamp(0.1*-(speed/35)+pressure, 0, 1) - 0.1
+		}
+	case PenMechanicalPencil1, PenMechanicalPencil2:
+		style = constant(base*base, 0.7)
+	case PenPaintbrush1, PenPaintbrush2:
+		every = 2
+		style = func(p RmPoint, _ float64) (float64, float64) {
+			speed, pressure := float64(p.Speed), float64(p.Pressure)
+			return 0.7 * ((1+1.4*pressure)*float64(p.Width) - 0.5*float64(p.Direction) - 0.5*speed/50),
+				clamp((math.Pow(pressure, 1.5)-0.2*(speed/50))*1.5, 0, 1)
+		}
+	case PenCalligraphy:
+		every = 2
+		style = func(p RmPoint, last float64) (float64, float64) {
+			return 0.9*((1+float64(p.Pressure))*float64(p.Width)-0.3*float64(p.Direction)) + 0.1*last, 1
+		}
+	case PenHighlighter1, PenHighlighter2:
+		stroke.Square = true
+		if line.ARGB == nil && line.Color == ColorBlack {
+			stroke.Color = rmColors[ColorYellow] // highlighters of older firmware have no color
+		}
+		style = constant(15, 0.3)
+	case PenShader:
+		style = constant(12*base, 0.1)
+	default:
+		style = constant(base*2, 1)
+	}
+
+	points := make([]RmPoint, len(line.Points))
+	for i, p := range line.Points {
+		p.X += float32(dx)
+		points[i] = p
+	}
+	if len(points) == 0 {
+		return stroke, false
+	}
+	if len(points) == 1 {
+		points = append(points, points[0]) // a dot
+	}
+	if every < 1 {
+		every = 1
+	}
+
+	/* Segments share their first point with the end of the previous one, so that the line is continuous */
+	last := base
+	for start := 0; start < len(points); start += every {
+		end := min(start+every+1, len(points))
+		if end-start < 2 && start > 0 {
+			break
+		}
+		width, opacity := style(points[start], last)
+		width = math.Max(width, 0.1)
+		stroke.Segments = append(stroke.Segments, rmSegment{
+			Points:  points[start:end],
+			Width:   width,
+			Opacity: clamp(opacity, 0, 1),
+		})
+		last = width
+	}
+	return stroke, true
+}
+
+/* Offset from the page's coordinates to the tablet's: version 6 measures x from the center of the screen */
+func rmOffsetX(page *RmPage) float64 {
+	if page.Version >= 6 {
+		return rmScreenWidth / 2
+	}
+	return 0
+}
+
+/*
+Returns the area of the page that has to be shown: the screen, grown to fit lines that were drawn
+outside of it in a scrolled or zoomed out page.
+*/
+func rmPageBounds(page *RmPage) (minX, minY, maxX, maxY float64) {
+	minX, minY, maxX, maxY = 0, 0, rmScreenWidth, rmScreenHeight
+	dx := rmOffsetX(page)
+	for _, layer := range page.Layers {
+		if !layer.Visible {
+			continue
+		}
+		for _, line := range layer.Lines {
+			for _, p := range line.Points {
+				minX, maxX = math.Min(minX, float64(p.X)+dx), math.Max(maxX, float64(p.X)+dx)
+				minY, maxY = math.Min(minY, float64(p.Y)), math.Max(maxY, float64(p.Y))
+			}
+		}
+	}
+	return math.Floor(minX), math.Floor(minY), math.Ceil(maxX), math.Ceil(maxY)
+}
+
+/* Typed text is drawn with a fixed font, it's wrapped at the width of the text block */
+const (
+	rmTextFontSize   = 32
+	rmTextLineHeight = 52
+)
+
+/* Splits the page's text into the lines it is drawn as, wrapping at about the width of the text block. */
+func rmTextLines(text *RmText) []string {
+	maxChars := int(float64(text.Width) / (rmTextFontSize * 0.5))
+	if maxChars < 10 {
+		maxChars = 10
+	}
+
+	lines := []string{}
+	for _, paragraph := range strings.Split(text.String(), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			if line != "" && len([]rune(line))+1+len([]rune(word)) > maxChars {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func svgNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+func svgColor(c rmRGB) string {
+	return fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+}
+
+/*
+Renders a page as SVG, one group per layer with hidden layers kept but not displayed.
+The SVG is in the tablet's pixels and covers the whole drawing, see rmPageBounds.
+*/
+func RenderRmPageSVG(page *RmPage) []byte {
+	var b bytes.Buffer
+	minX, minY, maxX, maxY := rmPageBounds(page)
+	dx := rmOffsetX(page)
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="%s %s %s %s">`+"\n",
+		svgNumber(maxX-minX), svgNumber(maxY-minY), svgNumber(minX), svgNumber(minY), svgNumber(maxX-minX), svgNumber(maxY-minY))
+
+	if page.Text != nil {
+		fmt.Fprintf(&b, `<g class="text" font-family="sans-serif" font-size="%d">`+"\n", rmTextFontSize)
+		for i, line := range rmTextLines(page.Text) {
+			if line == "" {
+				continue
+			}
+			fmt.Fprintf(&b, `<text x="%s" y="%s">%s</text>`+"\n",
+				svgNumber(page.Text.X+dx), svgNumber(page.Text.Y+float64(i+1)*rmTextLineHeight), html.EscapeString(line))
+		}
+		b.WriteString("</g>\n")
+	}
+
+	for _, layer := range page.Layers {
+		b.WriteString(`<g class="layer"`)
+		if layer.Name != "" {
+			fmt.Fprintf(&b, ` id="%s"`, html.EscapeString(layer.Name))
+		}
+		if !layer.Visible {
+			b.WriteString(` display="none"`)
+		}
+		b.WriteString(">\n")
+
+		for _, highlight := range layer.Highlights {
+			color := svgColor(rmColor(highlight.Color, highlight.ARGB))
+			for _, rect := range highlight.Rects {
+				fmt.Fprintf(&b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s" fill-opacity="0.3"/>`+"\n",
+					svgNumber(rect.X+dx), svgNumber(rect.Y), svgNumber(rect.Width), svgNumber(rect.Height), color)
+			}
+		}
+
+		for _, line := range layer.Lines {
+			stroke, ok := rmLineStroke(line, dx)
+			if !ok {
+				continue
+			}
+			linecap, linejoin := "round", "round"
+			if stroke.Square {
+				linecap, linejoin = "square", "miter"
+			}
+			fmt.Fprintf(&b, `<g fill="none" stroke="%s" stroke-linecap="%s" stroke-linejoin="%s">`+"\n",
+				svgColor(stroke.Color), linecap, linejoin)
+			for _, segment := range stroke.Segments {
+				coords := make([]string, len(segment.Points))
+				for i, p := range segment.Points {
+					coords[i] = svgNumber(float64(p.X)) + "," + svgNumber(float64(p.Y))
+				}
+				fmt.Fprintf(&b, `<polyline points="%s" stroke-width="%s"`, strings.Join(coords, " "), svgNumber(segment.Width))
+				if segment.Opacity < 1 {
+					fmt.Fprintf(&b, ` stroke-opacity="%s"`, svgNumber(segment.Opacity))
+				}
+				b.WriteString("/>\n")
+			}
+			b.WriteString("</g>\n")
+		}
+		b.WriteString("</g>\n")
+	}
+
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
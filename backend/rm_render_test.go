@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testLine(pen RmPen, color RmColor, n int) RmLine {
+	line := RmLine{Pen: pen, Color: color, ThicknessScale: 2}
+	for i := 0; i < n; i++ {
+		line.Points = append(line.Points, RmPoint{X: float32(i * 10), Y: 100, Width: 2, Pressure: float32(i) / float32(n)})
+	}
+	return line
+}
+
+func TestRmLineStroke(t *testing.T) {
+	/* The ballpoint gets wider with the pressure, the segments are connected */
+	stroke, ok := rmLineStroke(testLine(PenBallpoint2, ColorBlue, 12), 702)
+	if !ok || len(stroke.Segments) != 3 || stroke.Color != rmColors[ColorBlue] || stroke.Square {
+		t.Fatalf("unexpected ballpoint stroke: %+v", stroke)
+	}
+	widths := []int{}
+	for i, segment := range stroke.Segments {
+		widths = append(widths, int(segment.Width*100))
+		if i > 0 && segment.Points[0] != stroke.Segments[i-1].Points[len(stroke.Segments[i-1].Points)-1] {
+			t.Errorf("segment %d isn't connected to the previous one", i)
+		}
+	}
+	if diff := cmp.Diff([]int{250, 291, 333}, widths); diff != "" {
+		t.Errorf("widths mismatch (-want +got):\n%s", diff)
+	}
+	if x := stroke.Segments[0].Points[0].X; x != 702 {
+		t.Errorf("points weren't moved to page coordinates: %v", x)
+	}
+
+	stroke, _ = rmLineStroke(testLine(PenFineliner2, ColorBlack, 12), 0)
+	if len(stroke.Segments) != 1 || len(stroke.Segments[0].Points) != 12 || stroke.Segments[0].Width != math.Pow(2, 2.1)*1.3 {
+		t.Errorf("unexpected fineliner stroke: %+v", stroke)
+	}
+
+	/* Highlighters of older firmware are black in the file */
+	stroke, _ = rmLineStroke(testLine(PenHighlighter1, ColorBlack, 3), 0)
+	if !stroke.Square || stroke.Color != rmColors[ColorYellow] || stroke.Segments[0].Opacity != 0.3 {
+		t.Errorf("unexpected highlighter stroke: %+v", stroke)
+	}
+	argb := uint32(0xff00a0ff)
+	line := testLine(PenHighlighter2, ColorHighlight, 3)
+	line.ARGB = &argb
+	if stroke, _ = rmLineStroke(line, 0); stroke.Color != (rmRGB{0x00, 0xa0, 0xff}) {
+		t.Errorf("ARGB color was ignored: %+v", stroke.Color)
+	}
+
+	if stroke, _ = rmLineStroke(testLine(PenPencil2, ColorBlack, 1), 0); len(stroke.Segments) != 1 || len(stroke.Segments[0].Points) != 2 {
+		t.Errorf("a dot wasn't drawn: %+v", stroke)
+	}
+	if _, ok := rmLineStroke(testLine(PenEraseArea, ColorBlack, 5), 0); ok {
+		t.Errorf("an eraser line was drawn")
+	}
+}
+
+func TestRenderRmPageSVG(t *testing.T) {
+	scrolled := testLine(PenFineliner2, ColorRed, 2)
+	scrolled.Points[1].Y = 2500
+
+	page := &RmPage{
+		Version: 6,
+		Layers: []RmLayer{
+			{Name: "Layer <1>", Visible: true,
+				Lines:      []RmLine{testLine(PenBallpoint2, ColorBlack, 12), scrolled},
+				Highlights: []RmHighlight{{Color: ColorYellow, Rects: []RmRect{{X: -100, Y: 50, Width: 200, Height: 20}}}},
+			},
+			{Name: "Hidden", Visible: false, Lines: []RmLine{testLine(PenMarker2, ColorBlack, 4)}},
+		},
+		Text: &RmText{
+			Items: []RmTextItem{{ItemId: CrdtId{1, 1}, LeftId: rmEndMarker, RightId: rmEndMarker, Text: "Hello & goodbye\n\nworld"}},
+			X:     -468, Y: 234, Width: 936,
+		},
+	}
+
+	type element struct {
+		name  string
+		attrs map[string]string
+		text  string
+	}
+	elements := []element{}
+	decoder := xml.NewDecoder(strings.NewReader(string(RenderRmPageSVG(page))))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			break
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			attrs := map[string]string{}
+			for _, attr := range token.Attr {
+				attrs[attr.Name.Local] = attr.Value
+			}
+			elements = append(elements, element{name: token.Name.Local, attrs: attrs})
+		case xml.CharData:
+			if len(elements) > 0 {
+				elements[len(elements)-1].text += string(token)
+			}
+		}
+	}
+
+	counts := map[string]int{}
+	for _, e := range elements {
+		counts[e.name]++
+	}
+	/* Groups for the text, 2 layers and 3 lines; the ballpoint line has 3 segments */
+	if diff := cmp.Diff(map[string]int{"svg": 1, "g": 6, "text": 2, "rect": 1, "polyline": 5}, counts); diff != "" {
+		t.Errorf("elements mismatch (-want +got):\n%s", diff)
+	}
+
+	svg := elements[0]
+	if svg.attrs["viewBox"] != "0 0 1404 2500" || svg.attrs["height"] != "2500" {
+		t.Errorf("the page wasn't grown to fit the drawing: %v", svg.attrs)
+	}
+
+	layers := []string{}
+	texts := []string{}
+	for _, e := range elements {
+		if e.attrs["class"] == "layer" {
+			layers = append(layers, e.attrs["id"]+"/"+e.attrs["display"])
+		}
+		if e.name == "text" {
+			texts = append(texts, e.attrs["x"]+","+e.attrs["y"]+":"+strings.TrimSpace(e.text))
+		}
+		if e.name == "rect" && e.attrs["x"] != "602" {
+			t.Errorf("highlight wasn't moved to page coordinates: %v", e.attrs)
+		}
+	}
+	if diff := cmp.Diff([]string{"Layer <1>/", "Hidden/none"}, layers); diff != "" {
+		t.Errorf("layers mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"234,286:Hello & goodbye", "234,390:world"}, texts); diff != "" {
+		t.Errorf("text mismatch (-want +got):\n%s", diff)
+	}
+}
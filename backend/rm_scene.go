@@ -0,0 +1,316 @@
+package backend
+
+import (
+	"bytes"
+	"cmp"
+	"container/heap"
+	"fmt"
+	"strings"
+)
+
+/*
+Pages of notebooks and annotations are stored as .rm files, one per page, in the document's directory.
+Version 6 (firmware 3.0 and later) stores a scene tree of CRDT items, so that the tablet can merge
+the changes made on several devices. Versions 3 and 5 store plain lists of layers and lines.
+*/
+const (
+	rmHeaderV3 = "reMarkable .lines file, version=3          "
+	rmHeaderV5 = "reMarkable .lines file, version=5          "
+	rmHeaderV6 = "reMarkable .lines file, version=6          "
+)
+
+/* Identifies an item of the scene: the author's number and a counter. */
+type CrdtId struct {
+	Part1 uint8
+	Part2 uint64
+}
+
+func (id CrdtId) compare(other CrdtId) int {
+	return cmp.Or(cmp.Compare(id.Part1, other.Part1), cmp.Compare(id.Part2, other.Part2))
+}
+
+/* The parent of the layers */
+var rmRootId = CrdtId{0, 1}
+
+/* Marks the start and the end of a CRDT sequence in the left and right IDs */
+var rmEndMarker = CrdtId{0, 0}
+
+/* The tool a line was drawn with. The tablet has two numbers for most tools, from different firmware versions. */
+type RmPen uint32
+
+const (
+	PenPaintbrush1       RmPen = 0
+	PenPencil1           RmPen = 1
+	PenBallpoint1        RmPen = 2
+	PenMarker1           RmPen = 3
+	PenFineliner1        RmPen = 4
+	PenHighlighter1      RmPen = 5
+	PenEraser            RmPen = 6
+	PenMechanicalPencil1 RmPen = 7
+	PenEraseArea         RmPen = 8
+	PenPaintbrush2       RmPen = 12
+	PenMechanicalPencil2 RmPen = 13
+	PenPencil2           RmPen = 14
+	PenBallpoint2        RmPen = 15
+	PenMarker2           RmPen = 16
+	PenFineliner2        RmPen = 17
+	PenHighlighter2      RmPen = 18
+	PenCalligraphy       RmPen = 21
+	PenShader            RmPen = 23
+)
+
+type RmColor uint32
+
+const (
+	ColorBlack       RmColor = 0
+	ColorGray        RmColor = 1
+	ColorWhite       RmColor = 2
+	ColorYellow      RmColor = 3
+	ColorGreen       RmColor = 4
+	ColorPink        RmColor = 5
+	ColorBlue        RmColor = 6
+	ColorRed         RmColor = 7
+	ColorGrayOverlap RmColor = 8
+	ColorHighlight   RmColor = 9 // the actual color is in the ARGB value
+	ColorGreen2      RmColor = 10
+	ColorCyan        RmColor = 11
+	ColorMagenta     RmColor = 12
+	ColorYellow2     RmColor = 13
+)
+
+/*
+A point of a line. The coordinates are in screen pixels, x from the center of the page in v6
+and from the left edge in older versions. Version 6 stores the other values as small integers,
+they are converted to the units of the older versions.
+*/
+type RmPoint struct {
+	X, Y      float32
+	Speed     float32
+	Direction float32 // in radians
+	Width     float32
+	Pressure  float32 // from 0 to 1
+}
+
+type RmLine struct {
+	Pen            RmPen
+	Color          RmColor
+	ThicknessScale float64
+	StartingLength float32
+	Points         []RmPoint
+
+	Timestamp CrdtId
+	MoveId    *CrdtId
+	ARGB      *uint32 // color of highlighter and shader lines in recent firmware
+
+	version uint8     // of the block, decides the format of the points
+	extra   []byte    // fields after the known ones, written back as they are
+	unknown [2]uint32 // fields of the v3 and v5 lines whose meaning is unknown
+}
+
+/* A rectangle of highlighted text, in the coordinates of the lines */
+type RmRect struct {
+	X, Y, Width, Height float64
+}
+
+/* Text of a PDF or EPUB highlighted with the highlighter's text selection */
+type RmHighlight struct {
+	Start  *uint32 // of the highlighted text in the page's text, if known
+	Length uint32
+	Color  RmColor
+	Text   string
+	Rects  []RmRect
+	ARGB   *uint32
+
+	extra []byte
+}
+
+/* Paragraph styles of typed text */
+const (
+	TextStyleBasic           = 0
+	TextStylePlain           = 1
+	TextStyleHeading         = 2
+	TextStyleBold            = 3
+	TextStyleBullet          = 4
+	TextStyleBullet2         = 5
+	TextStyleCheckbox        = 6
+	TextStyleCheckboxChecked = 7
+)
+
+/* The typed text of a page, a CRDT sequence of strings. */
+type RmText struct {
+	Items   []RmTextItem
+	Formats []RmTextFormat
+	X, Y    float64
+	Width   float32
+
+	BlockId CrdtId
+}
+
+/* A piece of text. Deleted pieces have no text but a deleted length. */
+type RmTextItem struct {
+	ItemId, LeftId, RightId CrdtId
+	DeletedLength           uint32
+	Text                    string
+	Format                  *uint32 // instead of text, a paragraph style marker
+}
+
+/* The paragraph style starting at a character */
+type RmTextFormat struct {
+	CharId    CrdtId
+	Timestamp CrdtId
+	Style     uint8
+}
+
+/* Returns the text in the order of the sequence, deleted pieces and style markers left out. */
+func (t *RmText) String() string {
+	items := map[CrdtId]RmTextItem{}
+	for _, item := range t.Items {
+		items[item.ItemId] = item
+	}
+
+	var sb strings.Builder
+	for _, id := range crdtOrder(t.Items, func(item RmTextItem) (CrdtId, CrdtId, CrdtId) {
+		return item.ItemId, item.LeftId, item.RightId
+	}) {
+		sb.WriteString(items[id].Text)
+	}
+	return sb.String()
+}
+
+type RmLayer struct {
+	Name       string
+	Visible    bool
+	Lines      []RmLine
+	Highlights []RmHighlight
+}
+
+/*
+A parsed .rm page. Layers and Text are what the page shows; for version 6, Scene keeps
+the blocks the page was read from, and the page is written back from them.
+*/
+type RmPage struct {
+	Version int // 3, 5 or 6
+	Layers  []RmLayer
+	Text    *RmText // nil if the page has no typed text
+
+	Scene *RmScene // nil for versions 3 and 5
+}
+
+/* Parses a .rm page of any version. */
+func ParseRmPage(data []byte) (*RmPage, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(rmHeaderV6)):
+		scene, err := parseRmScene(data[len(rmHeaderV6):])
+		if err != nil {
+			return nil, err
+		}
+		page := scene.page()
+		return &page, nil
+	case bytes.HasPrefix(data, []byte(rmHeaderV5)):
+		return parseRmLegacy(data[len(rmHeaderV5):], 5)
+	case bytes.HasPrefix(data, []byte(rmHeaderV3)):
+		return parseRmLegacy(data[len(rmHeaderV3):], 3)
+	}
+	return nil, fmt.Errorf("not a .rm file")
+}
+
+/* Writes a page in its version. Version 6 pages are written from their scene, versions 3 and 5 from their layers. */
+func EncodeRmPage(page *RmPage) ([]byte, error) {
+	switch page.Version {
+	case 6:
+		if page.Scene == nil {
+			return nil, fmt.Errorf("version 6 page without a scene")
+		}
+		return append([]byte(rmHeaderV6), page.Scene.encode()...), nil
+	case 3, 5:
+		return encodeRmLegacy(page), nil
+	}
+	return nil, fmt.Errorf("unsupported .rm version: %d", page.Version)
+}
+
+/* A node of the graph crdtOrder() sorts: an item, or the start or the end of the sequence */
+type crdtNode struct {
+	id    CrdtId
+	start bool // the start or the end of the sequence
+}
+
+/* Nodes ready to be taken, by level and then by ID */
+type crdtQueue []crdtQueued
+
+type crdtQueued struct {
+	node  crdtNode
+	level int // the longest path from a node with nothing before it
+}
+
+func (q crdtQueue) Len() int { return len(q) }
+func (q crdtQueue) Less(i, j int) bool {
+	return cmp.Or(cmp.Compare(q[i].level, q[j].level), q[i].node.id.compare(q[j].node.id)) < 0
+}
+func (q crdtQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *crdtQueue) Push(x any)   { *q = append(*q, x.(crdtQueued)) }
+func (q *crdtQueue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+/*
+Orders the items of a CRDT sequence. Every item comes after the item on its left and before the item
+on its right; rmEndMarker stands for the start on the left and for the end on the right.
+Items that could be in either order are taken level by level and sorted by ID, like the tablet does.
+*/
+func crdtOrder[T any](items []T, ids func(item T) (id, left, right CrdtId)) []CrdtId {
+	start, end := crdtNode{rmEndMarker, true}, crdtNode{rmEndMarker, false}
+
+	known := map[CrdtId]bool{}
+	before := map[crdtNode]int{}      // node -> the number of edges to it that are left
+	next := map[crdtNode][]crdtNode{} // node -> the nodes that come after it
+	addEdge := func(from, to crdtNode) {
+		next[from] = append(next[from], to)
+		before[to]++
+		if _, ok := before[from]; !ok {
+			before[from] = 0
+		}
+	}
+
+	for _, item := range items {
+		id, left, right := ids(item)
+		known[id] = true
+
+		leftNode, rightNode := crdtNode{left, false}, crdtNode{right, false}
+		if left == rmEndMarker {
+			leftNode = start
+		}
+		if right == rmEndMarker {
+			rightNode = end
+		}
+
+		addEdge(leftNode, crdtNode{id, false})
+		addEdge(crdtNode{id, false}, rightNode)
+	}
+
+	/* Nodes that are only referenced, e.g. deleted items, have nothing before them */
+	ready := crdtQueue{}
+	for n, count := range before {
+		if count == 0 {
+			ready = append(ready, crdtQueued{n, 0})
+		}
+	}
+	heap.Init(&ready)
+
+	/* Kahn's algorithm; nodes in a cycle never get ready and are left out */
+	result := []CrdtId{}
+	for ready.Len() > 0 {
+		q := heap.Pop(&ready).(crdtQueued)
+		if !q.node.start && q.node != end && known[q.node.id] {
+			result = append(result, q.node.id)
+		}
+		for _, n := range next[q.node] {
+			if before[n]--; before[n] == 0 {
+				heap.Push(&ready, crdtQueued{n, q.level + 1})
+			}
+		}
+	}
+	return result
+}
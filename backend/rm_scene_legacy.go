@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"fmt"
+)
+
+/*
+Versions 3 and 5 of the .rm format: the layers with their lines, all numbers are 32 bits.
+Version 5 has one more field per line, the points are the same.
+*/
+func parseRmLegacy(data []byte, version int) (*RmPage, error) {
+	s := &rmStream{data: data}
+	page := &RmPage{Version: version}
+
+	layerCount, err := s.uint32()
+	if err != nil {
+		return nil, err
+	}
+	for n := range layerCount {
+		layer := RmLayer{Name: fmt.Sprintf("Layer %d", n+1), Visible: true}
+
+		lineCount, err := s.uint32()
+		if err != nil {
+			return nil, err
+		}
+		for range lineCount {
+			line, err := readLegacyLine(s, version)
+			if err != nil {
+				return nil, fmt.Errorf("layer %d: %v", n+1, err)
+			}
+			layer.Lines = append(layer.Lines, line)
+		}
+		page.Layers = append(page.Layers, layer)
+	}
+	return page, nil
+}
+
+func readLegacyLine(s *rmStream, version int) (RmLine, error) {
+	line := RmLine{}
+
+	pen, err := s.uint32()
+	if err != nil {
+		return line, err
+	}
+	color, _ := s.uint32()
+	line.unknown[0], _ = s.uint32()
+	size, _ := s.float32()
+	if version == 5 {
+		line.unknown[1], _ = s.uint32()
+	}
+	pointCount, err := s.uint32()
+	if err != nil {
+		return line, err
+	}
+	if pointCount > uint32(s.remaining()/rmPointSizeV1) {
+		return line, fmt.Errorf("%d points don't fit in the file", pointCount)
+	}
+
+	line.Pen, line.Color, line.ThicknessScale = RmPen(pen), RmColor(color), float64(size)
+	line.Points, err = readPoints(&rmStream{data: s.data[s.pos : s.pos+int(pointCount)*rmPointSizeV1]}, 1)
+	s.pos += int(pointCount) * rmPointSizeV1
+	return line, err
+}
+
+func encodeRmLegacy(page *RmPage) []byte {
+	w := &rmWriter{}
+	if page.Version == 5 {
+		w.buf.WriteString(rmHeaderV5)
+	} else {
+		w.buf.WriteString(rmHeaderV3)
+	}
+
+	w.uint32(uint32(len(page.Layers)))
+	for _, layer := range page.Layers {
+		w.uint32(uint32(len(layer.Lines)))
+		for _, line := range layer.Lines {
+			w.uint32(uint32(line.Pen))
+			w.uint32(uint32(line.Color))
+			w.uint32(line.unknown[0])
+			w.float32(float32(line.ThicknessScale))
+			if page.Version == 5 {
+				w.uint32(line.unknown[1])
+			}
+			w.uint32(uint32(len(line.Points)))
+			writePoints(w, line.Points, 1)
+		}
+	}
+	return w.buf.Bytes()
+}
@@ -0,0 +1,286 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+/*
+Tagged values of the v6 .rm format. Every value is preceded by a varuint tag,
+the index of the field shifted left by 4 and the type of the value in the low 4 bits.
+*/
+const (
+	rmTagByte1    = 0x1
+	rmTagByte4    = 0x4
+	rmTagByte8    = 0x8
+	rmTagSubblock = 0xC // uint32 length, then the content
+	rmTagId       = 0xF // uint8, then varuint
+)
+
+var errRmEnd = errors.New("unexpected end of data")
+
+/* Reads the values of a block or a subblock, little-endian. */
+type rmStream struct {
+	data []byte
+	pos  int
+}
+
+func (s *rmStream) remaining() int {
+	return len(s.data) - s.pos
+}
+
+/* Returns the bytes that weren't read. */
+func (s *rmStream) rest() []byte {
+	return bytes.Clone(s.data[s.pos:])
+}
+
+func (s *rmStream) bytes(n int) ([]byte, error) {
+	if n < 0 || s.remaining() < n {
+		return nil, errRmEnd
+	}
+	b := s.data[s.pos : s.pos+n]
+	s.pos += n
+	return b, nil
+}
+
+func (s *rmStream) uint8() (uint8, error) {
+	b, err := s.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (s *rmStream) uint16() (uint16, error) {
+	b, err := s.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (s *rmStream) uint32() (uint32, error) {
+	b, err := s.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (s *rmStream) float32() (float32, error) {
+	v, err := s.uint32()
+	return math.Float32frombits(v), err
+}
+
+func (s *rmStream) float64() (float64, error) {
+	b, err := s.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+func (s *rmStream) varuint() (uint64, error) {
+	v, n := binary.Uvarint(s.data[s.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid varuint at %d", s.pos)
+	}
+	s.pos += n
+	return v, nil
+}
+
+func (s *rmStream) crdtId() (CrdtId, error) {
+	part1, err := s.uint8()
+	if err != nil {
+		return CrdtId{}, err
+	}
+	part2, err := s.varuint()
+	return CrdtId{part1, part2}, err
+}
+
+/* Returns true if the next value has the tag, without reading it. */
+func (s *rmStream) hasTag(index, tagType uint64) bool {
+	pos := s.pos
+	defer func() { s.pos = pos }()
+
+	tag, err := s.varuint()
+	return err == nil && tag == index<<4|tagType
+}
+
+func (s *rmStream) tag(index, tagType uint64) error {
+	pos := s.pos
+	tag, err := s.varuint()
+	if err != nil {
+		return err
+	}
+	if tag != index<<4|tagType {
+		s.pos = pos
+		return fmt.Errorf("expected tag %d/%x at %d, got %d/%x", index, tagType, pos, tag>>4, tag&0xF)
+	}
+	return nil
+}
+
+func (s *rmStream) id(index uint64) (CrdtId, error) {
+	if err := s.tag(index, rmTagId); err != nil {
+		return CrdtId{}, err
+	}
+	return s.crdtId()
+}
+
+func (s *rmStream) bool(index uint64) (bool, error) {
+	if err := s.tag(index, rmTagByte1); err != nil {
+		return false, err
+	}
+	v, err := s.uint8()
+	return v != 0, err
+}
+
+func (s *rmStream) int(index uint64) (uint32, error) {
+	if err := s.tag(index, rmTagByte4); err != nil {
+		return 0, err
+	}
+	return s.uint32()
+}
+
+func (s *rmStream) float(index uint64) (float32, error) {
+	if err := s.tag(index, rmTagByte4); err != nil {
+		return 0, err
+	}
+	return s.float32()
+}
+
+func (s *rmStream) double(index uint64) (float64, error) {
+	if err := s.tag(index, rmTagByte8); err != nil {
+		return 0, err
+	}
+	return s.float64()
+}
+
+/* Reads a subblock and returns a stream over its content. */
+func (s *rmStream) subblock(index uint64) (*rmStream, error) {
+	if err := s.tag(index, rmTagSubblock); err != nil {
+		return nil, err
+	}
+	length, err := s.uint32()
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.bytes(int(length))
+	if err != nil {
+		return nil, err
+	}
+	return &rmStream{data: data}, nil
+}
+
+/* A string is a subblock with its length, an "is ASCII" flag and the UTF-8 bytes. */
+func (s *rmStream) string(index uint64) (string, error) {
+	sub, err := s.subblock(index)
+	if err != nil {
+		return "", err
+	}
+	return sub.stringContent()
+}
+
+func (s *rmStream) stringContent() (string, error) {
+	length, err := s.varuint()
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.uint8(); err != nil {
+		return "", err
+	}
+	b, err := s.bytes(int(length))
+	return string(b), err
+}
+
+/* Writes the values of a block or a subblock, the counterpart of rmStream. */
+type rmWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *rmWriter) uint8(v uint8) {
+	w.buf.WriteByte(v)
+}
+
+func (w *rmWriter) uint16(v uint16) {
+	w.buf.Write(binary.LittleEndian.AppendUint16(nil, v))
+}
+
+func (w *rmWriter) uint32(v uint32) {
+	w.buf.Write(binary.LittleEndian.AppendUint32(nil, v))
+}
+
+func (w *rmWriter) float32(v float32) {
+	w.uint32(math.Float32bits(v))
+}
+
+func (w *rmWriter) float64(v float64) {
+	w.buf.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func (w *rmWriter) varuint(v uint64) {
+	w.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (w *rmWriter) crdtId(id CrdtId) {
+	w.uint8(id.Part1)
+	w.varuint(id.Part2)
+}
+
+func (w *rmWriter) tag(index, tagType uint64) {
+	w.varuint(index<<4 | tagType)
+}
+
+func (w *rmWriter) id(index uint64, id CrdtId) {
+	w.tag(index, rmTagId)
+	w.crdtId(id)
+}
+
+func (w *rmWriter) bool(index uint64, v bool) {
+	w.tag(index, rmTagByte1)
+	if v {
+		w.uint8(1)
+	} else {
+		w.uint8(0)
+	}
+}
+
+func (w *rmWriter) int(index uint64, v uint32) {
+	w.tag(index, rmTagByte4)
+	w.uint32(v)
+}
+
+func (w *rmWriter) float(index uint64, v float32) {
+	w.tag(index, rmTagByte4)
+	w.float32(v)
+}
+
+func (w *rmWriter) double(index uint64, v float64) {
+	w.tag(index, rmTagByte8)
+	w.float64(v)
+}
+
+/* Writes a subblock with the content written by 'content'. */
+func (w *rmWriter) subblock(index uint64, content func(w *rmWriter)) {
+	sub := &rmWriter{}
+	content(sub)
+
+	w.tag(index, rmTagSubblock)
+	w.uint32(uint32(sub.buf.Len()))
+	w.buf.Write(sub.buf.Bytes())
+}
+
+func (w *rmWriter) string(index uint64, v string) {
+	w.subblock(index, func(w *rmWriter) {
+		w.stringContent(v)
+	})
+}
+
+func (w *rmWriter) stringContent(v string) {
+	w.varuint(uint64(len(v)))
+	w.uint8(1)
+	w.buf.WriteString(v)
+}
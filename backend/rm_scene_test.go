@@ -0,0 +1,291 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+/* A v6 line block written by hand: a ballpoint line with one point, directly under the root */
+const rmLineBlockHex = "45000000" + "00020205" + // length, reserved, min version, version, type
+	"1f0001" + "2f0105" + "3f0000" + "4f0000" + "5400000000" + // parent, item, left, right, deleted length
+	"6c2f000000" + "03" + // value subblock, line item
+	"140f000000" + "2400000000" + "380000000000000040" + "4400000000" + // pen, color, thickness scale, starting length
+	"5c0e000000" + "0000803f" + "00000040" + "0800" + "0c00" + "00" + "ff" + // points: x, y, speed, width, direction, pressure
+	"6f0106" // timestamp
+
+func TestParseRmLineBlock(t *testing.T) {
+	block, err := hex.DecodeString(rmLineBlockHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte(rmHeaderV6), block...)
+
+	page, err := ParseRmPage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Version != 6 || len(page.Layers) != 1 || len(page.Layers[0].Lines) != 1 {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+
+	line := page.Layers[0].Lines[0]
+	if line.Pen != PenBallpoint2 || line.Color != ColorBlack || line.ThicknessScale != 2 || line.Timestamp != (CrdtId{1, 6}) {
+		t.Errorf("unexpected line: %+v", line)
+	}
+	want := []RmPoint{{X: 1, Y: 2, Speed: 2, Width: 3, Direction: 0, Pressure: 1}}
+	if diff := cmp.Diff(want, line.Points); diff != "" {
+		t.Errorf("points mismatch (-want +got):\n%s", diff)
+	}
+
+	encoded, err := EncodeRmPage(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, encoded) {
+		t.Errorf("round trip changed the page:\n%x\n%x", data, encoded)
+	}
+}
+
+func u32(v uint32) *uint32 {
+	return &v
+}
+
+/* A page with two layers, lines out of order in the file, a deleted line, a highlight, typed text and an unknown block */
+func testRmScene() *RmScene {
+	layer1, layer2 := CrdtId{0, 11}, CrdtId{0, 12}
+	line := func(pen RmPen, x float32, version uint8) *RmLine {
+		return &RmLine{
+			Pen: pen, Color: ColorBlue, ThicknessScale: 1.5, Timestamp: CrdtId{1, 1},
+			Points: []RmPoint{
+				{X: x, Y: 10, Speed: 1.25, Width: 2.5, Direction: 0, Pressure: float32(128) / 255},
+				{X: x + 5, Y: 20, Speed: 0.5, Width: 3, Direction: 0, Pressure: 1},
+			},
+			version: version,
+		}
+	}
+	item := func(parent, id, left, right CrdtId) *RmSceneItem {
+		return &RmSceneItem{ParentId: parent, ItemId: id, LeftId: left, RightId: right, hasValue: true}
+	}
+
+	group1 := item(rmRootId, CrdtId{1, 20}, rmEndMarker, rmEndMarker)
+	group1.Group, group1.itemType = &layer1, rmItemGroup
+	group2 := item(rmRootId, CrdtId{1, 21}, CrdtId{1, 20}, rmEndMarker)
+	group2.Group, group2.itemType = &layer2, rmItemGroup
+
+	/* The second line is before the first one in the file */
+	second := item(layer1, CrdtId{1, 31}, CrdtId{1, 30}, rmEndMarker)
+	second.Line, second.itemType = line(PenFineliner2, 200, 2), rmItemLine
+	first := item(layer1, CrdtId{1, 30}, rmEndMarker, rmEndMarker)
+	first.Line, first.itemType = line(PenBallpoint2, 100, 2), rmItemLine
+	deleted := &RmSceneItem{ParentId: layer1, ItemId: CrdtId{1, 32}, LeftId: CrdtId{1, 31}, DeletedLength: 1}
+	oldLine := item(layer2, CrdtId{1, 40}, rmEndMarker, rmEndMarker)
+	oldLine.Line, oldLine.itemType = line(PenPencil1, 300, 1), rmItemLine
+	oldLine.Line.ARGB = u32(0xff00ff00)
+
+	highlight := item(layer2, CrdtId{1, 41}, CrdtId{1, 40}, rmEndMarker)
+	highlight.Highlight, highlight.itemType = &RmHighlight{
+		Start: u32(5), Length: 4, Color: ColorYellow, Text: "word",
+		Rects: []RmRect{{X: 1, Y: 2, Width: 30, Height: 12}},
+	}, rmItemGlyph
+
+	text := &RmText{
+		Items: []RmTextItem{
+			{ItemId: CrdtId{1, 51}, LeftId: CrdtId{1, 50}, RightId: rmEndMarker, Text: "world"},
+			{ItemId: CrdtId{1, 50}, LeftId: rmEndMarker, RightId: rmEndMarker, Text: "Hello\n"},
+			{ItemId: CrdtId{1, 52}, LeftId: CrdtId{1, 51}, RightId: rmEndMarker, DeletedLength: 3},
+			{ItemId: CrdtId{1, 53}, LeftId: CrdtId{1, 52}, RightId: rmEndMarker, Format: u32(1)},
+		},
+		Formats: []RmTextFormat{{CharId: CrdtId{1, 50}, Timestamp: CrdtId{1, 54}, Style: TextStyleHeading}},
+		X:       -468, Y: 234, Width: 936,
+	}
+
+	return &RmScene{Blocks: []RmBlock{
+		{Type: rmBlockAuthorIds, MinVersion: 1, Version: 1, data: []byte{0x01, 0x0c, 0x13, 0x00, 0x00, 0x00, 0x10}},
+		{Type: rmBlockTreeNode, MinVersion: 1, Version: 1, Node: &RmTreeNode{NodeId: layer1, Label: "Layer 1", Visible: true}},
+		{Type: rmBlockTreeNode, MinVersion: 1, Version: 1, Node: &RmTreeNode{NodeId: layer2, Label: "Hidden", LabelTime: CrdtId{1, 2}, Visible: false}},
+		{Type: rmBlockSceneTree, MinVersion: 1, Version: 1, TreeMove: &RmTreeMove{TreeId: layer1, NodeId: rmEndMarker, IsUpdate: true, ParentId: rmRootId}},
+		{Type: rmBlockGroupItem, MinVersion: 1, Version: 1, Item: group2},
+		{Type: rmBlockGroupItem, MinVersion: 1, Version: 1, Item: group1},
+		{Type: rmBlockLineItem, MinVersion: 2, Version: 2, Item: second},
+		{Type: rmBlockLineItem, MinVersion: 2, Version: 2, Item: first},
+		{Type: rmBlockLineItem, MinVersion: 2, Version: 2, Item: deleted},
+		{Type: rmBlockLineItem, MinVersion: 1, Version: 1, Item: oldLine},
+		{Type: rmBlockGlyphItem, MinVersion: 1, Version: 1, Item: highlight},
+		{Type: rmBlockRootText, MinVersion: 1, Version: 1, Text: text},
+	}}
+}
+
+func TestRmSceneRoundTrip(t *testing.T) {
+	data, err := EncodeRmPage(&RmPage{Version: 6, Scene: testRmScene()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := ParseRmPage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layers := []string{}
+	for _, layer := range page.Layers {
+		pens := []string{}
+		for _, line := range layer.Lines {
+			pens = append(pens, string(rune('0'+line.Pen%10)))
+		}
+		layers = append(layers, layer.Name+":"+strings.Join(pens, ",")+":"+map[bool]string{true: "visible", false: "hidden"}[layer.Visible])
+	}
+	/* Ballpoint (15) before fineliner (17), the deleted line isn't there */
+	if diff := cmp.Diff([]string{"Layer 1:5,7:visible", "Hidden:1:hidden"}, layers); diff != "" {
+		t.Errorf("layers mismatch (-want +got):\n%s", diff)
+	}
+
+	want := testRmScene().Blocks[7].Item.Line.Points
+	if diff := cmp.Diff(want, page.Layers[0].Lines[0].Points); diff != "" {
+		t.Errorf("points mismatch (-want +got):\n%s", diff)
+	}
+	if line := page.Layers[1].Lines[0]; line.ARGB == nil || *line.ARGB != 0xff00ff00 || line.Points[0].Pressure != float32(128)/255 {
+		t.Errorf("unexpected version 1 line: %+v", line)
+	}
+
+	highlights := page.Layers[1].Highlights
+	if len(highlights) != 1 || highlights[0].Text != "word" || *highlights[0].Start != 5 || highlights[0].Rects[0].Width != 30 {
+		t.Errorf("unexpected highlights: %+v", highlights)
+	}
+
+	if page.Text == nil || page.Text.String() != "Hello\nworld" || page.Text.Formats[0].Style != TextStyleHeading {
+		t.Errorf("unexpected text: %+v", page.Text)
+	}
+
+	encoded, err := EncodeRmPage(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, encoded) {
+		t.Errorf("round trip changed the page:\n%x\n%x", data, encoded)
+	}
+}
+
+func TestRmSceneUnreadableBlock(t *testing.T) {
+	/* A line block with a value that isn't a line is kept as it is */
+	block, _ := hex.DecodeString("0b000000" + "00020205" + "1f0001" + "2f0105" + "6c00000000")
+	data := append([]byte(rmHeaderV6), block...)
+
+	page, err := ParseRmPage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Layers) != 0 || len(page.Scene.Blocks) != 1 {
+		t.Errorf("unexpected page: %+v", page)
+	}
+	if encoded, _ := EncodeRmPage(page); !bytes.Equal(data, encoded) {
+		t.Errorf("the unreadable block changed:\n%x\n%x", data, encoded)
+	}
+
+	if _, err := ParseRmPage(append([]byte(rmHeaderV6), block[:10]...)); err == nil {
+		t.Errorf("truncated block was accepted")
+	}
+	if _, err := ParseRmPage([]byte("%PDF-1.7")); err == nil {
+		t.Errorf("a PDF was accepted")
+	}
+}
+
+func TestRmLegacyRoundTrip(t *testing.T) {
+	for _, version := range []int{3, 5} {
+		var buf bytes.Buffer
+		header := map[int]string{3: rmHeaderV3, 5: rmHeaderV5}[version]
+		buf.WriteString(header)
+
+		write := func(values ...interface{}) {
+			for _, v := range values {
+				binary.Write(&buf, binary.LittleEndian, v)
+			}
+		}
+		write(uint32(2))                                                         // layers
+		write(uint32(1))                                                         // lines of the first layer
+		write(uint32(PenFineliner1), uint32(ColorGray), uint32(0), float32(2.0)) // pen, color, unknown, base size
+		if version == 5 {
+			write(uint32(7))
+		}
+		write(uint32(2)) // points
+		write(float32(10), float32(20), float32(0.1), float32(1.5), float32(2), float32(0.3))
+		write(float32(11), float32(21), float32(0.2), float32(1.6), float32(2), float32(0.4))
+		write(uint32(0)) // no lines in the second layer
+
+		page, err := ParseRmPage(buf.Bytes())
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if page.Version != version || len(page.Layers) != 2 || page.Layers[1].Name != "Layer 2" {
+			t.Fatalf("v%d: unexpected page: %+v", version, page)
+		}
+		line := page.Layers[0].Lines[0]
+		if line.Pen != PenFineliner1 || line.ThicknessScale != 2 || line.Points[1] != (RmPoint{11, 21, 0.2, 1.6, 2, 0.4}) {
+			t.Errorf("v%d: unexpected line: %+v", version, line)
+		}
+
+		encoded, err := EncodeRmPage(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), encoded) {
+			t.Errorf("v%d: round trip changed the page", version)
+		}
+	}
+}
+
+func TestCrdtOrder(t *testing.T) {
+	type item struct{ id, left, right CrdtId }
+	id := func(n uint64) CrdtId { return CrdtId{1, n} }
+
+	items := []item{
+		{id(3), id(2), rmEndMarker},
+		{id(1), rmEndMarker, rmEndMarker},
+		{id(2), id(1), id(3)},
+		{id(5), id(1), rmEndMarker}, // inserted after 1 concurrently with 2
+		{id(4), id(9), rmEndMarker}, // after an item that isn't there
+	}
+	got := crdtOrder(items, func(i item) (CrdtId, CrdtId, CrdtId) { return i.id, i.left, i.right })
+
+	/* Items are ordered level by level like the tablet does, ties by ID */
+	want := []CrdtId{id(1), id(4), id(2), id(5), id(3)}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("order mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCrdtOrderLongChain(t *testing.T) {
+	type item struct{ id, left, right CrdtId }
+
+	/* Typed text is a chain of single characters, listed here from the end */
+	const n = 100000
+	items := []item{}
+	want := []CrdtId{}
+	for i := uint64(1); i <= n; i++ {
+		left, right := CrdtId{1, i - 1}, CrdtId{1, i + 1}
+		if i == 1 {
+			left = rmEndMarker
+		}
+		if i == n {
+			right = rmEndMarker
+		}
+		items = append(items, item{CrdtId{1, i}, left, right})
+		want = append(want, CrdtId{1, i})
+	}
+	slices.Reverse(items)
+
+	start := time.Now()
+	got := crdtOrder(items, func(i item) (CrdtId, CrdtId, CrdtId) { return i.id, i.left, i.right })
+	if !slices.Equal(want, got) {
+		t.Errorf("wrong order of the chain")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ordering %d items took %v", n, elapsed)
+	}
+}
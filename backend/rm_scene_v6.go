@@ -0,0 +1,807 @@
+package backend
+
+import (
+	"fmt"
+	"math"
+)
+
+/* Types of the blocks of a v6 page */
+const (
+	rmBlockMigrationInfo = 0x00
+	rmBlockSceneTree     = 0x01
+	rmBlockTreeNode      = 0x02
+	rmBlockGlyphItem     = 0x03
+	rmBlockGroupItem     = 0x04
+	rmBlockLineItem      = 0x05
+	rmBlockTextItem      = 0x06
+	rmBlockRootText      = 0x07
+	rmBlockTombstoneItem = 0x08
+	rmBlockAuthorIds     = 0x09
+	rmBlockPageInfo      = 0x0A
+	rmBlockSceneInfo     = 0x0D
+)
+
+/* Types of the values of scene items */
+const (
+	rmItemGlyph = 0x01
+	rmItemGroup = 0x02
+	rmItemLine  = 0x03
+	rmItemText  = 0x05
+)
+
+/* The blocks of a v6 page, in the order of the file. */
+type RmScene struct {
+	Blocks []RmBlock
+}
+
+/*
+A block of a v6 page. The field for the block's type is set; the blocks the app doesn't need
+(author IDs, page info...) and the ones it can't read are kept as raw data.
+*/
+type RmBlock struct {
+	Type       uint8
+	MinVersion uint8
+	Version    uint8
+
+	TreeMove *RmTreeMove  // rmBlockSceneTree
+	Node     *RmTreeNode  // rmBlockTreeNode
+	Item     *RmSceneItem // the item blocks
+	Text     *RmText      // rmBlockRootText
+
+	reserved uint8
+	data     []byte // content of the blocks that aren't parsed
+	extra    []byte // content after the parsed fields
+}
+
+/* Places a node of the scene tree (a layer or a group) under a parent. */
+type RmTreeMove struct {
+	TreeId   CrdtId
+	NodeId   CrdtId
+	IsUpdate bool
+	ParentId CrdtId
+}
+
+/* A layer or a group of the scene tree. Values are "last writer wins", with the timestamp of the change. */
+type RmTreeNode struct {
+	NodeId      CrdtId
+	Label       string
+	LabelTime   CrdtId
+	Visible     bool
+	VisibleTime CrdtId
+}
+
+/*
+An item of a CRDT sequence under a parent node: a line, a highlight, or a group that puts a node
+(e.g. a layer) in the parent's sequence. Deleted items have no value.
+*/
+type RmSceneItem struct {
+	ParentId      CrdtId
+	ItemId        CrdtId
+	LeftId        CrdtId
+	RightId       CrdtId
+	DeletedLength uint32
+
+	Line      *RmLine
+	Highlight *RmHighlight
+	Group     *CrdtId
+
+	hasValue bool
+	itemType uint8
+	value    []byte // the value of other item types
+}
+
+func parseRmScene(data []byte) (*RmScene, error) {
+	s := &rmStream{data: data}
+	scene := &RmScene{}
+
+	for s.remaining() > 0 {
+		length, err := s.uint32()
+		if err != nil {
+			return nil, err
+		}
+		header, err := s.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		content, err := s.bytes(int(length))
+		if err != nil {
+			return nil, fmt.Errorf("block at %d is truncated", s.pos)
+		}
+
+		block := RmBlock{reserved: header[0], MinVersion: header[1], Version: header[2], Type: header[3]}
+		if err := block.parse(content); err != nil {
+			/* Kept as it is, the page may still be usable */
+			block = RmBlock{reserved: header[0], MinVersion: header[1], Version: header[2], Type: header[3], data: content}
+		}
+		scene.Blocks = append(scene.Blocks, block)
+	}
+	return scene, nil
+}
+
+func (b *RmBlock) parse(content []byte) error {
+	s := &rmStream{data: content}
+	var err error
+
+	switch b.Type {
+	case rmBlockSceneTree:
+		b.TreeMove, err = readTreeMove(s)
+	case rmBlockTreeNode:
+		b.Node, err = readTreeNode(s)
+	case rmBlockGlyphItem, rmBlockGroupItem, rmBlockLineItem, rmBlockTextItem, rmBlockTombstoneItem:
+		b.Item, err = readSceneItem(s, b.Type, b.Version)
+	case rmBlockRootText:
+		b.Text, err = readRootText(s)
+	default:
+		b.data = content
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	b.extra = s.rest()
+	return nil
+}
+
+func (s *RmScene) encode() []byte {
+	w := &rmWriter{}
+	for _, block := range s.Blocks {
+		content := block.encode()
+		w.uint32(uint32(len(content)))
+		w.uint8(block.reserved)
+		w.uint8(block.MinVersion)
+		w.uint8(block.Version)
+		w.uint8(block.Type)
+		w.buf.Write(content)
+	}
+	return w.buf.Bytes()
+}
+
+func (b *RmBlock) encode() []byte {
+	w := &rmWriter{}
+	switch {
+	case b.TreeMove != nil:
+		writeTreeMove(w, b.TreeMove)
+	case b.Node != nil:
+		writeTreeNode(w, b.Node)
+	case b.Item != nil:
+		writeSceneItem(w, b.Item, b.Version)
+	case b.Text != nil:
+		writeRootText(w, b.Text)
+	default:
+		return b.data
+	}
+	w.buf.Write(b.extra)
+	return w.buf.Bytes()
+}
+
+func readTreeMove(s *rmStream) (*RmTreeMove, error) {
+	move := &RmTreeMove{}
+	var err error
+	if move.TreeId, err = s.id(1); err != nil {
+		return nil, err
+	}
+	if move.NodeId, err = s.id(2); err != nil {
+		return nil, err
+	}
+	if move.IsUpdate, err = s.bool(3); err != nil {
+		return nil, err
+	}
+
+	sub, err := s.subblock(4)
+	if err != nil {
+		return nil, err
+	}
+	if move.ParentId, err = sub.id(1); err != nil {
+		return nil, err
+	}
+	return move, nil
+}
+
+func writeTreeMove(w *rmWriter, move *RmTreeMove) {
+	w.id(1, move.TreeId)
+	w.id(2, move.NodeId)
+	w.bool(3, move.IsUpdate)
+	w.subblock(4, func(w *rmWriter) {
+		w.id(1, move.ParentId)
+	})
+}
+
+/* A "last writer wins" value: a subblock with the timestamp and the value. */
+func readLww(s *rmStream, index uint64, value func(s *rmStream) error) (CrdtId, error) {
+	sub, err := s.subblock(index)
+	if err != nil {
+		return CrdtId{}, err
+	}
+	timestamp, err := sub.id(1)
+	if err != nil {
+		return CrdtId{}, err
+	}
+	return timestamp, value(sub)
+}
+
+func readTreeNode(s *rmStream) (*RmTreeNode, error) {
+	node := &RmTreeNode{}
+	var err error
+	if node.NodeId, err = s.id(1); err != nil {
+		return nil, err
+	}
+
+	node.LabelTime, err = readLww(s, 2, func(s *rmStream) (err error) {
+		node.Label, err = s.string(2)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	node.VisibleTime, err = readLww(s, 3, func(s *rmStream) (err error) {
+		node.Visible, err = s.bool(2)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func writeTreeNode(w *rmWriter, node *RmTreeNode) {
+	w.id(1, node.NodeId)
+	w.subblock(2, func(w *rmWriter) {
+		w.id(1, node.LabelTime)
+		w.string(2, node.Label)
+	})
+	w.subblock(3, func(w *rmWriter) {
+		w.id(1, node.VisibleTime)
+		w.bool(2, node.Visible)
+	})
+}
+
+func readSceneItem(s *rmStream, blockType, version uint8) (*RmSceneItem, error) {
+	item := &RmSceneItem{}
+	var err error
+	if item.ParentId, err = s.id(1); err != nil {
+		return nil, err
+	}
+	if item.ItemId, err = s.id(2); err != nil {
+		return nil, err
+	}
+	if item.LeftId, err = s.id(3); err != nil {
+		return nil, err
+	}
+	if item.RightId, err = s.id(4); err != nil {
+		return nil, err
+	}
+	if item.DeletedLength, err = s.int(5); err != nil {
+		return nil, err
+	}
+
+	if !s.hasTag(6, rmTagSubblock) {
+		return item, nil
+	}
+	value, err := s.subblock(6)
+	if err != nil {
+		return nil, err
+	}
+	item.hasValue = true
+	if item.itemType, err = value.uint8(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case blockType == rmBlockLineItem && item.itemType == rmItemLine:
+		item.Line, err = readLine(value, version)
+	case blockType == rmBlockGlyphItem && item.itemType == rmItemGlyph:
+		item.Highlight, err = readHighlight(value)
+	case blockType == rmBlockGroupItem && item.itemType == rmItemGroup:
+		var node CrdtId
+		node, err = value.id(2)
+		item.Group = &node
+		item.value = value.rest()
+	default:
+		item.value = value.rest()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func writeSceneItem(w *rmWriter, item *RmSceneItem, version uint8) {
+	w.id(1, item.ParentId)
+	w.id(2, item.ItemId)
+	w.id(3, item.LeftId)
+	w.id(4, item.RightId)
+	w.int(5, item.DeletedLength)
+	if !item.hasValue {
+		return
+	}
+
+	w.subblock(6, func(w *rmWriter) {
+		w.uint8(item.itemType)
+		switch {
+		case item.Line != nil:
+			writeLine(w, item.Line, version)
+		case item.Highlight != nil:
+			writeHighlight(w, item.Highlight)
+		case item.Group != nil:
+			w.id(2, *item.Group)
+			w.buf.Write(item.value)
+		default:
+			w.buf.Write(item.value)
+		}
+	})
+}
+
+/* Points of the version 1 line blocks are 6 floats, later versions pack them in 14 bytes. */
+const (
+	rmPointSizeV1 = 24
+	rmPointSizeV2 = 14
+)
+
+func readLine(s *rmStream, version uint8) (*RmLine, error) {
+	line := &RmLine{version: version}
+
+	pen, err := s.int(1)
+	if err != nil {
+		return nil, err
+	}
+	color, err := s.int(2)
+	if err != nil {
+		return nil, err
+	}
+	line.Pen, line.Color = RmPen(pen), RmColor(color)
+
+	if line.ThicknessScale, err = s.double(3); err != nil {
+		return nil, err
+	}
+	if line.StartingLength, err = s.float(4); err != nil {
+		return nil, err
+	}
+
+	points, err := s.subblock(5)
+	if err != nil {
+		return nil, err
+	}
+	if line.Points, err = readPoints(points, version); err != nil {
+		return nil, err
+	}
+
+	if line.Timestamp, err = s.id(6); err != nil {
+		return nil, err
+	}
+	if s.hasTag(7, rmTagId) {
+		id, err := s.id(7)
+		if err != nil {
+			return nil, err
+		}
+		line.MoveId = &id
+	}
+	if s.hasTag(8, rmTagByte4) {
+		argb, err := s.int(8)
+		if err != nil {
+			return nil, err
+		}
+		line.ARGB = &argb
+	}
+
+	line.extra = s.rest()
+	return line, nil
+}
+
+func readPoints(s *rmStream, version uint8) ([]RmPoint, error) {
+	size := rmPointSizeV2
+	if version == 1 {
+		size = rmPointSizeV1
+	}
+	if s.remaining()%size != 0 {
+		return nil, fmt.Errorf("%d bytes of points aren't a multiple of %d", s.remaining(), size)
+	}
+
+	points := make([]RmPoint, 0, s.remaining()/size)
+	for s.remaining() > 0 {
+		var p RmPoint
+		p.X, _ = s.float32()
+		p.Y, _ = s.float32()
+
+		if version == 1 {
+			p.Speed, _ = s.float32()
+			p.Direction, _ = s.float32()
+			p.Width, _ = s.float32()
+			p.Pressure, _ = s.float32()
+		} else {
+			speed, _ := s.uint16()
+			width, _ := s.uint16()
+			direction, _ := s.uint8()
+			pressure, _ := s.uint8()
+			p.Speed = float32(speed) / 4
+			p.Width = float32(width) / 4
+			p.Direction = float32(float64(direction) * 2 * math.Pi / 255)
+			p.Pressure = float32(pressure) / 255
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+func writeLine(w *rmWriter, line *RmLine, version uint8) {
+	w.int(1, uint32(line.Pen))
+	w.int(2, uint32(line.Color))
+	w.double(3, line.ThicknessScale)
+	w.float(4, line.StartingLength)
+	w.subblock(5, func(w *rmWriter) {
+		writePoints(w, line.Points, version)
+	})
+	w.id(6, line.Timestamp)
+	if line.MoveId != nil {
+		w.id(7, *line.MoveId)
+	}
+	if line.ARGB != nil {
+		w.int(8, *line.ARGB)
+	}
+	w.buf.Write(line.extra)
+}
+
+func writePoints(w *rmWriter, points []RmPoint, version uint8) {
+	clamp := func(v float64, max float64) float64 {
+		return math.Min(math.Max(math.Round(v), 0), max)
+	}
+
+	for _, p := range points {
+		w.float32(p.X)
+		w.float32(p.Y)
+		if version == 1 {
+			w.float32(p.Speed)
+			w.float32(p.Direction)
+			w.float32(p.Width)
+			w.float32(p.Pressure)
+			continue
+		}
+		w.uint16(uint16(clamp(float64(p.Speed)*4, math.MaxUint16)))
+		w.uint16(uint16(clamp(float64(p.Width)*4, math.MaxUint16)))
+		w.uint8(uint8(clamp(float64(p.Direction)*255/(2*math.Pi), math.MaxUint8)))
+		w.uint8(uint8(clamp(float64(p.Pressure)*255, math.MaxUint8)))
+	}
+}
+
+func readHighlight(s *rmStream) (*RmHighlight, error) {
+	h := &RmHighlight{}
+	var err error
+
+	if s.hasTag(2, rmTagByte4) {
+		start, err := s.int(2)
+		if err != nil {
+			return nil, err
+		}
+		h.Start = &start
+	}
+	if h.Length, err = s.int(3); err != nil {
+		return nil, err
+	}
+	color, err := s.int(4)
+	if err != nil {
+		return nil, err
+	}
+	h.Color = RmColor(color)
+	if h.Text, err = s.string(5); err != nil {
+		return nil, err
+	}
+
+	rects, err := s.subblock(6)
+	if err != nil {
+		return nil, err
+	}
+	count, err := rects.varuint()
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(rects.remaining()/32) {
+		return nil, fmt.Errorf("%d highlight rectangles don't fit in the block", count)
+	}
+	for range count {
+		var r RmRect
+		r.X, _ = rects.float64()
+		r.Y, _ = rects.float64()
+		r.Width, _ = rects.float64()
+		r.Height, _ = rects.float64()
+		h.Rects = append(h.Rects, r)
+	}
+
+	if s.hasTag(7, rmTagByte4) {
+		argb, err := s.int(7)
+		if err != nil {
+			return nil, err
+		}
+		h.ARGB = &argb
+	}
+
+	h.extra = s.rest()
+	return h, nil
+}
+
+func writeHighlight(w *rmWriter, h *RmHighlight) {
+	if h.Start != nil {
+		w.int(2, *h.Start)
+	}
+	w.int(3, h.Length)
+	w.int(4, uint32(h.Color))
+	w.string(5, h.Text)
+	w.subblock(6, func(w *rmWriter) {
+		w.varuint(uint64(len(h.Rects)))
+		for _, r := range h.Rects {
+			w.float64(r.X)
+			w.float64(r.Y)
+			w.float64(r.Width)
+			w.float64(r.Height)
+		}
+	})
+	if h.ARGB != nil {
+		w.int(7, *h.ARGB)
+	}
+	w.buf.Write(h.extra)
+}
+
+/* The paragraph style of a format is preceded by this constant, its meaning is unknown */
+const rmTextFormatMarker = 17
+
+func readRootText(s *rmStream) (*RmText, error) {
+	text := &RmText{}
+	var err error
+	if text.BlockId, err = s.id(1); err != nil {
+		return nil, err
+	}
+
+	sequences, err := s.subblock(2)
+	if err != nil {
+		return nil, err
+	}
+
+	/* The text items are nested in two subblocks, like the formats */
+	items, err := nestedSubblocks(sequences, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	count, err := items.varuint()
+	if err != nil {
+		return nil, err
+	}
+	for range count {
+		item, err := readTextItem(items)
+		if err != nil {
+			return nil, err
+		}
+		text.Items = append(text.Items, item)
+	}
+
+	formats, err := nestedSubblocks(sequences, 2, 1)
+	if err != nil {
+		return nil, err
+	}
+	if count, err = formats.varuint(); err != nil {
+		return nil, err
+	}
+	for range count {
+		format, err := readTextFormat(formats)
+		if err != nil {
+			return nil, err
+		}
+		text.Formats = append(text.Formats, format)
+	}
+
+	position, err := s.subblock(3)
+	if err != nil {
+		return nil, err
+	}
+	if text.X, err = position.float64(); err != nil {
+		return nil, err
+	}
+	if text.Y, err = position.float64(); err != nil {
+		return nil, err
+	}
+
+	if text.Width, err = s.float(4); err != nil {
+		return nil, err
+	}
+	return text, nil
+}
+
+func nestedSubblocks(s *rmStream, outer, inner uint64) (*rmStream, error) {
+	sub, err := s.subblock(outer)
+	if err != nil {
+		return nil, err
+	}
+	return sub.subblock(inner)
+}
+
+func readTextItem(s *rmStream) (RmTextItem, error) {
+	item := RmTextItem{}
+	sub, err := s.subblock(0)
+	if err != nil {
+		return item, err
+	}
+
+	if item.ItemId, err = sub.id(2); err != nil {
+		return item, err
+	}
+	if item.LeftId, err = sub.id(3); err != nil {
+		return item, err
+	}
+	if item.RightId, err = sub.id(4); err != nil {
+		return item, err
+	}
+	if item.DeletedLength, err = sub.int(5); err != nil {
+		return item, err
+	}
+
+	if sub.hasTag(6, rmTagSubblock) {
+		value, err := sub.subblock(6)
+		if err != nil {
+			return item, err
+		}
+		if item.Text, err = value.stringContent(); err != nil {
+			return item, err
+		}
+		if value.remaining() > 0 {
+			format, err := value.int(2)
+			if err != nil {
+				return item, err
+			}
+			item.Format = &format
+		}
+	}
+	return item, nil
+}
+
+func readTextFormat(s *rmStream) (RmTextFormat, error) {
+	format := RmTextFormat{}
+	var err error
+	if format.CharId, err = s.crdtId(); err != nil {
+		return format, err
+	}
+	if format.Timestamp, err = s.id(1); err != nil {
+		return format, err
+	}
+
+	sub, err := s.subblock(2)
+	if err != nil {
+		return format, err
+	}
+	marker, err := sub.uint8()
+	if err != nil {
+		return format, err
+	}
+	if marker != rmTextFormatMarker {
+		return format, fmt.Errorf("unexpected text format marker %d", marker)
+	}
+	format.Style, err = sub.uint8()
+	return format, err
+}
+
+func writeRootText(w *rmWriter, text *RmText) {
+	w.id(1, text.BlockId)
+	w.subblock(2, func(w *rmWriter) {
+		w.subblock(1, func(w *rmWriter) {
+			w.subblock(1, func(w *rmWriter) {
+				w.varuint(uint64(len(text.Items)))
+				for _, item := range text.Items {
+					writeTextItem(w, item)
+				}
+			})
+		})
+		w.subblock(2, func(w *rmWriter) {
+			w.subblock(1, func(w *rmWriter) {
+				w.varuint(uint64(len(text.Formats)))
+				for _, format := range text.Formats {
+					w.crdtId(format.CharId)
+					w.id(1, format.Timestamp)
+					w.subblock(2, func(w *rmWriter) {
+						w.uint8(rmTextFormatMarker)
+						w.uint8(format.Style)
+					})
+				}
+			})
+		})
+	})
+	w.subblock(3, func(w *rmWriter) {
+		w.float64(text.X)
+		w.float64(text.Y)
+	})
+	w.float(4, text.Width)
+}
+
+func writeTextItem(w *rmWriter, item RmTextItem) {
+	w.subblock(0, func(w *rmWriter) {
+		w.id(2, item.ItemId)
+		w.id(3, item.LeftId)
+		w.id(4, item.RightId)
+		w.int(5, item.DeletedLength)
+		/* Deleted items have no value */
+		if item.Text == "" && item.Format == nil {
+			return
+		}
+		w.subblock(6, func(w *rmWriter) {
+			w.stringContent(item.Text)
+			if item.Format != nil {
+				w.int(2, *item.Format)
+			}
+		})
+	})
+}
+
+/* Builds the layers from the scene tree: the groups under the root are the layers, in the order of their sequence. */
+func (s *RmScene) page() RmPage {
+	page := RmPage{Version: 6, Scene: s}
+
+	nodes := map[CrdtId]*RmTreeNode{}
+	children := map[CrdtId][]*RmSceneItem{}
+	for _, block := range s.Blocks {
+		switch {
+		case block.Node != nil:
+			nodes[block.Node.NodeId] = block.Node
+		case block.Item != nil:
+			children[block.Item.ParentId] = append(children[block.Item.ParentId], block.Item)
+		case block.Text != nil && page.Text == nil:
+			page.Text = block.Text
+		}
+	}
+
+	ordered := func(parent CrdtId) []*RmSceneItem {
+		byId := map[CrdtId]*RmSceneItem{}
+		for _, item := range children[parent] {
+			byId[item.ItemId] = item
+		}
+
+		result := []*RmSceneItem{}
+		for _, id := range crdtOrder(children[parent], func(item *RmSceneItem) (CrdtId, CrdtId, CrdtId) {
+			return item.ItemId, item.LeftId, item.RightId
+		}) {
+			if item := byId[id]; item.hasValue {
+				result = append(result, item)
+			}
+		}
+		return result
+	}
+
+	/* Groups inside a layer are flattened into it */
+	visited := map[CrdtId]bool{}
+	var collect func(layer *RmLayer, node CrdtId)
+	collect = func(layer *RmLayer, node CrdtId) {
+		if visited[node] {
+			return
+		}
+		visited[node] = true
+
+		for _, item := range ordered(node) {
+			switch {
+			case item.Line != nil:
+				layer.Lines = append(layer.Lines, *item.Line)
+			case item.Highlight != nil:
+				layer.Highlights = append(layer.Highlights, *item.Highlight)
+			case item.Group != nil:
+				collect(layer, *item.Group)
+			}
+		}
+	}
+
+	/* Lines directly under the root come before the layers */
+	root := RmLayer{Visible: true}
+	visited[rmRootId] = true
+	for _, item := range ordered(rmRootId) {
+		switch {
+		case item.Line != nil:
+			root.Lines = append(root.Lines, *item.Line)
+		case item.Highlight != nil:
+			root.Highlights = append(root.Highlights, *item.Highlight)
+		case item.Group != nil:
+			layer := RmLayer{Visible: true}
+			if node, ok := nodes[*item.Group]; ok {
+				layer.Name, layer.Visible = node.Label, node.Visible
+			}
+			collect(&layer, *item.Group)
+			page.Layers = append(page.Layers, layer)
+		}
+	}
+	if len(root.Lines) > 0 || len(root.Highlights) > 0 {
+		page.Layers = append([]RmLayer{root}, page.Layers...)
+	}
+	return page
+}
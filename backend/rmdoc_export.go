@@ -0,0 +1,88 @@
+package backend
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+)
+
+/* Reads the files of a document from xochitl's directory; SSHConnection, tests use a fake. */
+type remoteDocumentReader interface {
+	remoteFileReader
+	ListDirectory(remotePath string) ([]RemoteFileInfo, error)
+}
+
+/*
+Returns the names of the document's files that go into its .rmdoc archive, in the order the USB
+web interface writes them: <id>.content, <id>.metadata, <id>.pagedata, the PDF or EPUB and the
+files of the pages. Thumbnails and other caches of the tablet are left out.
+*/
+func rmdocFileNames(files remoteDocumentReader, id DocId, content *SSHContent) ([]string, error) {
+	names := []string{id + ".content", id + ".metadata", id + ".pagedata"}
+	if content.FileType == "pdf" || content.FileType == "epub" {
+		names = append(names, id+"."+content.FileType)
+	}
+
+	entries, err := files.ListDirectory(xochitlPath(id))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list the pages of %s: %w", id, err)
+	}
+	pages := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir {
+			pages = append(pages, id+"/"+entry.Name)
+		}
+	}
+	slices.Sort(pages)
+	return append(names, pages...), nil
+}
+
+/*
+Packs a document from xochitl's directory as an .rmdoc archive, like the USB web interface exports
+it. The .pagedata file is optional: documents created on newer software versions have none.
+*/
+func buildRmdoc(files remoteDocumentReader, id DocId) ([]byte, error) {
+	data, err := files.ReadRemoteFile(xochitlPath(id + ".content"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the content of %s: %w", id, err)
+	}
+	var content SSHContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("failed to parse the content of %s: %v", id, err)
+	}
+	names, err := rmdocFileNames(files, id, &content)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range names {
+		data, err := readOptional(files, xochitlPath(name))
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			if name == id+".pagedata" {
+				continue
+			}
+			return nil, fmt.Errorf("%w: document %s has no file %s", ErrFormatUnavailable, id, name)
+		}
+
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
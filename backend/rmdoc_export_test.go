@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildRmdoc(t *testing.T) {
+	page := testNotebookId + "/" + testPage1
+	files := fakeRemoteFiles{
+		xochitlPath(testNotebookId + ".metadata"):                         []byte(`{"type": "DocumentType", "visibleName": "Paper"}`),
+		xochitlPath(testNotebookId + ".content"):                          []byte(`{"fileType": "pdf"}`),
+		xochitlPath(testNotebookId + ".pdf"):                              []byte("%PDF-1.4"),
+		xochitlPath(page + ".rm"):                                         []byte("reMarkable .lines file, version=6"),
+		xochitlPath(page + "-metadata.json"):                              []byte(`{"layers": [{"name": "Layer 1"}]}`),
+		xochitlPath(testNotebookId + ".thumbnails/" + testPage1 + ".png"): []byte("thumbnail"),
+		xochitlPath(testNotebookId + "/cache/" + testPage1 + ".png"):      []byte("cache"),
+	}
+
+	data, err := buildRmdoc(files, testNotebookId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* The files are in the order of the web interface's archives */
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	want := []string{
+		testNotebookId + ".content",
+		testNotebookId + ".metadata",
+		testNotebookId + ".pdf",
+		page + "-metadata.json",
+		page + ".rm",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("unexpected files: %v", names)
+	}
+
+	/* The archive imports back as the same document */
+	path := filepath.Join(t.TempDir(), "Paper.rmdoc")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := readRmdoc(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.id != testNotebookId {
+		t.Errorf("unexpected ID: %s", archive.id)
+	}
+	for _, name := range want {
+		if diff := cmp.Diff(files[xochitlPath(name)], archive.files[name]); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+
+	/* A document without its source file can't be packed */
+	delete(files, xochitlPath(testNotebookId+".pdf"))
+	if _, err := buildRmdoc(files, testNotebookId); !errors.Is(err, ErrFormatUnavailable) {
+		t.Errorf("unexpected error without the PDF: %v", err)
+	}
+
+	/* A notebook that was never drawn on has no pages directory and no .pagedata */
+	notebook := fakeRemoteFiles{
+		xochitlPath(testNotebookId + ".metadata"): []byte(`{"type": "DocumentType", "visibleName": "Notes"}`),
+		xochitlPath(testNotebookId + ".content"):  []byte(`{"fileType": "notebook"}`),
+	}
+	if data, err = buildRmdoc(notebook, testNotebookId); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = zip.NewReader(bytes.NewReader(data), int64(len(data))); err != nil || len(r.File) != 2 {
+		t.Errorf("unexpected notebook archive: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package backend
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/* Limit for the unpacked size of an .rmdoc archive, so that a broken archive can't fill the memory. */
+const maxRmdocSize = 2 << 30
+
+type RmdocImportOptions struct {
+	ParentId DocId // folder to put the document in, "" for the root
+	KeepId   bool  // keep the UUID from the archive instead of generating a new one
+}
+
+/*
+Contents of an .rmdoc archive, the format the USB web interface exports.
+It has the same files as xochitl's directory for a single document: <id>.metadata, <id>.content,
+<id>.pagedata, <id>.pdf or <id>.epub, the pages in <id>/<page id>.rm and so on.
+*/
+type rmdocArchive struct {
+	id    DocId
+	files map[string][]byte // by the path inside the archive
+}
+
+/* Reads an .rmdoc archive and checks that every file belongs to a single document. */
+func readRmdoc(localPath string) (*rmdocArchive, error) {
+	archive, err := zip.OpenReader(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("not an .rmdoc file: %v", err)
+	}
+	defer archive.Close()
+
+	var total uint64
+	for _, f := range archive.File {
+		total += f.UncompressedSize64
+	}
+	if total > maxRmdocSize {
+		return nil, fmt.Errorf(".rmdoc archive is too large: %d bytes", total)
+	}
+
+	result := &rmdocArchive{files: map[string][]byte{}}
+	for _, f := range archive.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+
+		name := path.Clean(f.Name)
+		if !fs.ValidPath(name) || strings.Contains(name, `\`) {
+			return nil, fmt.Errorf("invalid file name in the .rmdoc archive: %q", f.Name)
+		}
+
+		if id, ok := strings.CutSuffix(name, ".metadata"); ok && !strings.Contains(id, "/") {
+			if result.id != "" {
+				return nil, fmt.Errorf(".rmdoc archive has more than one document")
+			}
+			result.id = id
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from the .rmdoc archive: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(r, maxRmdocSize))
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from the .rmdoc archive: %v", f.Name, err)
+		}
+		result.files[name] = data
+	}
+
+	if result.id == "" {
+		return nil, fmt.Errorf(".rmdoc archive has no .metadata file")
+	}
+	if err := validateDocId(result.id); err != nil {
+		return nil, fmt.Errorf(".rmdoc archive has an invalid document ID: %v", err)
+	}
+
+	for name := range result.files {
+		if !strings.HasPrefix(name, result.id+".") && !strings.HasPrefix(name, result.id+"/") {
+			return nil, fmt.Errorf("file %s in the .rmdoc archive doesn't belong to document %s", name, result.id)
+		}
+	}
+
+	return result, nil
+}
+
+/*
+Moves the document to another ID and parent. The ID is the prefix of every file name,
+the parent is stored in the .metadata file, the other fields there are kept.
+*/
+func (a *rmdocArchive) rewrite(id DocId, parentId DocId, now time.Time) (*rmdocArchive, error) {
+	result := &rmdocArchive{id: id, files: map[string][]byte{}}
+	for name, data := range a.files {
+		result.files[id+strings.TrimPrefix(name, a.id)] = data
+	}
+
+	metadataName := id + ".metadata"
+	metadata, err := updateMetadataJSON(result.files[metadataName], map[string]interface{}{
+		"parent":  parentId,
+		"deleted": false,
+	}, now)
+	if err != nil {
+		return nil, fmt.Errorf("invalid .metadata in the .rmdoc archive: %v", err)
+	}
+	result.files[metadataName] = metadata
+
+	return result, nil
+}
+
+/*
+Returns the file names in the upload order: the .metadata file comes last,
+so that xochitl doesn't see the document before all of its files are there.
+*/
+func (a *rmdocArchive) uploadOrder() []string {
+	names := []string{}
+	for name := range a.files {
+		if name != a.id+".metadata" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return append(names, a.id+".metadata")
+}
+
+func (s *SSHImporter) importRmdoc(ctx context.Context, localPath string, options RmdocImportOptions) (string, error) {
+	s.logInfof("[SSH_IMPORT] Importing .rmdoc: %s (parent: %s)", localPath, options.ParentId)
+
+	if err := validateParentId(options.ParentId); err != nil {
+		return "", err
+	}
+
+	archive, err := readRmdoc(localPath)
+	if err != nil {
+		return "", err
+	}
+
+	id := archive.id
+	if !options.KeepId {
+		id = uuid.New().String()
+	} else if _, err := s.connection.Stat(xochitlPath(id + ".metadata")); err == nil {
+		return "", fmt.Errorf("document %s already exists on the tablet", id)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to check for document %s on the tablet: %w", id, err)
+	}
+
+	archive, err = archive.rewrite(id, options.ParentId, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	/* The document appears on the tablet complete or not at all */
+	stage, err := newRemoteStage(s.connection)
+	if err != nil {
+		return "", err
+	}
+	defer stage.abort()
+
+	for _, name := range archive.uploadOrder() {
+		err := stage.write(name, archive.files[name])
+		if err != nil {
+			return "", err
+		}
+	}
+	s.logInfof("[SSH_IMPORT] Uploaded %d files of document %s", len(archive.files), id)
+
+	for _, dir := range documentDirectories(id) {
+		err = stage.mkdir(dir)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = stage.commit(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
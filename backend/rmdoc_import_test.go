@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+const rmdocId = "44444444-4444-4444-8444-444444444444"
+
+func rmdocEntries(id string) []zipEntry {
+	return []zipEntry{
+		{id + ".metadata", `{"parent": "55555555-5555-4555-8555-555555555555", "type": "DocumentType", "visibleName": "Notes", "pinned": true}`, zip.Deflate},
+		{id + ".content", `{"fileType": "notebook", "pageCount": 1}`, zip.Deflate},
+		{id + "/66666666-6666-4666-8666-666666666666.rm", "reMarkable .lines file, version=6", zip.Deflate},
+		{id + ".pagedata", "Blank\n", zip.Deflate},
+	}
+}
+
+func TestReadRmdoc(t *testing.T) {
+	archive, err := readRmdoc(writeZip(t, rmdocEntries(rmdocId)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.id != rmdocId || len(archive.files) != 4 {
+		t.Fatalf("unexpected archive: %v %v", archive.id, archive.files)
+	}
+
+	newId := "77777777-7777-4777-8777-777777777777"
+	rewritten, err := archive.rewrite(newId, "", time.UnixMilli(1700000000000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := rewritten.uploadOrder()
+	want := []string{
+		newId + ".content",
+		newId + ".pagedata",
+		newId + "/66666666-6666-4666-8666-666666666666.rm",
+		newId + ".metadata",
+	}
+	if !slices.Equal(order, want) {
+		t.Errorf("unexpected files: %v", order)
+	}
+
+	var metadata SSHMetadata
+	if err := json.Unmarshal(rewritten.files[newId+".metadata"], &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Parent != "" || metadata.VisibleName != "Notes" || !metadata.Pinned || metadata.Deleted {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+}
+
+func TestReadRmdocRejectsInvalidArchives(t *testing.T) {
+	otherId := "88888888-8888-4888-8888-888888888888"
+
+	invalid := map[string][]zipEntry{
+		"no metadata":    rmdocEntries(rmdocId)[1:],
+		"two documents":  append(rmdocEntries(rmdocId), rmdocEntries(otherId)[0]),
+		"foreign file":   append(rmdocEntries(rmdocId), zipEntry{"evil.sh", "rm -rf /", zip.Deflate}),
+		"path traversal": append(rmdocEntries(rmdocId), zipEntry{rmdocId + "/../../.bashrc", "x", zip.Deflate}),
+		"invalid id":     rmdocEntries("not-a-uuid"),
+	}
+
+	for name, entries := range invalid {
+		if _, err := readRmdoc(writeZip(t, entries)); err == nil {
+			t.Errorf("%s: invalid archive is accepted", name)
+		}
+	}
+}
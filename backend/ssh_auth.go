@@ -0,0 +1,161 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type SSHAuthMethod = string
+
+const (
+	AuthPassword            SSHAuthMethod = "password"
+	AuthKeyFile             SSHAuthMethod = "key"
+	AuthAgent               SSHAuthMethod = "agent"
+	AuthKeyboardInteractive SSHAuthMethod = "keyboard-interactive"
+)
+
+/* How to authenticate on the tablet. Only the fields used by Method need to be set. */
+type SSHAuthOptions struct {
+	Method SSHAuthMethod
+
+	// AuthPassword and AuthKeyboardInteractive
+	Password string
+
+	// AuthKeyFile. If KeyPath is empty, the default keys in ~/.ssh are tried.
+	KeyPath    string
+	Passphrase string
+
+	// AuthAgent. If empty, $SSH_AUTH_SOCK is used.
+	AgentSocket string
+}
+
+/* Private keys tried when AuthKeyFile is used without a KeyPath, in the order of preference. */
+var defaultKeyFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+/*
+Returns the ssh auth methods for the options.
+The returned function releases the resources (e.g. agent connection) and
+must be called once the handshake is complete.
+*/
+func (o SSHAuthOptions) authMethods() ([]ssh.AuthMethod, func(), error) {
+	noop := func() {}
+
+	switch o.Method {
+	case AuthPassword, "":
+		return []ssh.AuthMethod{ssh.Password(o.Password)}, noop, nil
+
+	case AuthKeyboardInteractive:
+		return []ssh.AuthMethod{ssh.KeyboardInteractive(o.answerPrompts)}, noop, nil
+
+	case AuthKeyFile:
+		signers, err := o.keyFileSigners()
+		if err != nil {
+			return nil, noop, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, noop, nil
+
+	case AuthAgent:
+		socket := o.AgentSocket
+		if socket == "" {
+			socket = os.Getenv("SSH_AUTH_SOCK")
+		}
+		if socket == "" {
+			return nil, noop, fmt.Errorf("ssh-agent socket is not set and SSH_AUTH_SOCK is empty")
+		}
+
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, noop, fmt.Errorf("failed to connect to ssh-agent at %s: %v", socket, err)
+		}
+
+		client := agent.NewClient(conn)
+		return []ssh.AuthMethod{ssh.PublicKeysCallback(client.Signers)}, func() { conn.Close() }, nil
+	}
+
+	return nil, noop, fmt.Errorf("unknown SSH auth method: %q", o.Method)
+}
+
+/*
+Answers every keyboard-interactive question with the password.
+The tablet asks a single "Password:" question.
+*/
+func (o SSHAuthOptions) answerPrompts(user, instruction string, questions []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(questions))
+	for i := range questions {
+		answers[i] = o.Password
+	}
+	return answers, nil
+}
+
+func (o SSHAuthOptions) keyFileSigners() ([]ssh.Signer, error) {
+	if o.KeyPath != "" {
+		signer, err := o.loadKey(expandHome(o.KeyPath))
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.Signer{signer}, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("no key file given and home directory is unknown: %v", err)
+	}
+
+	signers := []ssh.Signer{}
+	for _, name := range defaultKeyFiles {
+		signer, err := o.loadKey(filepath.Join(home, ".ssh", name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no key file given and none of %v found in %s", defaultKeyFiles, filepath.Join(home, ".ssh"))
+	}
+	return signers, nil
+}
+
+func (o SSHAuthOptions) loadKey(path string) (ssh.Signer, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if o.Passphrase == "" {
+			return nil, fmt.Errorf("key file %s is encrypted, a passphrase is required", path)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(o.Passphrase))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s: %v", path, err)
+	}
+	return signer, nil
+}
+
+/* Expands the leading "~" of a local path. */
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,217 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+/* Writes a new private key in the OpenSSH format, encrypted if the passphrase isn't empty, and returns its public key. */
+func writeTestKey(t *testing.T, path string, ecdsaKey bool, passphrase string) ssh.PublicKey {
+	t.Helper()
+
+	var key any
+	var err error
+	if ecdsaKey {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer.PublicKey()
+}
+
+func sameKey(a, b ssh.PublicKey) bool {
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	plainPath, encryptedPath := filepath.Join(dir, "plain"), filepath.Join(dir, "encrypted")
+	plain := writeTestKey(t, plainPath, false, "")
+	encrypted := writeTestKey(t, encryptedPath, false, "secret")
+
+	tests := []struct {
+		name       string
+		path       string
+		passphrase string
+		want       ssh.PublicKey
+		err        string
+	}{
+		{"plain key", plainPath, "", plain, ""},
+		{"plain key, passphrase ignored", plainPath, "secret", plain, ""},
+		{"encrypted key", encryptedPath, "secret", encrypted, ""},
+		{"encrypted key without passphrase", encryptedPath, "", nil, "a passphrase is required"},
+		{"wrong passphrase", encryptedPath, "wrong", nil, "failed to parse key file"},
+		{"missing key", filepath.Join(dir, "missing"), "", nil, "failed to read key file"},
+	}
+	for _, test := range tests {
+		signer, err := SSHAuthOptions{Method: AuthKeyFile, Passphrase: test.passphrase}.loadKey(test.path)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !sameKey(test.want, signer.PublicKey()) {
+			t.Errorf("%s: wrong key", test.name)
+		}
+	}
+
+	/* The error of the missing key stays recognizable, the default keys that don't exist are skipped */
+	if _, err := (SSHAuthOptions{}).loadKey(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing key isn't reported as such: %v", err)
+	}
+}
+
+func TestKeyFileSigners(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	sshDir := filepath.Join(home, ".ssh")
+	os.MkdirAll(sshDir, 0700)
+
+	/* No key file given and no default keys */
+	if _, err := (SSHAuthOptions{Method: AuthKeyFile}).keyFileSigners(); err == nil || !strings.Contains(err.Error(), "none of") {
+		t.Errorf("expected an error without keys, got %v", err)
+	}
+
+	/* The default keys that exist, in the order of preference */
+	ecdsaKey := writeTestKey(t, filepath.Join(sshDir, "id_ecdsa"), true, "")
+	ed25519Key := writeTestKey(t, filepath.Join(sshDir, "id_ed25519"), false, "")
+	signers, err := SSHAuthOptions{Method: AuthKeyFile}.keyFileSigners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 || !sameKey(ed25519Key, signers[0].PublicKey()) || !sameKey(ecdsaKey, signers[1].PublicKey()) {
+		t.Errorf("unexpected default keys: %d", len(signers))
+	}
+
+	/* An encrypted default key needs the passphrase too */
+	writeTestKey(t, filepath.Join(sshDir, "id_rsa"), false, "secret")
+	if _, err := (SSHAuthOptions{Method: AuthKeyFile}).keyFileSigners(); err == nil || !strings.Contains(err.Error(), "passphrase") {
+		t.Errorf("expected a passphrase error, got %v", err)
+	}
+
+	/* A given key file, with "~" for the home directory */
+	signers, err = SSHAuthOptions{Method: AuthKeyFile, KeyPath: "~/.ssh/id_ecdsa"}.keyFileSigners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 || !sameKey(ecdsaKey, signers[0].PublicKey()) {
+		t.Errorf("unexpected keys for the key file: %d", len(signers))
+	}
+}
+
+func TestAuthMethods(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "key")
+	writeTestKey(t, keyPath, false, "secret")
+
+	for _, options := range []SSHAuthOptions{
+		{Method: "", Password: "pw"},
+		{Method: AuthPassword, Password: "pw"},
+		{Method: AuthKeyboardInteractive, Password: "pw"},
+		{Method: AuthKeyFile, KeyPath: keyPath, Passphrase: "secret"},
+	} {
+		methods, release, err := options.authMethods()
+		if err != nil || len(methods) != 1 {
+			t.Errorf("%q: %d methods, %v", options.Method, len(methods), err)
+		}
+		release()
+	}
+
+	for _, options := range []SSHAuthOptions{
+		{Method: AuthKeyFile, KeyPath: keyPath},
+		{Method: "certificate"},
+	} {
+		if _, release, err := options.authMethods(); err == nil {
+			t.Errorf("%q: expected an error", options.Method)
+		} else {
+			release()
+		}
+	}
+
+	answers, err := SSHAuthOptions{Password: "pw"}.answerPrompts("root", "", []string{"Password:"}, []bool{false})
+	if err != nil || len(answers) != 1 || answers[0] != "pw" {
+		t.Errorf("unexpected answers: %v, %v", answers, err)
+	}
+}
+
+func TestAuthMethodsAgent(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, _, err := (SSHAuthOptions{Method: AuthAgent}).authMethods(); err == nil {
+		t.Errorf("expected an error without an agent socket")
+	}
+
+	/* Unix socket paths are short, the test's temporary directory may be too long */
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "sock")
+
+	if _, _, err := (SSHAuthOptions{Method: AuthAgent, AgentSocket: socket}).authMethods(); err == nil {
+		t.Errorf("expected an error without an agent")
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets are not available: %v", err)
+	}
+	defer listener.Close()
+	keyring := agent.NewKeyring()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	/* $SSH_AUTH_SOCK is used when no socket is given */
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	methods, release, err := SSHAuthOptions{Method: AuthAgent}.authMethods()
+	if err != nil || len(methods) != 1 {
+		t.Fatalf("%d methods, %v", len(methods), err)
+	}
+	release()
+}
@@ -1,27 +1,45 @@
 package backend
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/pkg/sftp"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshDialTimeout       = 10 * time.Second
+	sshKeepAliveInterval = 30 * time.Second
 )
 
 type SSHConnection struct {
 	host     string
 	username string
-	password string
+	auth     SSHAuthOptions
+	hosts    *KnownHosts
 	ctx      context.Context
+
+	mu       sync.Mutex
+	client   *ssh.Client   // nil until Connect() succeeds
+	transfer fileTransfer  // SFTP if the tablet supports it, shell commands otherwise
+	done     chan struct{} // closed to stop the keepalive loop
 }
 
 type SSHMetadata struct {
+	CreatedTime      string `json:"createdTime,omitempty"`
 	Deleted          bool   `json:"deleted"`
 	LastModified     string `json:"lastModified"`
 	MetadataModified bool   `json:"metadatamodified"`
@@ -35,16 +53,36 @@ type SSHMetadata struct {
 	LastOpenedPage   *int   `json:"lastOpenedPage,omitempty"`
 }
 
+/* The numbers written by the tablet aren't always integers, e.g. the transform and the text scale. */
 type SSHContent struct {
-	ExtraMetadata  map[string]interface{} `json:"extraMetadata"`
-	FileType       string                 `json:"fileType"`
-	FontName       string                 `json:"fontName"`
-	LastOpenedPage int                    `json:"lastOpenedPage"`
-	LineHeight     int                    `json:"lineHeight"`
-	Margins        int                    `json:"margins"`
-	PageCount      int                    `json:"pageCount"`
-	TextScale      int                    `json:"textScale"`
-	Transform      map[string]int         `json:"transform"`
+	CoverPageNumber    int                    `json:"coverPageNumber"`
+	CPages             json.RawMessage        `json:"cPages,omitempty"` // pages of format version 2, see pageList
+	DocumentMetadata   *SSHDocumentMetadata   `json:"documentMetadata,omitempty"`
+	ExtraMetadata      map[string]interface{} `json:"extraMetadata"`
+	FileType           string                 `json:"fileType"`
+	FontName           string                 `json:"fontName"`
+	FormatVersion      int                    `json:"formatVersion,omitempty"`
+	LastOpenedPage     int                    `json:"lastOpenedPage"`
+	LineHeight         int                    `json:"lineHeight"`
+	Margins            float64                `json:"margins"`
+	Orientation        string                 `json:"orientation,omitempty"`
+	OriginalPageCount  int                    `json:"originalPageCount,omitempty"`
+	PageCount          int                    `json:"pageCount"`
+	Pages              []string               `json:"pages,omitempty"`
+	PageTags           []SSHPageTag           `json:"pageTags,omitempty"`
+	RedirectionPageMap []int                  `json:"redirectionPageMap,omitempty"`
+	SizeInBytes        string                 `json:"sizeInBytes,omitempty"`
+	Tags               []SSHTag               `json:"tags,omitempty"`
+	TextAlignment      string                 `json:"textAlignment,omitempty"`
+	TextScale          float64                `json:"textScale"`
+	Transform          map[string]float64     `json:"transform"`
+	ZoomMode           string                 `json:"zoomMode,omitempty"`
+}
+
+/* Title and authors the tablet shows in the document info */
+type SSHDocumentMetadata struct {
+	Authors []string `json:"authors,omitempty"`
+	Title   string   `json:"title,omitempty"`
 }
 
 type SSHFileInfo struct {
@@ -55,13 +93,18 @@ type SSHFileInfo struct {
 	Type     string
 	Path     string
 	Size     int64
+	ModTime  time.Time // of the .metadata file
+
+	Metadata SSHMetadata
+	Content  *SSHContent // nil if the .content file is missing or invalid
 }
 
-func NewSSHConnection(host, username, password string, ctx context.Context) *SSHConnection {
+func NewSSHConnection(host, username string, auth SSHAuthOptions, hosts *KnownHosts, ctx context.Context) *SSHConnection {
 	return &SSHConnection{
 		host:     host,
 		username: username,
-		password: password,
+		auth:     auth,
+		hosts:    hosts,
 		ctx:      ctx,
 	}
 }
@@ -71,122 +114,221 @@ func (s *SSHConnection) GetContext() context.Context {
 	return s.ctx
 }
 
+/*
+Opens a single SSH connection to the tablet.
+All the commands and file transfers are multiplexed over this connection as separate sessions,
+so it should be closed with Close() once it's not needed anymore.
+*/
 func (s *SSHConnection) Connect() error {
-	runtime.LogInfof(s.ctx, "[SSH] Testing connection to %s:22 with user '%s'", s.host, s.username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return nil
+	}
+
+	addr := sshAddress(s.host)
+	runtime.LogInfof(s.ctx, "[SSH] Connecting to %s with user '%s' (auth: %s)", addr, s.username, s.auth.Method)
+
+	authMethods, release, err := s.auth.authMethods()
+	if err != nil {
+		runtime.LogErrorf(s.ctx, "[SSH] Authentication setup failed: %v", err)
+		return err
+	}
+	defer release()
+
+	hostKeyCallback, err := s.hosts.hostKeyCallback(func(host, fingerprint string) {
+		runtime.LogWarningf(s.ctx, "[SSH] First connection to %s, trusting host key %s", host, fingerprint)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load known hosts: %v", err)
+	}
+
+	config := &ssh.ClientConfig{
+		User:              s.username,
+		Auth:              authMethods,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: s.hosts.hostKeyAlgorithms(addr),
+		Timeout:           sshDialTimeout,
+	}
+
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		runtime.LogErrorf(s.ctx, "[SSH] Connection failed: %v", err)
+		return fmt.Errorf("SSH connection failed: %w", err)
+	}
+
+	s.client = client
+	s.done = make(chan struct{})
+	go s.keepAlive(client, s.done)
+
+	runtime.LogInfof(s.ctx, "[SSH] Connected to %s (server version: %s)", addr, string(client.ServerVersion()))
 
-	// Test the connection with a simple command
-	output, err := s.executeSSHCommand("echo 'SSH connection test successful'")
+	sftpClient, err := sftp.NewClient(client)
 	if err != nil {
-		runtime.LogErrorf(s.ctx, "[SSH] Connection test failed: %v", err)
-		return fmt.Errorf("SSH connection test failed: %v", err)
+		runtime.LogWarningf(s.ctx, "[SSH] SFTP is not available (%v), falling back to shell commands for file transfers", err)
+		s.transfer = &execTransfer{connection: s}
+	} else {
+		s.transfer = &sftpTransfer{client: sftpClient}
 	}
 
-	runtime.LogInfof(s.ctx, "[SSH] Connection test successful: %s", strings.TrimSpace(output))
 	return nil
 }
 
 func (s *SSHConnection) Close() error {
-	// No persistent connection to close
-	return nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return nil
+	}
+
+	close(s.done)
+	if s.transfer != nil {
+		s.transfer.Close()
+		s.transfer = nil
+	}
+	err := s.client.Close()
+	s.client = nil
+
+	runtime.LogInfo(s.ctx, "[SSH] Connection closed")
+	return err
 }
 
-// executeSSHCommand executes a command on the remote server using plink
-func (s *SSHConnection) executeSSHCommand(command string) (string, error) {
-	runtime.LogInfof(s.ctx, "[SSH] Executing command: %s", command)
+/* Returns "host:22" unless the host already specifies a port. */
+func sshAddress(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, "22")
+}
 
-	// Use plink with -batch to prevent GUI popups
-	cmd := exec.Command("plink",
-		"-ssh",
-		"-batch", // Prevent GUI popups and interactive prompts
-		"-pw", s.password,
-		fmt.Sprintf("%s@%s", s.username, s.host),
-		command)
+/*
+Periodically pings the server so that an idle connection isn't dropped by the tablet
+(e.g. while the user is browsing the file list).
+*/
+func (s *SSHConnection) keepAlive(client *ssh.Client, done chan struct{}) {
+	ticker := time.NewTicker(sshKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			if err != nil {
+				runtime.LogWarningf(s.ctx, "[SSH] Keepalive failed: %v", err)
+				return
+			}
+		}
+	}
+}
 
-	// Capture output
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return string(output), fmt.Errorf("plink command failed: %v", err)
+/* Opens a new session on the persistent connection. */
+func (s *SSHConnection) newSession() (*ssh.Session, error) {
+	s.mu.Lock()
+	client := s.client
+	s.mu.Unlock()
+
+	if client == nil {
+		return nil, fmt.Errorf("SSH connection is not established")
 	}
 
-	return string(output), nil
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SSH session: %v", err)
+	}
+	return session, nil
 }
 
-// ExecuteCommand executes a command on the remote server
-func (s *SSHConnection) ExecuteCommand(command string) (string, error) {
-	return s.executeSSHCommand(command)
+/* Returns the file transfer layer of the established connection. */
+func (s *SSHConnection) getTransfer() (fileTransfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.transfer == nil {
+		return nil, fmt.Errorf("SSH connection is not established")
+	}
+	return s.transfer, nil
 }
 
-// ListXochitlFiles lists all files in the xochitl directory
-func (s *SSHConnection) ListXochitlFiles() ([]SSHFileInfo, error) {
-	runtime.LogInfo(s.ctx, "[SSH] Listing xochitl files...")
+// executeSSHCommand executes a command on the remote server in a new session
+func (s *SSHConnection) executeSSHCommand(command *remoteCommand) (string, error) {
+	runtime.LogInfof(s.ctx, "[SSH] Executing command: %s", command)
 
-	// Use find command to get all metadata files
-	command := "find ~/.local/share/remarkable/xochitl/ -name '*.metadata' -type f"
-	output, err := s.executeSSHCommand(command)
+	session, err := s.newSession()
 	if err != nil {
-		runtime.LogErrorf(s.ctx, "[SSH] Command failed: %v", err)
-		return nil, fmt.Errorf("failed to list metadata files: %v", err)
+		return "", err
 	}
+	defer session.Close()
 
-	runtime.LogInfof(s.ctx, "[SSH] Command output length: %d characters", len(output))
+	output, err := session.CombinedOutput(command.String())
+	if err != nil {
+		return string(output), fmt.Errorf("SSH command failed: %v", err)
+	}
 
-	var files []SSHFileInfo
-	lines := strings.Split(strings.TrimSpace(output), "\n")
-	runtime.LogInfof(s.ctx, "[SSH] Found %d metadata files", len(lines))
+	return string(output), nil
+}
 
-	for i, line := range lines {
-		if line == "" {
-			continue
-		}
+// ExecuteCommand executes a program with the given arguments on the remote server
+func (s *SSHConnection) ExecuteCommand(name string, args ...string) (string, error) {
+	return s.executeSSHCommand(newRemoteCommand(name, args...))
+}
 
-		runtime.LogInfof(s.ctx, "[SSH] Processing file %d: %s", i+1, line)
+/*
+Lists all the documents and folders in the xochitl directory,
+together with their parsed .metadata and .content files.
 
-		// Get the directory and filename
-		dir := filepath.Dir(line)
-		baseName := filepath.Base(line)
-		// Remove .metadata extension to get the ID
-		id := strings.TrimSuffix(baseName, ".metadata")
+Everything is fetched with a single command (see xochitlListingCommand),
+so the time doesn't depend much on the number of documents.
+*/
+func (s *SSHConnection) ListXochitlFiles() ([]SSHFileInfo, error) {
+	runtime.LogInfo(s.ctx, "[SSH] Listing xochitl files...")
+	start := time.Now()
 
-		// Read metadata file
-		metadata, err := s.ReadMetadataFile(line)
-		if err != nil {
-			runtime.LogErrorf(s.ctx, "[SSH] Failed to read metadata for %s: %v", line, err)
-			continue // Skip files with invalid metadata
-		}
+	session, err := s.newSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
 
-		// Check if it's a document or collection
-		isFolder := metadata.Type == "CollectionType"
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
 
-		// Get file size for documents
-		var size int64
-		if !isFolder {
-			// Use stat command to get file size
-			sizeCmd := fmt.Sprintf("stat -c%%s %s 2>/dev/null || echo 0", filepath.Join(dir, id+".pdf"))
-			sizeOutput, _ := s.executeSSHCommand(sizeCmd)
-			fmt.Sscanf(sizeOutput, "%d", &size)
-		}
+	command := xochitlListingCommand()
+	runtime.LogInfof(s.ctx, "[SSH] Executing command: %s", command)
+	err = session.Start(command.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list xochitl files: %v", err)
+	}
 
-		files = append(files, SSHFileInfo{
-			ID:       id,
-			Name:     metadata.VisibleName,
-			IsFolder: isFolder,
-			Parent:   metadata.Parent,
-			Type:     metadata.Type,
-			Path:     line,
-			Size:     size,
-		})
+	files, archived, parseErr := parseXochitlListing(stdout)
+	/* Drain the rest, so that the command can exit */
+	io.Copy(io.Discard, stdout)
+	err = session.Wait()
 
-		runtime.LogInfof(s.ctx, "[SSH] Added %s: %s (%s)", id, metadata.VisibleName, metadata.Type)
+	if parseErr != nil {
+		runtime.LogErrorf(s.ctx, "[SSH] Failed to parse the listing: %v, stderr: %s", parseErr, stderr.String())
+		return nil, fmt.Errorf("failed to list xochitl files: %v", parseErr)
+	}
+	/* tar may complain about an empty archive when there are no documents */
+	if err != nil && archived {
+		runtime.LogErrorf(s.ctx, "[SSH] Listing command failed: %v, stderr: %s", err, stderr.String())
+		return nil, fmt.Errorf("failed to list xochitl files: %v", err)
 	}
 
-	runtime.LogInfof(s.ctx, "[SSH] Successfully processed %d files", len(files))
+	runtime.LogInfof(s.ctx, "[SSH] Listed %d files in %v", len(files), time.Since(start))
 	return files, nil
 }
 
 // ReadMetadataFile reads a metadata file from the remote server
 func (s *SSHConnection) ReadMetadataFile(path string) (*SSHMetadata, error) {
-	command := fmt.Sprintf("cat %s", path)
-	output, err := s.executeSSHCommand(command)
+	output, err := s.executeSSHCommand(newRemoteCommand("cat").path(path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read metadata file %s: %v", path, err)
 	}
@@ -202,8 +344,7 @@ func (s *SSHConnection) ReadMetadataFile(path string) (*SSHMetadata, error) {
 
 // ReadContentFile reads a content file from the remote server
 func (s *SSHConnection) ReadContentFile(path string) (*SSHContent, error) {
-	command := fmt.Sprintf("cat %s", path)
-	output, err := s.executeSSHCommand(command)
+	output, err := s.executeSSHCommand(newRemoteCommand("cat").path(path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read content file %s: %v", path, err)
 	}
@@ -217,131 +358,135 @@ func (s *SSHConnection) ReadContentFile(path string) (*SSHContent, error) {
 	return &content, nil
 }
 
-// DownloadFile downloads a file from the remote server to local path
-func (s *SSHConnection) DownloadFile(remotePath, localPath string) error {
-	runtime.LogInfof(s.ctx, "[SSH] Downloading file: %s -> %s", remotePath, localPath)
-
-	// Use plink for file download
-	cmd := exec.Command("plink",
-		"-ssh",
-		"-batch", // Prevent GUI popups
-		"-pw", s.password,
-		fmt.Sprintf("%s@%s", s.username, s.host),
-		fmt.Sprintf("cat %s", remotePath))
-
-	// Set up stdout to write to local file
-	stdout, err := cmd.StdoutPipe()
+/* Reads a whole remote file, a missing file is an fs.ErrNotExist error. */
+func (s *SSHConnection) ReadRemoteFile(remotePath string) ([]byte, error) {
+	transfer, err := s.getTransfer()
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %v", err)
+		return nil, err
 	}
 
-	// Set up stderr capture
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %v", err)
+	var buf bytes.Buffer
+	if err := transfer.Download(remotePath, 0, &buf); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", remotePath, err)
 	}
+	return buf.Bytes(), nil
+}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start plink command: %v", err)
+// Stat returns information about a remote file
+func (s *SSHConnection) Stat(remotePath string) (RemoteFileInfo, error) {
+	transfer, err := s.getTransfer()
+	if err != nil {
+		return RemoteFileInfo{}, err
 	}
+	return transfer.Stat(remotePath)
+}
 
-	// Create local file
-	localFile, err := os.Create(localPath)
+// ListDirectory lists the entries of a remote directory
+func (s *SSHConnection) ListDirectory(remotePath string) ([]RemoteFileInfo, error) {
+	transfer, err := s.getTransfer()
 	if err != nil {
-		cmd.Process.Kill()
-		return fmt.Errorf("failed to create local file: %v", err)
+		return nil, err
 	}
-	defer localFile.Close()
+	return transfer.ReadDir(remotePath)
+}
 
-	// Copy stdout to local file
-	_, err = io.Copy(localFile, stdout)
+// MkdirAll creates a remote directory with its parents
+func (s *SSHConnection) MkdirAll(remotePath string) error {
+	transfer, err := s.getTransfer()
 	if err != nil {
-		cmd.Process.Kill()
-		return fmt.Errorf("failed to copy data to local file: %v", err)
+		return err
 	}
+	return transfer.MkdirAll(remotePath)
+}
 
-	// Read stderr
-	errorBytes, _ := io.ReadAll(stderr)
-
-	// Wait for the command to complete
-	err = cmd.Wait()
+/* Renames a remote file or directory, a file at newPath is replaced. */
+func (s *SSHConnection) Rename(oldPath, newPath string) error {
+	transfer, err := s.getTransfer()
 	if err != nil {
-		return fmt.Errorf("plink command failed: %v, stderr: %s", err, string(errorBytes))
+		return err
 	}
+	return transfer.Rename(oldPath, newPath)
+}
 
-	runtime.LogInfo(s.ctx, "[SSH] File download successful")
+/* Removes a remote file or directory with everything in it, like "rm -rf". */
+func (s *SSHConnection) RemoveAll(remotePath string) error {
+	_, err := s.executeSSHCommand(newRemoteCommand("rm", "-rf").path(remotePath))
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %v", remotePath, err)
+	}
 	return nil
 }
 
-// UploadFile uploads a local file to the remote server
-func (s *SSHConnection) UploadFile(localPath, remotePath string) error {
-	runtime.LogInfof(s.ctx, "[SSH] Uploading file: %s -> %s", localPath, remotePath)
+/*
+Downloads a file from the remote server to local path.
 
-	// Create remote directory if it doesn't exist
-	remoteDir := filepath.Dir(remotePath)
-	dirCommand := fmt.Sprintf("mkdir -p %s", remoteDir)
-	_, err := s.executeSSHCommand(dirCommand)
+The data is written to "<localPath>.part" first, which is renamed to localPath once
+its size matches the remote file. An interrupted download resumes from the end of the
+.part file when the remote file hasn't changed since, see downloadFile().
+*/
+func (s *SSHConnection) DownloadFile(remotePath, localPath string) error {
+	runtime.LogInfof(s.ctx, "[SSH] Downloading file: %s -> %s", remotePath, localPath)
+
+	transfer, err := s.getTransfer()
 	if err != nil {
-		return fmt.Errorf("failed to create remote directory: %v", err)
+		return err
 	}
 
-	// Read local file
-	localFile, err := os.Open(localPath)
+	offset, err := downloadFile(transfer, remotePath, localPath)
+	if offset > 0 {
+		runtime.LogInfof(s.ctx, "[SSH] Resumed download from byte %d", offset)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to open local file: %v", err)
+		return err
 	}
-	defer localFile.Close()
 
-	// Use plink for file upload
-	cmd := exec.Command("plink",
-		"-ssh",
-		"-batch", // Prevent GUI popups
-		"-pw", s.password,
-		fmt.Sprintf("%s@%s", s.username, s.host),
-		fmt.Sprintf("cat > %s", remotePath))
+	runtime.LogInfo(s.ctx, "[SSH] File download successful")
+	return nil
+}
 
-	// Set up stdin to provide file content
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdin pipe: %v", err)
-	}
+// UploadFile uploads a local file to the remote server, readable by everyone
+func (s *SSHConnection) UploadFile(localPath, remotePath string) error {
+	return s.UploadFileWithMode(localPath, remotePath, 0644)
+}
+
+/*
+Uploads a local file to the remote server and sets its permissions.
 
-	// Set up stderr capture
-	stderr, err := cmd.StderrPipe()
+The data is written to "<remotePath>.part" first, which is renamed into place once
+its size matches the local file, so the tablet never sees a half-written file.
+An interrupted upload resumes from the end of the .part file when the local file
+hasn't changed since, see uploadFile().
+*/
+func (s *SSHConnection) UploadFileWithMode(localPath, remotePath string, perm os.FileMode) error {
+	runtime.LogInfof(s.ctx, "[SSH] Uploading file: %s -> %s", localPath, remotePath)
+
+	transfer, err := s.getTransfer()
 	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %v", err)
+		return err
 	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start plink command: %v", err)
+	offset, err := uploadFile(transfer, localPath, remotePath, perm)
+	if offset > 0 {
+		runtime.LogInfof(s.ctx, "[SSH] Resumed upload from byte %d", offset)
 	}
-
-	// Send file content
-	go func() {
-		defer stdin.Close()
-		io.Copy(stdin, localFile)
-	}()
-
-	// Read stderr
-	errorBytes, _ := io.ReadAll(stderr)
-
-	// Wait for the command to complete
-	err = cmd.Wait()
 	if err != nil {
-		return fmt.Errorf("plink command failed: %v, stderr: %s", err, string(errorBytes))
+		return err
 	}
 
 	runtime.LogInfo(s.ctx, "[SSH] File upload successful")
 	return nil
 }
 
-// CreateMetadataFile creates a metadata file on the remote server
-func (s *SSHConnection) CreateMetadataFile(id, name, parent string, isFolder bool) error {
+/* Returns the .metadata of a new document or folder, as JSON. */
+func newMetadataJSON(name, parent string, isFolder bool, now time.Time) ([]byte, error) {
+	if err := validateParentId(parent); err != nil {
+		return nil, err
+	}
+
+	timestamp := fmt.Sprintf("%d", now.UnixMilli())
 	metadata := SSHMetadata{
 		Deleted:          false,
-		LastModified:     fmt.Sprintf("%d", time.Now().UnixMilli()),
+		LastModified:     timestamp,
 		MetadataModified: false,
 		Modified:         false,
 		Parent:           parent,
@@ -354,35 +499,38 @@ func (s *SSHConnection) CreateMetadataFile(id, name, parent string, isFolder boo
 
 	if !isFolder {
 		metadata.Type = "DocumentType"
+		metadata.CreatedTime = timestamp
 		lastOpenedPage := 0
 		metadata.LastOpenedPage = &lastOpenedPage
 	}
 
 	metadataJSON, err := json.MarshalIndent(metadata, "", "    ")
 	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %v", err)
+		return nil, fmt.Errorf("failed to marshal metadata: %v", err)
+	}
+	return metadataJSON, nil
+}
+
+// CreateMetadataFile creates a metadata file on the remote server
+func (s *SSHConnection) CreateMetadataFile(id, name, parent string, isFolder bool) error {
+	if err := validateDocId(id); err != nil {
+		return err
 	}
 
-	// Write metadata to temporary file and upload
-	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s.metadata", id))
-	err = s.WriteRemoteFile(tempFile, string(metadataJSON))
+	metadataJSON, err := newMetadataJSON(name, parent, isFolder, time.Now())
 	if err != nil {
-		return fmt.Errorf("failed to write metadata file: %v", err)
+		return err
 	}
 
-	// Move to final location
-	finalPath := fmt.Sprintf("~/.local/share/remarkable/xochitl/%s.metadata", id)
-	moveCommand := fmt.Sprintf("mv %s %s", tempFile, finalPath)
-	_, err = s.executeSSHCommand(moveCommand)
+	err = s.WriteRemoteFile(xochitlPath(id+".metadata"), string(metadataJSON))
 	if err != nil {
-		return fmt.Errorf("failed to move metadata file: %v", err)
+		return fmt.Errorf("failed to write metadata file: %v", err)
 	}
-
 	return nil
 }
 
-// CreateContentFile creates a content file on the remote server
-func (s *SSHConnection) CreateContentFile(id, fileType string) error {
+/* Returns the .content of a new document of the file type ("pdf", "epub" or "" for folders). */
+func newContent(fileType string) SSHContent {
 	content := SSHContent{
 		ExtraMetadata:  make(map[string]interface{}),
 		FileType:       fileType,
@@ -390,112 +538,206 @@ func (s *SSHConnection) CreateContentFile(id, fileType string) error {
 		LastOpenedPage: 0,
 		LineHeight:     -1,
 		Margins:        100,
-		PageCount:      1,
+		PageCount:      0,
 		TextScale:      1,
-		Transform: map[string]int{
-			"m11": 1, "m12": 1, "m13": 1,
-			"m21": 1, "m22": 1, "m23": 1,
-			"m31": 1, "m32": 1, "m33": 1,
-		},
+		Transform:      identityTransform(),
+	}
+
+	/* The pages of an EPUB are laid out by the tablet when it's opened, with the reader's defaults */
+	if fileType == "epub" {
+		content.Margins = 180
+		content.Orientation = "portrait"
+		content.TextAlignment = "justify"
+	}
+
+	return content
+}
+
+/* The transform of a document that isn't zoomed or moved */
+func identityTransform() map[string]float64 {
+	return map[string]float64{
+		"m11": 1, "m12": 0, "m13": 0,
+		"m21": 0, "m22": 1, "m23": 0,
+		"m31": 0, "m32": 0, "m33": 1,
+	}
+}
+
+/*
+Returns the .content of a new PDF document, the way the tablet writes it on import.
+Each page gets a new UUID, which is where the tablet keeps its annotations.
+Without the info (the PDF couldn't be read), the tablet counts the pages when the document is opened.
+*/
+func newPdfContent(info *PdfInfo, sizeInBytes int64) SSHContent {
+	content := newContent("pdf")
+	content.Margins = 125
+	content.FormatVersion = 1
+	content.ZoomMode = "bestFit"
+	content.Orientation = "portrait"
+	if sizeInBytes > 0 {
+		content.SizeInBytes = strconv.FormatInt(sizeInBytes, 10)
+	}
+
+	if info == nil {
+		return content
+	}
+
+	content.PageCount = info.PageCount()
+	content.OriginalPageCount = info.PageCount()
+	content.Pages = make([]string, info.PageCount())
+	content.RedirectionPageMap = make([]int, info.PageCount())
+	for i := range content.Pages {
+		content.Pages[i] = uuid.New().String()
+		content.RedirectionPageMap[i] = i
 	}
 
+	if first := info.PageSizes[0]; first.Width > first.Height {
+		content.Orientation = "landscape"
+	}
+
+	if info.Title != "" || info.Author != "" {
+		content.DocumentMetadata = &SSHDocumentMetadata{Title: info.Title}
+		if info.Author != "" {
+			content.DocumentMetadata.Authors = []string{info.Author}
+		}
+	}
+	return content
+}
+
+func marshalContent(content SSHContent) ([]byte, error) {
 	contentJSON, err := json.MarshalIndent(content, "", "    ")
 	if err != nil {
-		return fmt.Errorf("failed to marshal content: %v", err)
+		return nil, fmt.Errorf("failed to marshal content: %v", err)
 	}
+	return contentJSON, nil
+}
 
-	// Write content to temporary file and upload
-	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s.content", id))
-	err = s.WriteRemoteFile(tempFile, string(contentJSON))
+// CreateContentFile creates a content file on the remote server
+func (s *SSHConnection) CreateContentFile(id string, content SSHContent) error {
+	if err := validateDocId(id); err != nil {
+		return err
+	}
+
+	contentJSON, err := marshalContent(content)
+	if err != nil {
+		return err
+	}
+
+	err = s.WriteRemoteFile(xochitlPath(id+".content"), string(contentJSON))
 	if err != nil {
 		return fmt.Errorf("failed to write content file: %v", err)
 	}
+	return nil
+}
 
-	// Move to final location
-	finalPath := fmt.Sprintf("~/.local/share/remarkable/xochitl/%s.content", id)
-	moveCommand := fmt.Sprintf("mv %s %s", tempFile, finalPath)
-	_, err = s.executeSSHCommand(moveCommand)
+/*
+Writes content to a file on the remote server, readable by everyone.
+The file is replaced atomically, xochitl never reads a half-written .metadata or .content.
+*/
+func (s *SSHConnection) WriteRemoteFile(remotePath, content string) error {
+	transfer, err := s.getTransfer()
 	if err != nil {
-		return fmt.Errorf("failed to move content file: %v", err)
+		return err
 	}
 
-	return nil
+	err = transfer.MkdirAll(path.Dir(remotePath))
+	if err != nil {
+		return fmt.Errorf("failed to create remote directory: %v", err)
+	}
+	return writeFileAtomic(transfer, remotePath, []byte(content), 0644)
 }
 
-// WriteRemoteFile writes content to a file on the remote server
-func (s *SSHConnection) WriteRemoteFile(path, content string) error {
-	// Create a temporary local file
-	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("temp_%d", time.Now().UnixNano()))
-	err := os.WriteFile(tempFile, []byte(content), 0644)
+/*
+Changes fields of a document's .metadata file, e.g. {"parent": ""}.
+Fields the app doesn't know about are kept as they are.
+*/
+func (s *SSHConnection) UpdateMetadata(id string, fields map[string]interface{}) error {
+	if err := validateDocId(id); err != nil {
+		return err
+	}
+
+	metadataPath := xochitlPath(id + ".metadata")
+	data, err := s.ReadRemoteFile(metadataPath)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %v", err)
+		return fmt.Errorf("failed to read metadata of %s: %w", id, err)
 	}
-	defer os.Remove(tempFile)
 
-	// Upload the temporary file to the remote location
-	err = s.UploadFile(tempFile, path)
+	updated, err := updateMetadataJSON(data, fields, time.Now())
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %v", err)
+		return fmt.Errorf("failed to update metadata of %s: %v", id, err)
 	}
 
+	err = s.WriteRemoteFile(metadataPath, string(updated))
+	if err != nil {
+		return fmt.Errorf("failed to write metadata of %s: %v", id, err)
+	}
 	return nil
 }
 
-// DownloadDocument downloads a complete document (PDF, metadata, content) via SSH
-func (s *SSHConnection) DownloadDocument(id, localDir string, formats []string) error {
-	runtime.LogInfof(s.ctx, "[SSH] Downloading document %s to %s", id, localDir)
-
-	// Create local directory
-	err := os.MkdirAll(localDir, 0755)
+/*
+Sets the fields in a .metadata file and marks it as modified, so that xochitl syncs the change.
+Numbers are kept as they are written, unknown fields are kept too.
+*/
+func updateMetadataJSON(data []byte, fields map[string]interface{}, now time.Time) ([]byte, error) {
+	metadata := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&metadata)
 	if err != nil {
-		return fmt.Errorf("failed to create local directory: %v", err)
+		return nil, err
 	}
 
-	// Download each requested format
-	for _, format := range formats {
-		remotePath := fmt.Sprintf("~/.local/share/remarkable/xochitl/%s.%s", id, format)
-		localPath := filepath.Join(localDir, fmt.Sprintf("%s.%s", id, format))
+	for key, value := range fields {
+		metadata[key] = value
+	}
+	metadata["lastModified"] = fmt.Sprintf("%d", now.UnixMilli())
+	metadata["metadatamodified"] = true
 
-		err = s.DownloadFile(remotePath, localPath)
-		if err != nil {
-			runtime.LogErrorf(s.ctx, "[SSH] Failed to download %s: %v", format, err)
-			// Continue with other formats even if one fails
-		} else {
-			runtime.LogInfof(s.ctx, "[SSH] Successfully downloaded %s", format)
-		}
+	return json.MarshalIndent(metadata, "", "    ")
+}
+
+/* Everything xochitl may store for a document next to its .metadata file. */
+var documentFileSuffixes = []string{
+	"", ".metadata", ".content", ".pdf", ".epub", ".epubindex", ".pagedata", ".local", ".bookm", ".tombstone",
+	".cache", ".highlights", ".thumbnails", ".textconversion",
+}
+
+/* Permanently removes all the files and directories of a document. */
+func (s *SSHConnection) RemoveDocumentFiles(id string) error {
+	if err := validateDocId(id); err != nil {
+		return err
 	}
 
-	// Download metadata file
-	metadataPath := fmt.Sprintf("~/.local/share/remarkable/xochitl/%s.metadata", id)
-	localMetadataPath := filepath.Join(localDir, fmt.Sprintf("%s.metadata", id))
-	err = s.DownloadFile(metadataPath, localMetadataPath)
-	if err != nil {
-		runtime.LogErrorf(s.ctx, "[SSH] Failed to download metadata: %v", err)
+	command := newRemoteCommand("rm", "-rf")
+	for _, suffix := range documentFileSuffixes {
+		command.path(xochitlPath(id + suffix))
 	}
 
-	// Download content file
-	contentPath := fmt.Sprintf("~/.local/share/remarkable/xochitl/%s.content", id)
-	localContentPath := filepath.Join(localDir, fmt.Sprintf("%s.content", id))
-	err = s.DownloadFile(contentPath, localContentPath)
+	_, err := s.executeSSHCommand(command)
 	if err != nil {
-		runtime.LogErrorf(s.ctx, "[SSH] Failed to download content: %v", err)
+		return fmt.Errorf("failed to remove files of %s: %v", id, err)
 	}
-
 	return nil
 }
 
+/* The directories xochitl expects next to a document's files */
+func documentDirectories(id string) []string {
+	return []string{id + ".cache", id + ".highlights", id + ".thumbnails"}
+}
+
 // CreateDirectories creates the necessary directories for a document
 func (s *SSHConnection) CreateDirectories(id string) error {
-	basePath := fmt.Sprintf("~/.local/share/remarkable/xochitl/%s", id)
+	if err := validateDocId(id); err != nil {
+		return err
+	}
 
-	dirs := []string{
-		fmt.Sprintf("%s.cache", basePath),
-		fmt.Sprintf("%s.highlights", basePath),
-		fmt.Sprintf("%s.thumbnails", basePath),
+	transfer, err := s.getTransfer()
+	if err != nil {
+		return err
 	}
 
-	for _, dir := range dirs {
-		_, err := s.executeSSHCommand(fmt.Sprintf("mkdir -p %s", dir))
+	for _, name := range documentDirectories(id) {
+		dir := xochitlPath(name)
+		err := transfer.MkdirAll(dir)
 		if err != nil {
 			return fmt.Errorf("failed to create directory %s: %v", dir, err)
 		}
@@ -504,9 +746,23 @@ func (s *SSHConnection) CreateDirectories(id string) error {
 	return nil
 }
 
+/*
+Returns the state of the xochitl service, e.g. "active" or "inactive".
+Tablets with another launcher (e.g. Oxide or remux) may not run it at all.
+*/
+func (s *SSHConnection) XochitlState() (string, error) {
+	/* is-active exits with an error for every state but "active", the state is printed anyway */
+	command := newRemoteCommand("systemctl", "is-active", "xochitl").or(newRemoteCommand("true"))
+	output, err := s.executeSSHCommand(command)
+	if err != nil {
+		return "", fmt.Errorf("failed to check xochitl: %v", err)
+	}
+	return strings.TrimSpace(output), nil
+}
+
 // RestartXochitl restarts the xochitl service
 func (s *SSHConnection) RestartXochitl() error {
-	_, err := s.executeSSHCommand("systemctl restart xochitl")
+	_, err := s.executeSSHCommand(newRemoteCommand("systemctl", "restart", "xochitl"))
 	if err != nil {
 		return fmt.Errorf("failed to restart xochitl: %v", err)
 	}
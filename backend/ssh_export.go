@@ -1,119 +1,234 @@
 package backend
 
 import (
-	"context"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
 	"os"
-	"path/filepath"
-	"time"
+	"path"
+	"strings"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
-type SSHExport struct {
-	ctx         context.Context
-	connection  *SSHConnection
-	options     RmExportOptions
-	items       []DocInfo
-	export_from int
+/* Where xochitl keeps the page templates, as PNG images */
+const templatesDir = "/usr/share/remarkable/templates"
+
+/* The document turned out to have a source file, it's downloaded instead of rendered */
+var errNotNotebook = errors.New("not a notebook")
+
+/* Reads files of the tablet; SSHConnection, tests use a fake. */
+type remoteFileReader interface {
+	ReadRemoteFile(remotePath string) ([]byte, error)
 }
 
-type SSHExportOptions struct {
-	Location string
-	Pdf      bool
-	Rmdoc    bool
+/* Downloads the items' files from xochitl's directory over SSH. */
+type SSHTransport struct {
+	connection *SSHConnection
 }
 
-func InitSSHExport(ctx context.Context, options RmExportOptions, items []DocInfo, connection *SSHConnection) SSHExport {
-	return SSHExport{
-		ctx:         ctx,
-		connection:  connection,
-		options:     options,
-		items:       items,
-		export_from: 0,
-	}
+func NewSSHTransport(connection *SSHConnection) *SSHTransport {
+	return &SSHTransport{connection: connection}
 }
 
 /*
-Exports all items passed in Init() method using SSH.
-Calls the callbacks when:
-* item started downloading;
-* item download has finished;
-* item download has failed.
-
-Supports retries.
-In case the last export succeeded on all items, it starts the export again from the first item;
-otherwise, the export starts from the first failed item.
+PDFs and EPUBs are downloaded as they are stored on the tablet, the annotations are written into
+the PDFs here. Notebooks have no PDF on the tablet, their pages are rendered here instead.
+The tablet has no .rmdoc files either, the archive is packed from the document's files.
 */
-func (s *SSHExport) Export(started, finished func(item DocInfo), failed func(item DocInfo, err error)) {
-	formats := []string{}
-	if s.options.Rmdoc {
-		formats = append(formats, "rmdoc")
+func (s *SSHTransport) Download(item DocInfo, format string, dest string) error {
+	if err := validateDocId(item.Id); err != nil {
+		return err
 	}
-	if s.options.Pdf {
-		formats = append(formats, "pdf")
+
+	if format == "rmdoc" {
+		data, err := buildRmdoc(s.connection, item.Id)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dest, data, 0644)
 	}
 
-	runtime.LogInfof(s.ctx, "[%v] SSH Export formats: %v", time.Now().UTC(), formats)
-	runtime.LogInfof(s.ctx, "[%v] SSH Export location: %v", time.Now().UTC(), s.options.Location)
+	if format == "pdf" && (item.FileType == nil || *item.FileType == "notebook") {
+		data, err := renderNotebookPdf(s.connection, item)
+		if err == nil {
+			return os.WriteFile(dest, data, 0644)
+		}
+		if !errors.Is(err, errNotNotebook) {
+			return err
+		}
+	}
 
-	for i := s.export_from; i < len(s.items); i++ {
-		item := s.items[i]
-		started(item)
+	remotePath := xochitlPath(item.Id + "." + format)
+	if _, err := s.connection.Stat(remotePath); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: document %s has no %s file", ErrFormatUnavailable, item.Id, format)
+	}
 
-		err := s.exportOne(item, formats)
-		if err != nil {
-			s.export_from = i
-			failed(item, err)
-			return
-		}
+	err := s.connection.DownloadFile(remotePath, dest)
+	if err != nil {
+		return fmt.Errorf("failed to download document: %w", err)
+	}
+	if format == "pdf" {
+		return s.annotate(item, dest)
+	}
+	return nil
+}
+
+/* Writes the annotations made on the tablet into a downloaded PDF. */
+func (s *SSHTransport) annotate(item DocInfo, dest string) error {
+	original, err := os.ReadFile(dest)
+	if err != nil {
+		return err
+	}
 
-		finished(item)
+	annotated, err := annotatedPdf(s.connection, item, original)
+	if errors.Is(err, errPdfNotAnnotated) {
+		runtime.LogWarningf(s.connection.GetContext(), "[SSH] Exporting %s without annotations: %v", item.Id, err)
+		return nil
 	}
+	if err != nil || annotated == nil {
+		return err
+	}
+	return os.WriteFile(dest, annotated, 0644)
 }
 
-func (s *SSHExport) exportOne(item DocInfo, formats []string) error {
-	if item.IsFolder {
+/* Reads a remote file, a file that doesn't exist is returned as nil without an error. */
+func readOptional(files remoteFileReader, remotePath string) ([]byte, error) {
+	data, err := files.ReadRemoteFile(remotePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+/*
+Reads the template of a page. The background is optional: a template that isn't installed,
+e.g. one that was synced from another tablet, or that can't be decoded is left out.
+*/
+func readTemplate(files remoteFileReader, name string, cache map[string]image.Image) image.Image {
+	if name == "" || name == "Blank" || path.Base(name) != name || strings.HasPrefix(name, ".") {
 		return nil
 	}
+	if img, ok := cache[name]; ok {
+		return img
+	}
 
-	runtime.LogInfof(s.ctx, "[%v] SSH Exporting item, id=%v", time.Now().UTC(), item.Id)
+	var img image.Image
+	if data, err := readOptional(files, path.Join(templatesDir, name+".png")); err == nil && data != nil {
+		img, _ = png.Decode(bytes.NewReader(data))
+	}
+	cache[name] = img
+	return img
+}
 
-	// Create the local directory structure
-	localDir, err := s.createLocalDirectory(item)
+/*
+Returns the PDF with the annotations made on the tablet, or nil if the pages have no annotations
+and none of them was added, deleted or moved on the tablet.
+*/
+func annotatedPdf(files remoteFileReader, item DocInfo, original []byte) ([]byte, error) {
+	data, err := files.ReadRemoteFile(xochitlPath(item.Id + ".content"))
 	if err != nil {
-		return fmt.Errorf("failed to create local directory: %v", err)
+		return nil, fmt.Errorf("failed to read the content of %s: %w", item.Id, err)
 	}
-
-	// Download the document via SSH
-	err = s.connection.DownloadDocument(item.Id, localDir, formats)
+	var content SSHContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("failed to parse the content of %s: %v", item.Id, err)
+	}
+	pagedata, err := readOptional(files, xochitlPath(item.Id+".pagedata"))
 	if err != nil {
-		return fmt.Errorf("failed to download document: %v", err)
+		return nil, err
+	}
+	list, err := content.pageList(string(pagedata))
+	if err != nil || len(list) == 0 {
+		return nil, err
 	}
 
-	return nil
-}
+	/* Pages deleted at the end only show in the number of pages */
+	changed := content.PageCount != 0 && content.OriginalPageCount != 0 && content.PageCount != content.OriginalPageCount
+	templates := map[string]image.Image{}
+	pages := []rmAnnotatedPage{}
+	for i, page := range list {
+		if err := validateDocId(page.Id); err != nil {
+			return nil, fmt.Errorf("invalid page ID in %s: %q", item.Id, page.Id)
+		}
 
-func (s *SSHExport) createLocalDirectory(item DocInfo) (string, error) {
-	// Create a path similar to the HTTP export but for SSH
-	// Use the item's tablet path to create the directory structure
-	pathParts := []string{s.options.Location}
+		p := rmAnnotatedPage{Redirect: page.Redirect}
+		data, err := readOptional(files, xochitlPath(item.Id+"/"+page.Id+".rm"))
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			if p.Page, err = ParseRmPage(data); err != nil {
+				return nil, fmt.Errorf("failed to read page %d of %s: %v", i+1, item.Id, err)
+			}
+		}
+		if page.Redirect < 0 {
+			p.Template = readTemplate(files, page.Template, templates)
+		}
+		changed = changed || p.Page != nil || page.Redirect != i
+		pages = append(pages, p)
+	}
+	if !changed {
+		return nil, nil
+	}
 
-	// Add the tablet path components
-	for _, part := range item.TabletPath {
-		pathParts = append(pathParts, part)
+	annotated, err := annotatePdf(original, pages)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errPdfNotAnnotated, err)
 	}
+	return annotated, nil
+}
 
-	// Join all parts
-	localDir := filepath.Join(pathParts...)
+/* Renders the pages of a notebook as PDF, from its .content file, its .rm pages and the templates. */
+func renderNotebookPdf(files remoteFileReader, item DocInfo) ([]byte, error) {
+	data, err := files.ReadRemoteFile(xochitlPath(item.Id + ".content"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the content of %s: %w", item.Id, err)
+	}
+	var content SSHContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("failed to parse the content of %s: %v", item.Id, err)
+	}
+	if content.FileType == "pdf" || content.FileType == "epub" {
+		return nil, fmt.Errorf("%w: document %s is a %s", errNotNotebook, item.Id, content.FileType)
+	}
 
-	// Create the directory
-	err := os.MkdirAll(localDir, 0755)
+	pagedata, err := readOptional(files, xochitlPath(item.Id+".pagedata"))
+	if err != nil {
+		return nil, err
+	}
+	pages, err := content.pageList(string(pagedata))
 	if err != nil {
-		return "", fmt.Errorf("failed to create directory %s: %v", localDir, err)
+		return nil, err
 	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%w: notebook %s has no pages", ErrFormatUnavailable, item.Id)
+	}
+
+	templates := map[string]image.Image{}
+	pdfPages := []rmPdfPage{}
+	for i, page := range pages {
+		if err := validateDocId(page.Id); err != nil {
+			return nil, fmt.Errorf("invalid page ID in %s: %q", item.Id, page.Id)
+		}
 
-	runtime.LogDebugf(s.ctx, "[%v] Created local directory: %v, id=%v", time.Now().UTC(), localDir, item.Id)
+		p := rmPdfPage{Template: readTemplate(files, page.Template, templates)}
+		/* A page that was never drawn on has no .rm file */
+		data, err := readOptional(files, xochitlPath(item.Id+"/"+page.Id+".rm"))
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			if p.Page, err = ParseRmPage(data); err != nil {
+				return nil, fmt.Errorf("failed to read page %d of %s: %v", i+1, item.Id, err)
+			}
+		}
+		pdfPages = append(pdfPages, p)
+	}
 
-	return localDir, nil
+	return buildRmPdf(item.Name, pdfPages), nil
 }
@@ -0,0 +1,208 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+/* Files of the tablet by their remote paths */
+type fakeRemoteFiles map[string][]byte
+
+func (f fakeRemoteFiles) ReadRemoteFile(remotePath string) ([]byte, error) {
+	data, ok := f[remotePath]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return data, nil
+}
+
+func (f fakeRemoteFiles) ListDirectory(remotePath string) ([]RemoteFileInfo, error) {
+	entries := map[string]RemoteFileInfo{}
+	for name, data := range f {
+		rest, ok := strings.CutPrefix(name, remotePath+"/")
+		if !ok {
+			continue
+		}
+		if dir, _, isDir := strings.Cut(rest, "/"); isDir {
+			entries[dir] = RemoteFileInfo{Name: dir, IsDir: true}
+		} else {
+			entries[rest] = RemoteFileInfo{Name: rest, Size: int64(len(data))}
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	result := []RemoteFileInfo{}
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+const (
+	testNotebookId = "6f0c4f4e-2a7d-4c3e-9d55-1b2a8c0e9f10"
+	testPage1      = "a1a1a1a1-0000-4000-8000-000000000001"
+	testPage2      = "a1a1a1a1-0000-4000-8000-000000000002"
+	testPage3      = "a1a1a1a1-0000-4000-8000-000000000003"
+)
+
+func TestContentPageList(t *testing.T) {
+	/* Version 1: the templates are in .pagedata, the original pages in redirectionPageMap */
+	content := SSHContent{FileType: "pdf", Pages: []string{"p1", "p2", "p3"}, RedirectionPageMap: []int{0, -1, 1}}
+	pages, err := content.pageList("Blank\nP Grid small\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rmContentPage{{"p1", "Blank", 0}, {"p2", "P Grid small", -1}, {"p3", "", 1}}
+	if diff := cmp.Diff(want, pages); diff != "" {
+		t.Errorf("pages mismatch (-want +got):\n%s", diff)
+	}
+
+	/* A PDF without a page map shows its pages in order, a notebook has no original pages */
+	content = SSHContent{FileType: "pdf", Pages: []string{"p1", "p2"}}
+	if pages, _ = content.pageList(""); pages[1].Redirect != 1 {
+		t.Errorf("unexpected PDF pages: %+v", pages)
+	}
+	content.FileType = "notebook"
+	if pages, _ = content.pageList(""); pages[1].Redirect != -1 {
+		t.Errorf("unexpected notebook pages: %+v", pages)
+	}
+
+	/* Version 2: cPages, ordered by index, deleted pages left out */
+	content = SSHContent{FileType: "pdf", CPages: []byte(`{
+		"lastOpened": {"timestamp": "1:1", "value": "p3"},
+		"pages": [
+			{"id": "p3", "idx": {"timestamp": "1:2", "value": "bc"}, "template": {"timestamp": "1:1", "value": "Blank"}},
+			{"id": "p1", "idx": {"timestamp": "1:2", "value": "ba"}, "redir": {"timestamp": "1:2", "value": 0}},
+			{"id": "p2", "idx": {"timestamp": "1:2", "value": "bb"}, "redir": {"timestamp": "1:2", "value": 1}, "deleted": {"timestamp": "1:3", "value": 1}},
+			{"id": "p4", "idx": {"timestamp": "1:2", "value": "bd"}, "redir": {"timestamp": "1:2", "value": 2}, "scrollTime": {"timestamp": "1:1", "value": "0"}}
+		]
+	}`)}
+	pages, err = content.pageList("ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []rmContentPage{{"p1", "", 0}, {"p3", "Blank", -1}, {"p4", "", 2}}
+	if diff := cmp.Diff(want, pages); diff != "" {
+		t.Errorf("pages mismatch (-want +got):\n%s", diff)
+	}
+
+	content.CPages = []byte(`{"pages": "invalid"}`)
+	if _, err := content.pageList(""); err == nil {
+		t.Errorf("invalid cPages were accepted")
+	}
+}
+
+func testTemplatePng(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRenderNotebookPdf(t *testing.T) {
+	drawn, err := EncodeRmPage(&RmPage{Version: 6, Scene: testRmScene()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := fakeRemoteFiles{
+		xochitlPath(testNotebookId + ".content"): []byte(`{"fileType": "notebook", "formatVersion": 2, "cPages": {"pages": [
+			{"id": "` + testPage1 + `", "idx": {"value": "ba"}, "template": {"value": "P Lines small"}},
+			{"id": "` + testPage2 + `", "idx": {"value": "bb"}, "template": {"value": "Not installed"}},
+			{"id": "` + testPage3 + `", "idx": {"value": "bc"}, "template": {"value": "P Lines small"}, "deleted": {"value": 1}}
+		]}}`),
+		xochitlPath(testNotebookId + "/" + testPage1 + ".rm"): drawn,
+		templatesDir + "/P Lines small.png":                   testTemplatePng(t),
+		xochitlPath(testNotebookId + "/" + testPage3 + ".rm"): []byte("broken, but deleted"),
+	}
+	item := DocInfo{Id: testNotebookId, Name: "Notes"}
+
+	data, err := renderNotebookPdf(files, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf := openWrittenPdf(t, data)
+	pages := pdfPages(t, pdf)
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(pages))
+	}
+	if !strings.Contains(pdfPageContent(t, pdf, pages[0]), "/Template Do") {
+		t.Errorf("the first page has no template")
+	}
+	if content := pdfPageContent(t, pdf, pages[1]); strings.Contains(content, "/Template Do") || strings.Contains(content, " S\n") {
+		t.Errorf("the empty page has content: %s", content)
+	}
+
+	/* A broken page fails the export */
+	files[xochitlPath(testNotebookId+"/"+testPage2+".rm")] = []byte("reMarkable .lines file, version=6          \x10")
+	if _, err := renderNotebookPdf(files, item); err == nil || !strings.Contains(err.Error(), "page 2") {
+		t.Errorf("broken page wasn't reported: %v", err)
+	}
+
+	/* A PDF is downloaded as it is */
+	files[xochitlPath(testNotebookId+".content")] = []byte(`{"fileType": "pdf"}`)
+	if _, err := renderNotebookPdf(files, item); !errors.Is(err, errNotNotebook) {
+		t.Errorf("unexpected error for a PDF: %v", err)
+	}
+
+	/* Page IDs become remote paths */
+	files[xochitlPath(testNotebookId+".content")] = []byte(`{"fileType": "notebook", "pages": ["../../etc/passwd"]}`)
+	if _, err := renderNotebookPdf(files, item); err == nil {
+		t.Errorf("invalid page ID was accepted")
+	}
+}
+
+func TestAnnotatedPdf(t *testing.T) {
+	original, err := os.ReadFile(writePdf(t, testPdfObjects, "<< /Size 9 /Root 1 0 R /Info 7 0 R >>", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := xochitlPath(testNotebookId + ".content")
+	files := fakeRemoteFiles{
+		content: []byte(`{"fileType": "pdf", "pages": ["` + testPage1 + `", "` + testPage2 + `", "` + testPage3 + `"]}`),
+	}
+	item := DocInfo{Id: testNotebookId, Name: "Paper"}
+
+	/* A PDF that was only read is exported as it is */
+	if data, err := annotatedPdf(files, item, original); err != nil || data != nil {
+		t.Errorf("unchanged PDF was rewritten: %v", err)
+	}
+
+	drawn, err := EncodeRmPage(&RmPage{Version: 6, Scene: testRmScene()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[xochitlPath(testNotebookId+"/"+testPage2+".rm")] = drawn
+	data, err := annotatedPdf(files, item, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := pdfPages(t, openWrittenPdf(t, data))
+	if len(pages) != 3 || pages[0]["Resources"] != nil || pages[1]["Resources"] == nil {
+		t.Errorf("unexpected pages: %v", pages)
+	}
+
+	/* A page deleted on the tablet is left out */
+	delete(files, xochitlPath(testNotebookId+"/"+testPage2+".rm"))
+	files[content] = []byte(`{"fileType": "pdf", "pages": ["` + testPage1 + `", "` + testPage3 + `"], "redirectionPageMap": [0, 2]}`)
+	if data, err = annotatedPdf(files, item, original); err != nil {
+		t.Fatal(err)
+	}
+	if pages := pdfPages(t, openWrittenPdf(t, data)); len(pages) != 2 {
+		t.Errorf("expected 2 pages, got %d", len(pages))
+	}
+
+	/* A PDF that can't be written into is reported, to be exported without the annotations */
+	if _, err := annotatedPdf(files, item, []byte("not a PDF")); !errors.Is(err, errPdfNotAnnotated) {
+		t.Errorf("unexpected error for a broken PDF: %v", err)
+	}
+}
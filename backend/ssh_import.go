@@ -1,81 +1,273 @@
 package backend
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
+/* What the importer needs from the connection, SSHConnection in the app. */
+type importTarget interface {
+	stagingTarget
+	remoteFileReader
+	GetContext() context.Context
+	ListXochitlFiles() ([]SSHFileInfo, error)
+	UpdateMetadata(id string, fields map[string]interface{}) error
+	executeSSHCommand(command *remoteCommand) (string, error)
+}
+
 // SSHImporter handles file uploads to the reMarkable device via SSH
 type SSHImporter struct {
-	connection *SSHConnection
+	connection importTarget
 }
 
 // NewSSHImporter creates a new SSH importer
-func NewSSHImporter(connection *SSHConnection) *SSHImporter {
+func NewSSHImporter(connection importTarget) *SSHImporter {
 	return &SSHImporter{
 		connection: connection,
 	}
 }
 
-// UploadFile uploads a file to the reMarkable device and returns the generated UUID
-func (s *SSHImporter) UploadFile(localPath, fileName, parentId string) (string, error) {
-	ctx := s.connection.GetContext()
-	runtime.LogInfof(ctx, "[SSH_IMPORT] Starting upload: %s -> %s (parent: %s)", localPath, fileName, parentId)
+/* Logs to the app's log. A connection without the app's context, e.g. in tests, logs nothing. */
+func (s *SSHImporter) logInfof(format string, args ...any) {
+	if ctx := s.connection.GetContext(); ctx != nil {
+		runtime.LogInfof(ctx, format, args...)
+	}
+}
+
+func (s *SSHImporter) logWarningf(format string, args ...any) {
+	if ctx := s.connection.GetContext(); ctx != nil {
+		runtime.LogWarningf(ctx, format, args...)
+	}
+}
 
-	// Validate file extension - only allow PDF files for now
+/* The file types xochitl can open, by file extension */
+var importFileTypes = map[string]string{
+	".pdf":  "pdf",
+	".epub": "epub",
+}
+
+/* Returns true for the files UploadFile accepts. */
+func isImportable(fileName string) bool {
 	ext := strings.ToLower(filepath.Ext(fileName))
-	if ext != ".pdf" {
-		return "", fmt.Errorf("only PDF files are supported, got: %s", ext)
+	_, ok := importFileTypes[ext]
+	return ok || ext == ".rmdoc"
+}
+
+/*
+Uploads a file without restarting xochitl, so the tablet doesn't show it yet.
+Duplicates are looked up in the index, which gets the uploaded document.
+The files are staged and moved into place together, unless ctx is cancelled before that.
+*/
+func (s *SSHImporter) uploadFile(ctx context.Context, localPath, fileName, parentId string, policy DuplicatePolicy, index *tabletIndex) (UploadResult, error) {
+	s.logInfof("[SSH_IMPORT] Starting upload: %s -> %s (parent: %s)", localPath, fileName, parentId)
+
+	if err := validateDuplicatePolicy(policy); err != nil {
+		return UploadResult{}, err
+	}
+
+	// Remove extension from filename for visible name
+	ext := strings.ToLower(filepath.Ext(fileName))
+	visibleName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+
+	/* An .rmdoc brings its own document, it's always imported as a new one */
+	if ext == ".rmdoc" {
+		id, err := s.importRmdoc(ctx, localPath, RmdocImportOptions{ParentId: parentId})
+		if err != nil {
+			return UploadResult{}, err
+		}
+		index.add(indexedItem{id: id, parent: parentId, name: visibleName}, "")
+		return UploadResult{Id: id, Name: visibleName, Action: UploadCreated}, nil
+	}
+
+	// Validate file extension - only the documents xochitl can open
+	fileType, ok := importFileTypes[ext]
+	if !ok {
+		return UploadResult{}, fmt.Errorf("only PDF, EPUB and .rmdoc files are supported, got: %s", ext)
+	}
+
+	content, err := s.documentContent(localPath, fileType)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	if err := validateParentId(parentId); err != nil {
+		return UploadResult{}, err
+	}
+
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	var hash string
+	localHash := func() (string, error) {
+		if hash != "" {
+			return hash, nil
+		}
+		h, err := fileSHA256(localPath)
+		hash = h
+		return h, err
+	}
+
+	file := indexedItem{parent: parentId, name: visibleName, fileType: fileType, size: stat.Size()}
+	duplicate, err := index.findDuplicate(file, localHash, s.remoteSHA256)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	if duplicate != nil {
+		s.logInfof("[SSH_IMPORT] %s is a duplicate of %s (same content: %v, policy: %s)",
+			fileName, duplicate.DuplicateOf, duplicate.SameContent, policy)
+
+		switch {
+		case policy == DuplicateSkip, policy == DuplicateReplace && duplicate.SameContent:
+			duplicate.Action = UploadSkipped
+			return *duplicate, nil
+		case policy == DuplicateReplace:
+			err := s.replaceDocument(ctx, duplicate.Id, localPath, fileType, content)
+			if err != nil {
+				return UploadResult{}, err
+			}
+			index.replace(duplicate.Id, file.size, hash)
+			duplicate.Action = UploadReplaced
+			return *duplicate, nil
+		}
+		visibleName = index.uniqueName(visibleName, parentId)
 	}
 
 	// Generate a new UUID for the document
 	uuidStr := uuid.New().String()
-	runtime.LogInfof(ctx, "[SSH_IMPORT] Generated UUID: %s", uuidStr)
+	s.logInfof("[SSH_IMPORT] Generated UUID: %s", uuidStr)
 
-	// Remove extension from filename for visible name
-	visibleName := strings.TrimSuffix(fileName, ext)
+	/* The document appears on the tablet complete or not at all */
+	stage, err := newRemoteStage(s.connection)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer stage.abort()
 
 	// Upload the main file
-	remoteFilePath := fmt.Sprintf("~/.local/share/remarkable/xochitl/%s%s", uuidStr, ext)
-	err := s.connection.UploadFile(localPath, remoteFilePath)
+	err = stage.upload(localPath, uuidStr+ext)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload file: %v", err)
+		return UploadResult{}, err
 	}
-	runtime.LogInfo(ctx, "[SSH_IMPORT] File upload successful")
+	s.logInfof("[SSH_IMPORT] File upload successful")
 
-	// Create metadata file
-	err = s.connection.CreateMetadataFile(uuidStr, visibleName, parentId, false)
+	metadataJSON, err := newMetadataJSON(visibleName, parentId, false, time.Now())
 	if err != nil {
-		return "", fmt.Errorf("failed to create metadata: %v", err)
+		return UploadResult{}, err
+	}
+	err = stage.write(uuidStr+".metadata", metadataJSON)
+	if err != nil {
+		return UploadResult{}, err
 	}
-	runtime.LogInfo(ctx, "[SSH_IMPORT] Metadata file created successfully")
 
-	// Create content file
-	fileType := strings.TrimPrefix(ext, ".")
-	err = s.connection.CreateContentFile(uuidStr, fileType)
+	contentJSON, err := marshalContent(content)
 	if err != nil {
-		return "", fmt.Errorf("failed to create content file: %v", err)
+		return UploadResult{}, err
+	}
+	err = stage.write(uuidStr+".content", contentJSON)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	for _, dir := range documentDirectories(uuidStr) {
+		err = stage.mkdir(dir)
+		if err != nil {
+			return UploadResult{}, err
+		}
 	}
-	runtime.LogInfo(ctx, "[SSH_IMPORT] Content file created successfully")
 
-	// Create necessary directories
-	err = s.connection.CreateDirectories(uuidStr)
+	err = stage.commit(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to create directories: %v", err)
+		return UploadResult{}, err
 	}
-	runtime.LogInfo(ctx, "[SSH_IMPORT] Directories created successfully")
 
-	// Restart xochitl to make the new document visible
-	err = s.connection.RestartXochitl()
+	file.id = uuidStr
+	file.name = visibleName
+	index.add(file, hash)
+
+	s.logInfof("[SSH_IMPORT] Upload process completed successfully!")
+	result := UploadResult{Id: uuidStr, Name: visibleName, Action: UploadCreated}
+	if duplicate != nil {
+		result.DuplicateOf = duplicate.DuplicateOf
+		result.SameContent = duplicate.SameContent
+	}
+	return result, nil
+}
+
+/*
+Checks the local file and returns the .content to write for it.
+A PDF this parser can't read is still uploaded, the tablet has the final say on it.
+*/
+func (s *SSHImporter) documentContent(localPath, fileType string) (SSHContent, error) {
+	if fileType == "epub" {
+		return newContent(fileType), validateEpub(localPath)
+	}
+
+	stat, err := os.Stat(localPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to restart xochitl: %v", err)
+		return SSHContent{}, err
 	}
-	runtime.LogInfo(ctx, "[SSH_IMPORT] Xochitl restarted successfully")
 
-	runtime.LogInfo(ctx, "[SSH_IMPORT] Upload process completed successfully!")
+	info, err := ReadPdfInfo(localPath)
+	if errors.Is(err, errNotPdf) {
+		return SSHContent{}, fmt.Errorf("%s is not a PDF file", filepath.Base(localPath))
+	}
+	if err != nil {
+		s.logWarningf("[SSH_IMPORT] Couldn't read the PDF, the tablet will count its pages: %v", err)
+		info = nil
+	}
+	return newPdfContent(info, stat.Size()), nil
+}
+
+// CreateFolder creates an empty folder on the reMarkable device and returns its UUID
+func (s *SSHImporter) CreateFolder(folderName, parentId string) (string, error) {
+	return s.createFolder(context.Background(), folderName, parentId)
+}
+
+func (s *SSHImporter) createFolder(ctx context.Context, folderName, parentId string) (string, error) {
+	// Generate a new UUID for the folder
+	uuidStr := uuid.New().String()
+
+	metadataJSON, err := newMetadataJSON(folderName, parentId, true, time.Now())
+	if err != nil {
+		return "", err
+	}
+	contentJSON, err := marshalContent(newContent(""))
+	if err != nil {
+		return "", err
+	}
+
+	stage, err := newRemoteStage(s.connection)
+	if err != nil {
+		return "", err
+	}
+	defer stage.abort()
+
+	// Create metadata file for the folder
+	err = stage.write(uuidStr+".metadata", metadataJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to create folder metadata: %v", err)
+	}
+
+	// Create empty content file for folder
+	err = stage.write(uuidStr+".content", contentJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to create folder content: %v", err)
+	}
+
+	err = stage.commit(ctx)
+	if err != nil {
+		return "", err
+	}
 	return uuidStr, nil
 }
@@ -0,0 +1,182 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/* A local file or folder to upload, folders are recreated on the tablet. */
+type UploadItem struct {
+	LocalPath  string
+	Name       string // file name with the extension, or the folder name
+	IsFolder   bool
+	ParentPath string // LocalPath of the parent folder in the upload, "" for the target folder
+}
+
+/*
+Lists everything to upload for the chosen local files and folders.
+Folders are walked recursively and come before their contents. Inside folders, hidden files
+and files the tablet can't open are skipped; files chosen directly are always listed,
+so that the user sees why they weren't uploaded.
+*/
+func PlanUpload(paths []string) ([]UploadItem, error) {
+	items := []UploadItem{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			items = append(items, UploadItem{LocalPath: p, Name: filepath.Base(p)})
+			continue
+		}
+
+		items, err = planFolder(items, p, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+func planFolder(items []UploadItem, dir string, parentPath string) ([]UploadItem, error) {
+	items = append(items, UploadItem{LocalPath: dir, Name: filepath.Base(dir), IsFolder: true, ParentPath: parentPath})
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Files first, then subfolders, both sorted by name */
+	slices.SortStableFunc(entries, func(a, b os.DirEntry) int {
+		if a.IsDir() != b.IsDir() {
+			if a.IsDir() {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		p := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			items, err = planFolder(items, p, dir)
+			if err != nil {
+				return nil, err
+			}
+		} else if entry.Type().IsRegular() && isImportable(entry.Name()) {
+			items = append(items, UploadItem{LocalPath: p, Name: entry.Name(), ParentPath: dir})
+		}
+	}
+	return items, nil
+}
+
+/*
+Uploads the items planned by PlanUpload into the parent folder, without restarting xochitl.
+Calls the callbacks when:
+* item started uploading;
+* item upload has finished, with the ID on the tablet and what happened to a duplicate;
+* item upload has failed.
+
+A failed item doesn't stop the upload, but the contents of a failed folder fail too.
+With the "skip" and "replace" policies, the contents of a folder that already exists go into it.
+Cancelling ctx fails the item in progress and the rest, the finished items stay on the tablet.
+*/
+func (s *SSHImporter) UploadBatch(ctx context.Context, items []UploadItem, parentId string, policy DuplicatePolicy,
+	started func(item UploadItem), finished func(item UploadItem, result UploadResult), failed func(item UploadItem, err error)) error {
+	s.logInfof("[SSH_IMPORT] Uploading %d items (parent: %s)", len(items), parentId)
+
+	if err := validateParentId(parentId); err != nil {
+		return err
+	}
+	if err := validateDuplicatePolicy(policy); err != nil {
+		return err
+	}
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	/* IDs of the created folders by their local paths */
+	folderIds := map[string]string{"": parentId}
+	uploaded := 0
+
+	for _, item := range items {
+		started(item)
+
+		if ctx.Err() != nil {
+			failed(item, errUploadCancelled)
+			continue
+		}
+
+		parent, ok := folderIds[item.ParentPath]
+		if !ok {
+			failed(item, fmt.Errorf("folder %s wasn't created", filepath.Base(item.ParentPath)))
+			continue
+		}
+
+		var result UploadResult
+		var err error
+		if item.IsFolder {
+			result, err = s.uploadFolder(ctx, item.Name, parent, policy, index)
+		} else {
+			result, err = s.uploadFile(ctx, item.LocalPath, item.Name, parent, policy, index)
+		}
+
+		if errors.Is(err, context.Canceled) {
+			err = errUploadCancelled
+		}
+		if err != nil {
+			failed(item, err)
+			continue
+		}
+
+		if item.IsFolder {
+			folderIds[item.LocalPath] = result.Id
+		}
+		uploaded++
+		finished(item, result)
+	}
+
+	s.logInfof("[SSH_IMPORT] Uploaded %d of %d items", uploaded, len(items))
+	if ctx.Err() != nil {
+		return errUploadCancelled
+	}
+	return nil
+}
+
+var errUploadCancelled = errors.New("upload cancelled")
+
+/* Creates a folder of the batch, or reuses the folder with the same name unless the policy is "copy". */
+func (s *SSHImporter) uploadFolder(ctx context.Context, name, parentId string, policy DuplicatePolicy, index *tabletIndex) (UploadResult, error) {
+	existing, ok := index.findFolder(name, parentId)
+	if ok && policy != DuplicateCopy {
+		return UploadResult{Id: existing.id, Name: name, Action: UploadSkipped, DuplicateOf: existing.id}, nil
+	}
+
+	result := UploadResult{Name: name, Action: UploadCreated}
+	if ok {
+		result.Name = index.uniqueName(name, parentId)
+		result.DuplicateOf = existing.id
+	}
+
+	id, err := s.createFolder(ctx, result.Name, parentId)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	result.Id = id
+	index.add(indexedItem{id: id, parent: parentId, name: result.Name, isFolder: true}, "")
+	return result, nil
+}
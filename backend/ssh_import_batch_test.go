@@ -0,0 +1,210 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlanUpload(t *testing.T) {
+	root := t.TempDir()
+	books := filepath.Join(root, "Books")
+	papers := filepath.Join(books, "Papers")
+	os.MkdirAll(papers, 0755)
+
+	for _, name := range []string{
+		filepath.Join(books, "b.epub"),
+		filepath.Join(books, "a.PDF"),
+		filepath.Join(books, "notes.txt"),
+		filepath.Join(books, ".hidden.pdf"),
+		filepath.Join(papers, "paper.pdf"),
+		filepath.Join(root, "single.txt"),
+	} {
+		os.WriteFile(name, []byte("x"), 0644)
+	}
+
+	items, err := PlanUpload([]string{filepath.Join(root, "single.txt"), books})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []UploadItem{
+		{LocalPath: filepath.Join(root, "single.txt"), Name: "single.txt"},
+		{LocalPath: books, Name: "Books", IsFolder: true},
+		{LocalPath: filepath.Join(books, "a.PDF"), Name: "a.PDF", ParentPath: books},
+		{LocalPath: filepath.Join(books, "b.epub"), Name: "b.epub", ParentPath: books},
+		{LocalPath: papers, Name: "Papers", IsFolder: true, ParentPath: books},
+		{LocalPath: filepath.Join(papers, "paper.pdf"), Name: "paper.pdf", ParentPath: papers},
+	}
+	if diff := cmp.Diff(want, items); diff != "" {
+		t.Errorf("Upload plan mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := PlanUpload([]string{filepath.Join(root, "missing")}); err == nil {
+		t.Errorf("missing path is accepted")
+	}
+}
+
+/* The tablet for the importer: the staged files in memory, a listing of the documents that were there before */
+type fakeImportTarget struct {
+	*fakeStagingTarget
+	listing []SSHFileInfo
+	hashes  map[string]string // SHA-256 of the documents' files by remote path
+	readErr error             // reading any file fails with this
+}
+
+func (f *fakeImportTarget) GetContext() context.Context {
+	return nil
+}
+
+func (f *fakeImportTarget) ListXochitlFiles() ([]SSHFileInfo, error) {
+	return f.listing, nil
+}
+
+func (f *fakeImportTarget) ReadRemoteFile(remotePath string) ([]byte, error) {
+	if f.readErr != nil {
+		return nil, f.readErr
+	}
+	content, ok := f.files[remotePath]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return []byte(content), nil
+}
+
+func (f *fakeImportTarget) UpdateMetadata(id string, fields map[string]interface{}) error {
+	return nil
+}
+
+func (f *fakeImportTarget) executeSSHCommand(command *remoteCommand) (string, error) {
+	remotePath := command.words[len(command.words)-1]
+	if command.words[0] != "sha256sum" || f.hashes[remotePath] == "" {
+		return "", fmt.Errorf("unexpected command: %v", command.words)
+	}
+	return f.hashes[remotePath] + "  " + remotePath + "\n", nil
+}
+
+func TestUploadBatch(t *testing.T) {
+	pdf := writePdf(t, testPdfObjects, "<< /Size 9 /Root 1 0 R >>", false)
+	info, err := os.Stat(pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := fileSHA256(pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* "Paper" is on the tablet already, with the same content */
+	const paperId = "99999999-9999-4999-8999-999999999999"
+	newTarget := func() *fakeImportTarget {
+		return &fakeImportTarget{
+			fakeStagingTarget: newFakeStagingTarget(map[string]string{}),
+			listing:           []SSHFileInfo{{ID: paperId, Name: "Paper", Size: info.Size(), Content: &SSHContent{FileType: "pdf"}}},
+			hashes:            map[string]string{xochitlPath(paperId + ".pdf"): hash},
+		}
+	}
+	/* Other files, the trailers make the sizes differ */
+	book := writePdf(t, testPdfObjects, "<< /Size 9 /Root 1 0 R /Info 7 0 R >>", false)
+	last := writePdf(t, testPdfObjects, "<< /Size 9 /Root 1 0 R /ID [<01> <01>] >>", false)
+	items := []UploadItem{
+		{LocalPath: "/books", Name: "Books", IsFolder: true},
+		{LocalPath: book, Name: "Book.pdf", ParentPath: "/books"},
+		{LocalPath: "/books/notes.txt", Name: "notes.txt", ParentPath: "/books"},
+		{LocalPath: pdf, Name: "Paper.pdf"},
+		{LocalPath: pdf, Name: "Lost.pdf", ParentPath: "/missing"},
+		{LocalPath: last, Name: "Last.pdf", ParentPath: "/books"},
+	}
+
+	tests := []struct {
+		policy    DuplicatePolicy
+		paper     UploadResult // the result of "Paper.pdf", without the ID of a new document
+		documents int          // created on the tablet, folders included
+	}{
+		{DuplicateSkip, UploadResult{Id: paperId, Name: "Paper", Action: UploadSkipped, DuplicateOf: paperId, SameContent: true}, 3},
+		{DuplicateCopy, UploadResult{Name: "Paper (1)", Action: UploadCreated, DuplicateOf: paperId, SameContent: true}, 4},
+	}
+	for _, test := range tests {
+		target := newTarget()
+		importer := NewSSHImporter(target)
+
+		started := []string{}
+		results := map[string]UploadResult{}
+		failed := map[string]error{}
+		err := importer.UploadBatch(context.Background(), items, "", test.policy,
+			func(item UploadItem) { started = append(started, item.Name) },
+			func(item UploadItem, result UploadResult) { results[item.Name] = result },
+			func(item UploadItem, err error) { failed[item.Name] = err })
+		if err != nil {
+			t.Fatalf("%s: %v", test.policy, err)
+		}
+
+		/* A failed item doesn't stop the batch, every item gets its own result */
+		if len(started) != len(items) || len(results)+len(failed) != len(items) {
+			t.Errorf("%s: started %v, finished %v, failed %v", test.policy, started, results, failed)
+		}
+		if failed["notes.txt"] == nil || failed["Lost.pdf"] == nil || len(failed) != 2 {
+			t.Errorf("%s: unexpected failures: %v", test.policy, failed)
+		}
+
+		books := results["Books"]
+		for name, localPath := range map[string]string{"Book.pdf": book, "Last.pdf": last} {
+			result := results[name]
+			if result.Action != UploadCreated || target.files[xochitlPath(result.Id+".pdf")] != "uploaded "+localPath {
+				t.Errorf("%s: %s wasn't uploaded: %+v", test.policy, name, result)
+			}
+			var metadata SSHMetadata
+			if err := json.Unmarshal([]byte(target.files[xochitlPath(result.Id+".metadata")]), &metadata); err != nil || metadata.Parent != books.Id {
+				t.Errorf("%s: %s isn't in the new folder: %+v, %v", test.policy, name, metadata, err)
+			}
+		}
+
+		paper := results["Paper.pdf"]
+		if test.paper.Action == UploadCreated {
+			test.paper.Id = paper.Id
+		}
+		if diff := cmp.Diff(test.paper, paper); diff != "" {
+			t.Errorf("%s: duplicate result mismatch (-want +got):\n%s", test.policy, diff)
+		}
+
+		metadataFiles := 0
+		for _, name := range target.paths() {
+			if strings.HasSuffix(name, ".metadata") {
+				metadataFiles++
+			}
+		}
+		if metadataFiles != test.documents {
+			t.Errorf("%s: expected %d new items, got %d: %v", test.policy, test.documents, metadataFiles, target.paths())
+		}
+	}
+}
+
+func TestUploadBatchCancelled(t *testing.T) {
+	target := &fakeImportTarget{fakeStagingTarget: newFakeStagingTarget(map[string]string{})}
+	items := []UploadItem{{LocalPath: "/books", Name: "Books", IsFolder: true}, {LocalPath: "/a.pdf", Name: "a.pdf", ParentPath: "/books"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	failed := 0
+	err := NewSSHImporter(target).UploadBatch(ctx, items, "", DuplicateSkip, func(UploadItem) {},
+		func(item UploadItem, result UploadResult) { t.Errorf("%s finished after the cancellation", item.Name) },
+		func(item UploadItem, err error) {
+			if errors.Is(err, errUploadCancelled) {
+				failed++
+			}
+		})
+	if !errors.Is(err, errUploadCancelled) || failed != len(items) {
+		t.Errorf("unexpected cancellation: %v, %d cancelled", err, failed)
+	}
+	if len(target.files) != 0 {
+		t.Errorf("a cancelled batch left files: %v", target.paths())
+	}
+}
@@ -0,0 +1,333 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+/* What to do with a file that is already on the tablet. */
+type DuplicatePolicy string
+
+const (
+	DuplicateSkip    DuplicatePolicy = "skip"    // keep the existing document
+	DuplicateReplace DuplicatePolicy = "replace" // replace the existing document's file, keeping its ID
+	DuplicateCopy    DuplicatePolicy = "copy"    // import anyway, with a " (1)" suffix if the name is taken
+)
+
+func validateDuplicatePolicy(policy DuplicatePolicy) error {
+	switch policy {
+	case DuplicateSkip, DuplicateReplace, DuplicateCopy:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicate policy: %q", policy)
+}
+
+/* What happened to an uploaded file or folder */
+const (
+	UploadCreated  = "created"
+	UploadSkipped  = "skipped"  // a duplicate, Id is the existing item
+	UploadReplaced = "replaced" // a duplicate, Id is the existing document with the new file
+)
+
+type UploadResult struct {
+	Id          string
+	Name        string // visible name on the tablet
+	Action      string
+	DuplicateOf string // ID of the document on the tablet with the same content or name, if any
+	SameContent bool   // the duplicate is the same file, not just the same name
+}
+
+/*
+Documents and folders on the tablet, to find duplicates of the imported files.
+Uploads are added as they go, so that a batch doesn't import the same file twice either.
+*/
+type tabletIndex struct {
+	items  []indexedItem
+	hashes map[string]string // SHA-256 of the document files by ID, computed when needed
+}
+
+type indexedItem struct {
+	id       string
+	parent   string
+	name     string
+	isFolder bool
+	fileType string // "pdf" or "epub", "" for folders and notebooks
+	size     int64  // of the document file
+}
+
+/* Indexes the listed items, except for the deleted ones and the ones in the trash, which the user doesn't see. */
+func newTabletIndex(files []SSHFileInfo) *tabletIndex {
+	parents := map[string]string{}
+	for _, f := range files {
+		parents[f.ID] = f.Parent
+	}
+	inTrash := func(id string) bool {
+		/* Bounded, a broken tablet may have a cycle of parents */
+		for range len(parents) + 1 {
+			parent, ok := parents[id]
+			if !ok || parent == "" {
+				return false
+			}
+			if parent == trashId {
+				return true
+			}
+			id = parent
+		}
+		return false
+	}
+
+	index := &tabletIndex{hashes: map[string]string{}}
+	for _, f := range files {
+		if f.Metadata.Deleted || inTrash(f.ID) {
+			continue
+		}
+
+		item := indexedItem{id: f.ID, parent: f.Parent, name: f.Name, isFolder: f.IsFolder, size: f.Size}
+		if f.Content != nil {
+			item.fileType = f.Content.FileType
+		}
+		index.items = append(index.items, item)
+	}
+	return index
+}
+
+func (i *tabletIndex) add(item indexedItem, hash string) {
+	i.items = append(i.items, item)
+	if hash != "" {
+		i.hashes[item.id] = hash
+	}
+}
+
+/* Updates a document whose file was replaced, the hash is "" if it wasn't computed. */
+func (i *tabletIndex) replace(id string, size int64, hash string) {
+	for n := range i.items {
+		if i.items[n].id == id {
+			i.items[n].size = size
+		}
+	}
+
+	delete(i.hashes, id)
+	if hash != "" {
+		i.hashes[id] = hash
+	}
+}
+
+/* Returns the folder with the name in the parent, if there is one. */
+func (i *tabletIndex) findFolder(name, parent string) (indexedItem, bool) {
+	for _, item := range i.items {
+		if item.isFolder && item.parent == parent && item.name == name {
+			return item, true
+		}
+	}
+	return indexedItem{}, false
+}
+
+/*
+Finds an existing document for the file to import. A document of the same type with the same name
+in the parent comes first, so that "replace" replaces what the user sees; then the same content anywhere else.
+The hashes are only computed for the documents of the same type and size.
+*/
+func (i *tabletIndex) findDuplicate(file indexedItem, localHash func() (string, error), remoteHash func(item indexedItem) (string, error)) (*UploadResult, error) {
+	sameContent := func(item indexedItem) (bool, error) {
+		if item.isFolder || item.fileType != file.fileType || item.size != file.size {
+			return false, nil
+		}
+
+		hash, ok := i.hashes[item.id]
+		if !ok {
+			var err error
+			hash, err = remoteHash(item)
+			if err != nil {
+				return false, fmt.Errorf("failed to hash %s on the tablet: %v", item.name, err)
+			}
+			i.hashes[item.id] = hash
+		}
+
+		local, err := localHash()
+		if err != nil {
+			return false, err
+		}
+		return hash == local, nil
+	}
+
+	var byContent *indexedItem
+	for _, item := range i.items {
+		if !item.isFolder && item.fileType == file.fileType && item.parent == file.parent && item.name == file.name {
+			same, err := sameContent(item)
+			if err != nil {
+				return nil, err
+			}
+			return &UploadResult{Id: item.id, Name: item.name, DuplicateOf: item.id, SameContent: same}, nil
+		}
+
+		if byContent == nil {
+			same, err := sameContent(item)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				byContent = &item
+			}
+		}
+	}
+
+	if byContent != nil {
+		return &UploadResult{Id: byContent.id, Name: byContent.name, DuplicateOf: byContent.id, SameContent: true}, nil
+	}
+	return nil, nil
+}
+
+/* Returns the name, or the name with the first free " (n)" suffix in the parent. */
+func (i *tabletIndex) uniqueName(name, parent string) string {
+	taken := map[string]bool{}
+	for _, item := range i.items {
+		if item.parent == parent {
+			taken[item.name] = true
+		}
+	}
+
+	unique := name
+	for n := 1; taken[unique]; n++ {
+		unique = fmt.Sprintf("%s (%d)", name, n)
+	}
+	return unique
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+/* Hashes the document file of an item on the tablet. */
+func (s *SSHImporter) remoteSHA256(item indexedItem) (string, error) {
+	output, err := s.connection.executeSSHCommand(newRemoteCommand("sha256sum").path(xochitlPath(item.id + "." + item.fileType)))
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("unexpected sha256sum output: %q", output)
+	}
+	return fields[0], nil
+}
+
+/* Lists the tablet's documents for duplicate detection. */
+func (s *SSHImporter) loadIndex() (*tabletIndex, error) {
+	files, err := s.connection.ListXochitlFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the documents on the tablet: %v", err)
+	}
+	return newTabletIndex(files), nil
+}
+
+/*
+Replaces the file of an existing document and its .content, both together or neither.
+Annotations and page tags belong to the page UUIDs, the pages of the file that exist in both versions
+keep theirs; the thumbnails are removed so that the tablet renders them again.
+*/
+func (s *SSHImporter) replaceDocument(ctx context.Context, id, localPath, fileType string, content SSHContent) error {
+	data, err := readOptional(s.connection, xochitlPath(id+".content"))
+	if err != nil {
+		return fmt.Errorf("failed to read the content of %s: %w", id, err)
+	}
+	if data != nil {
+		var old SSHContent
+		if err := json.Unmarshal(data, &old); err != nil {
+			return fmt.Errorf("failed to parse the content of %s: %v", id, err)
+		}
+		pagedata, err := readOptional(s.connection, xochitlPath(id+".pagedata"))
+		if err != nil {
+			return err
+		}
+		pages, err := old.pageList(string(pagedata))
+		if err != nil {
+			return fmt.Errorf("failed to read the pages of %s: %v", id, err)
+		}
+		keepPageIds(&content, pages)
+		content.LastOpenedPage = min(old.LastOpenedPage, max(content.PageCount-1, 0))
+
+		/* The tags of the pages that are gone go with them */
+		content.Tags = old.Tags
+		for _, tag := range old.PageTags {
+			if slices.Contains(content.Pages, tag.PageId) {
+				content.PageTags = append(content.PageTags, tag)
+			}
+		}
+	}
+
+	contentJSON, err := marshalContent(content)
+	if err != nil {
+		return err
+	}
+
+	stage, err := newRemoteStage(s.connection)
+	if err != nil {
+		return err
+	}
+	defer stage.abort()
+
+	documentName := id + "." + fileType
+	err = stage.upload(localPath, documentName)
+	if err != nil {
+		return err
+	}
+	stage.replaces(documentName)
+
+	err = stage.write(id+".content", contentJSON)
+	if err != nil {
+		return err
+	}
+	stage.replaces(id + ".content")
+
+	err = stage.commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.connection.RemoveAll(xochitlPath(id + ".thumbnails"))
+	if err != nil {
+		return err
+	}
+
+	/* Only marks the document as modified */
+	return s.connection.UpdateMetadata(id, map[string]interface{}{})
+}
+
+/*
+Gives the pages of the new content the IDs of the old pages that showed the same page of the file.
+Pages added on the tablet have no page of the file, they aren't carried over.
+*/
+func keepPageIds(content *SSHContent, old []rmContentPage) {
+	ids := map[int]string{}
+	for _, page := range old {
+		if page.Redirect >= 0 {
+			ids[page.Redirect] = page.Id
+		}
+	}
+	for i := range content.Pages {
+		redirect := i
+		if i < len(content.RedirectionPageMap) {
+			redirect = content.RedirectionPageMap[i]
+		}
+		if id, ok := ids[redirect]; ok {
+			content.Pages[i] = id
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testTabletIndex() *tabletIndex {
+	content := func(fileType string) *SSHContent {
+		return &SSHContent{FileType: fileType}
+	}
+
+	return newTabletIndex([]SSHFileInfo{
+		{ID: "folder", Name: "Papers", IsFolder: true},
+		{ID: "paper", Name: "Paper", Parent: "folder", Size: 100, Content: content("pdf")},
+		{ID: "paper-epub", Name: "Paper", Parent: "folder", Size: 100, Content: content("epub")},
+		{ID: "copy", Name: "Other name", Parent: "", Size: 100, Content: content("pdf")},
+		{ID: "numbered", Name: "Paper (1)", Parent: "folder", Size: 5, Content: content("pdf")},
+		{ID: "deleted", Name: "Paper (2)", Parent: "folder", Size: 100, Content: content("pdf"), Metadata: SSHMetadata{Deleted: true}},
+		{ID: "trashed", Name: "Trashed", Parent: trashId, Size: 42, Content: content("pdf")},
+		{ID: "trashed-folder", Name: "Old papers", Parent: trashId, IsFolder: true},
+		{ID: "trashed-child", Name: "Paper", Parent: "trashed-folder", Size: 42, Content: content("pdf")},
+	})
+}
+
+func TestFindDuplicate(t *testing.T) {
+	hashed := []string{}
+	remoteHash := func(item indexedItem) (string, error) {
+		hashed = append(hashed, item.id)
+		if item.id == "copy" || item.id == "trashed" || item.id == "trashed-child" {
+			return "local", nil
+		}
+		return "remote", nil
+	}
+	localHash := func() (string, error) {
+		return "local", nil
+	}
+
+	tests := []struct {
+		name   string
+		file   indexedItem
+		want   *UploadResult
+		hashed []string
+	}{
+		{
+			name:   "same name, other content",
+			file:   indexedItem{parent: "folder", name: "Paper", fileType: "pdf", size: 100},
+			want:   &UploadResult{Id: "paper", Name: "Paper", DuplicateOf: "paper"},
+			hashed: []string{"paper"},
+		},
+		{
+			name:   "same name, other size",
+			file:   indexedItem{parent: "folder", name: "Paper", fileType: "pdf", size: 7},
+			want:   &UploadResult{Id: "paper", Name: "Paper", DuplicateOf: "paper"},
+			hashed: []string{},
+		},
+		{
+			name:   "same content elsewhere",
+			file:   indexedItem{parent: "", name: "New", fileType: "pdf", size: 100},
+			want:   &UploadResult{Id: "copy", Name: "Other name", DuplicateOf: "copy", SameContent: true},
+			hashed: []string{"paper", "copy"},
+		},
+		{
+			name:   "same content in the trash",
+			file:   indexedItem{parent: "", name: "Trashed", fileType: "pdf", size: 42},
+			want:   nil,
+			hashed: []string{},
+		},
+		{
+			name:   "new file",
+			file:   indexedItem{parent: "", name: "New", fileType: "epub", size: 3},
+			want:   nil,
+			hashed: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		index := testTabletIndex()
+		hashed = []string{}
+
+		got, err := index.findDuplicate(test.file, localHash, remoteHash)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s: result mismatch (-want +got):\n%s", test.name, diff)
+		}
+		if diff := cmp.Diff(test.hashed, hashed); diff != "" {
+			t.Errorf("%s: hashed documents mismatch (-want +got):\n%s", test.name, diff)
+		}
+	}
+}
+
+func TestTabletIndexNames(t *testing.T) {
+	index := testTabletIndex()
+
+	/* The deleted "Paper (2)" doesn't take the name */
+	if got := index.uniqueName("Paper", "folder"); got != "Paper (2)" {
+		t.Errorf("unexpected unique name: %s", got)
+	}
+	if got := index.uniqueName("Paper", ""); got != "Paper" {
+		t.Errorf("unexpected unique name: %s", got)
+	}
+
+	if folder, ok := index.findFolder("Papers", ""); !ok || folder.id != "folder" {
+		t.Errorf("folder not found: %+v", folder)
+	}
+	if _, ok := index.findFolder("Old papers", trashId); ok {
+		t.Errorf("a folder in the trash was found")
+	}
+	if _, ok := index.findFolder("Paper", "folder"); ok {
+		t.Errorf("a document was found as a folder")
+	}
+
+	index.add(indexedItem{id: "new", parent: "folder", name: "Paper (2)", fileType: "pdf", size: 1}, "hash")
+	if got := index.uniqueName("Paper", "folder"); got != "Paper (3)" {
+		t.Errorf("an added document doesn't take the name: %s", got)
+	}
+}
+
+func TestFileSHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("unexpected hash: %s", got)
+	}
+	if validateDuplicatePolicy("merge") == nil {
+		t.Errorf("unknown policy is valid")
+	}
+}
+
+func TestReplaceDocument(t *testing.T) {
+	const paperId = "99999999-9999-4999-8999-999999999999"
+	const first, added, second, deleted = "11111111-1111-4111-8111-111111111111", "22222222-2222-4222-8222-222222222222",
+		"33333333-3333-4333-8333-333333333333", "44444444-4444-4444-8444-444444444444"
+
+	/* Format version 2: a page added between the two pages of the PDF, its third page deleted */
+	oldContent := `{
+		"fileType": "pdf",
+		"formatVersion": 2,
+		"lastOpenedPage": 5,
+		"pages": [],
+		"cPages": {"pages": [
+			{"id": "` + second + `", "idx": {"value": "bc"}, "redir": {"value": 1}},
+			{"id": "` + first + `", "idx": {"value": "ba"}, "redir": {"value": 0}},
+			{"id": "` + added + `", "idx": {"value": "bb"}, "template": {"value": "Lined"}},
+			{"id": "` + deleted + `", "idx": {"value": "bd"}, "redir": {"value": 2}, "deleted": {"value": 1}}
+		]},
+		"tags": [{"name": "Reading"}],
+		"pageTags": [{"name": "Intro", "pageId": "` + first + `"}, {"name": "Notes", "pageId": "` + added + `"}]
+	}`
+	newTarget := func() *fakeImportTarget {
+		return &fakeImportTarget{fakeStagingTarget: newFakeStagingTarget(map[string]string{
+			xochitlPath(paperId + ".pdf"):     "old",
+			xochitlPath(paperId + ".content"): oldContent,
+		})}
+	}
+	pdf := writePdf(t, testPdfObjects, "<< /Size 9 /Root 1 0 R >>", false)
+	sizes := []PdfPageSize{{Width: 595, Height: 842}, {Width: 595, Height: 842}, {Width: 595, Height: 842}}
+
+	target := newTarget()
+	content := newPdfContent(&PdfInfo{PageSizes: sizes}, 0)
+	third := content.Pages[2]
+	if err := NewSSHImporter(target).replaceDocument(context.Background(), paperId, pdf, "pdf", content); err != nil {
+		t.Fatal(err)
+	}
+	if target.files[xochitlPath(paperId+".pdf")] != "uploaded "+pdf {
+		t.Errorf("the file wasn't replaced: %v", target.paths())
+	}
+
+	/* The pages of the PDF keep their annotations and tags, the new third page gets a new ID */
+	var got SSHContent
+	if err := json.Unmarshal([]byte(target.files[xochitlPath(paperId+".content")]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{first, second, third}, got.Pages); diff != "" {
+		t.Errorf("page IDs mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]SSHPageTag{{Name: "Intro", PageId: first}}, got.PageTags); diff != "" {
+		t.Errorf("page tags mismatch (-want +got):\n%s", diff)
+	}
+	if len(got.Tags) != 1 || got.LastOpenedPage != 2 {
+		t.Errorf("unexpected tags or last opened page: %v, %d", got.Tags, got.LastOpenedPage)
+	}
+
+	/* The old content can't be read, the document stays as it was */
+	target = newTarget()
+	target.readErr = errors.New("permission denied")
+	err := NewSSHImporter(target).replaceDocument(context.Background(), paperId, pdf, "pdf", newPdfContent(&PdfInfo{PageSizes: sizes}, 0))
+	if err == nil || target.files[xochitlPath(paperId+".content")] != oldContent || target.files[xochitlPath(paperId+".pdf")] != "old" {
+		t.Errorf("replaced without the old content: %v", err)
+	}
+}
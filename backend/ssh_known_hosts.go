@@ -0,0 +1,219 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+/*
+Returned by SSHConnection.Connect when the tablet presents a host key that differs
+from the one stored in known_hosts.
+
+This is expected after a factory reset or a software update that regenerates the keys,
+but can also mean someone is intercepting the connection, so the user has to confirm
+with KnownHosts.Forget before connecting again.
+*/
+type HostKeyChangedError struct {
+	Host              string
+	Fingerprint       string   // SHA256 fingerprint of the key the tablet presented
+	KnownFingerprints []string // fingerprints stored in known_hosts for the host
+	KnownHostsPath    string
+}
+
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf("host key for %s has changed: the tablet presented %s, but %s has %s",
+		e.Host, e.Fingerprint, e.KnownHostsPath, strings.Join(e.KnownFingerprints, ", "))
+}
+
+/*
+known_hosts file of the app with trust-on-first-use:
+the key of a host that isn't in the file is accepted and stored,
+the key of a known host has to match the stored one.
+*/
+type KnownHosts struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewKnownHosts(path string) *KnownHosts {
+	return &KnownHosts{path: path}
+}
+
+/* Returns the path of the known_hosts file in the user's config directory. */
+func DefaultKnownHostsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rm-importer", "known_hosts"), nil
+}
+
+func (k *KnownHosts) Path() string {
+	return k.path
+}
+
+/* Creates the file with its directory if it doesn't exist yet. */
+func (k *KnownHosts) ensureFile() error {
+	err := os.MkdirAll(filepath.Dir(k.path), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create known_hosts directory: %v", err)
+	}
+
+	f, err := os.OpenFile(k.path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create known_hosts file: %v", err)
+	}
+	return f.Close()
+}
+
+/*
+Returns the host key callback for ssh.ClientConfig.
+The onNewHost function is called when a key of an unknown host is stored.
+*/
+func (k *KnownHosts) hostKeyCallback(onNewHost func(host, fingerprint string)) (ssh.HostKeyCallback, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	err := k.ensureFile()
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := knownhosts.New(k.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", k.path, err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		if len(keyErr.Want) > 0 {
+			known := []string{}
+			for _, w := range keyErr.Want {
+				known = append(known, ssh.FingerprintSHA256(w.Key))
+			}
+			return &HostKeyChangedError{
+				Host:              hostname,
+				Fingerprint:       ssh.FingerprintSHA256(key),
+				KnownFingerprints: known,
+				KnownHostsPath:    k.path,
+			}
+		}
+
+		/* The host isn't known yet: trust it on first use */
+		err = k.add(hostname, key)
+		if err != nil {
+			return err
+		}
+		if onNewHost != nil {
+			onNewHost(hostname, ssh.FingerprintSHA256(key))
+		}
+		return nil
+	}, nil
+}
+
+func (k *KnownHosts) add(hostname string, key ssh.PublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	f, err := os.OpenFile(k.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts: %v", err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	_, err = f.WriteString(line + "\n")
+	if err != nil {
+		return fmt.Errorf("failed to write known_hosts: %v", err)
+	}
+	return nil
+}
+
+/* Returns true if the hosts field of a known_hosts line lists the address. */
+func hostsFieldMatches(hosts string, address string) bool {
+	return slices.Contains(strings.Split(hosts, ","), address)
+}
+
+/*
+Returns the key algorithms stored for the host, so that the server is asked for
+a key of the same type and a host with several keys isn't reported as changed.
+*/
+func (k *KnownHosts) hostKeyAlgorithms(host string) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return nil
+	}
+
+	/* nil means the default algorithms for an unknown host */
+	var algorithms []string
+	address := knownhosts.Normalize(host)
+	for len(data) > 0 {
+		_, hosts, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if err != nil {
+			break
+		}
+		data = rest
+
+		if slices.Contains(hosts, address) {
+			algorithms = append(algorithms, hostKeyAlgorithmsFor(key.Type())...)
+		}
+	}
+	return algorithms
+}
+
+/* RSA keys can be used with several signature algorithms. */
+func hostKeyAlgorithmsFor(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+/* Removes the stored keys of the host, so that the next connection trusts the new key. */
+func (k *KnownHosts) Forget(host string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	data, err := os.ReadFile(k.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read known_hosts: %v", err)
+	}
+
+	address := knownhosts.Normalize(host)
+	kept := [][]byte{}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) > 0 && hostsFieldMatches(fields[0], address) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	err = os.WriteFile(k.path, bytes.Join(kept, []byte("\n")), 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write known_hosts: %v", err)
+	}
+	return nil
+}
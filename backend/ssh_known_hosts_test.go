@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func checkHostKey(t *testing.T, hosts *KnownHosts, addr string, key ssh.PublicKey) (bool, error) {
+	trusted := false
+	callback, err := hosts.hostKeyCallback(func(host, fingerprint string) {
+		trusted = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("10.11.99.1"), Port: 22}
+	err = callback(addr, remote, key)
+	return trusted, err
+}
+
+func TestKnownHostsTrustOnFirstUse(t *testing.T) {
+	hosts := NewKnownHosts(filepath.Join(t.TempDir(), "config", "known_hosts"))
+	key := newHostKey(t)
+
+	trusted, err := checkHostKey(t, hosts, "10.11.99.1:22", key)
+	if err != nil || !trusted {
+		t.Fatalf("first connection should be trusted, err=%v, trusted=%v", err, trusted)
+	}
+
+	trusted, err = checkHostKey(t, hosts, "10.11.99.1:22", key)
+	if err != nil || trusted {
+		t.Fatalf("known key should be accepted without storing it again, err=%v, trusted=%v", err, trusted)
+	}
+
+	algorithms := hosts.hostKeyAlgorithms("10.11.99.1:22")
+	if len(algorithms) != 1 || algorithms[0] != ssh.KeyAlgoED25519 {
+		t.Fatalf("hostKeyAlgorithms=%v, expected [%v]", algorithms, ssh.KeyAlgoED25519)
+	}
+
+	if algorithms := hosts.hostKeyAlgorithms("192.168.1.2:22"); algorithms != nil {
+		t.Fatalf("hostKeyAlgorithms of an unknown host=%v, expected nil", algorithms)
+	}
+}
+
+func TestKnownHostsChangedKey(t *testing.T) {
+	hosts := NewKnownHosts(filepath.Join(t.TempDir(), "known_hosts"))
+	oldKey, newKey := newHostKey(t), newHostKey(t)
+
+	checkHostKey(t, hosts, "10.11.99.1:22", oldKey)
+	checkHostKey(t, hosts, "192.168.1.2:22", oldKey)
+
+	_, err := checkHostKey(t, hosts, "10.11.99.1:22", newKey)
+	var changed *HostKeyChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("expected HostKeyChangedError, got %v", err)
+	}
+	if changed.Fingerprint != ssh.FingerprintSHA256(newKey) {
+		t.Fatalf("Fingerprint=%v, expected %v", changed.Fingerprint, ssh.FingerprintSHA256(newKey))
+	}
+	if len(changed.KnownFingerprints) != 1 || changed.KnownFingerprints[0] != ssh.FingerprintSHA256(oldKey) {
+		t.Fatalf("KnownFingerprints=%v, expected [%v]", changed.KnownFingerprints, ssh.FingerprintSHA256(oldKey))
+	}
+
+	err = hosts.Forget("10.11.99.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trusted, err := checkHostKey(t, hosts, "10.11.99.1:22", newKey)
+	if err != nil || !trusted {
+		t.Fatalf("new key should be trusted after Forget, err=%v, trusted=%v", err, trusted)
+	}
+
+	/* Other hosts are kept */
+	_, err = checkHostKey(t, hosts, "192.168.1.2:22", newKey)
+	if !errors.As(err, &changed) {
+		t.Fatalf("expected HostKeyChangedError for the other host, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package backend
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Returns the command that lists xochitl's directory in one round trip.
+
+The output has two parts separated by an empty line:
+  - `stat` line for every file in the directory: "./<name> <size> <mtime>";
+  - tar archive with all the .metadata and .content files.
+*/
+func xochitlListingCommand() *remoteCommand {
+	stat := newRemoteCommand("find", ".", "-maxdepth", "1", "-type", "f",
+		"-exec", "stat", "-c", "%n %s %Y", "{}", "+")
+
+	archive := newRemoteCommand("find", ".", "-maxdepth", "1", "-type", "f",
+		"(", "-name", "*.metadata", "-o", "-name", "*.content", ")").
+		pipe(newRemoteCommand("tar", "-cf", "-", "-T", "-"))
+
+	return newRemoteCommand("cd").path(xochitlDir).
+		and(stat).
+		and(newRemoteCommand("echo")).
+		and(archive)
+}
+
+type listedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+/* Reads the stat lines up to the empty separator line. */
+func parseListingStat(r *bufio.Reader) (map[string]listedFile, error) {
+	files := map[string]listedFile{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("listing ended before the archive: %v", err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return files, nil
+		}
+
+		/* The name comes first, it may contain spaces */
+		name, times, ok1 := cutLast(line)
+		name, sizeField, ok2 := cutLast(name)
+		if !ok1 || !ok2 || name == "" {
+			return nil, fmt.Errorf("unexpected stat line: %q", line)
+		}
+
+		size, err := strconv.ParseInt(sizeField, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected stat line: %q", line)
+		}
+		mtime, err := strconv.ParseInt(times, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected stat line: %q", line)
+		}
+
+		files[path.Base(name)] = listedFile{size, time.Unix(mtime, 0)}
+	}
+}
+
+/* Splits a line at its last space. */
+func cutLast(line string) (before, after string, found bool) {
+	i := strings.LastIndexByte(line, ' ')
+	if i < 0 {
+		return line, "", false
+	}
+	return line[:i], line[i+1:], true
+}
+
+/* Reads the .metadata and .content files from the archive, by file name. */
+func parseListingArchive(r io.Reader) (map[string][]byte, error) {
+	result := map[string][]byte{}
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the archive: %v", err)
+		}
+
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from the archive: %v", header.Name, err)
+		}
+		result[path.Base(header.Name)] = data
+	}
+}
+
+/* The size of a document is the size of its source file, if it has one. */
+var documentFileExtensions = []string{"pdf", "epub"}
+
+/*
+Parses the output of xochitlListingCommand.
+Items with a missing or invalid .metadata file are skipped.
+archived tells whether the directory has any files for the archive: without them,
+tar may fail on the empty archive.
+*/
+func parseXochitlListing(r io.Reader) (result []SSHFileInfo, archived bool, err error) {
+	br := bufio.NewReader(r)
+
+	stats, err := parseListingStat(br)
+	if err != nil {
+		return nil, false, err
+	}
+	for name := range stats {
+		if strings.HasSuffix(name, ".metadata") || strings.HasSuffix(name, ".content") {
+			archived = true
+			break
+		}
+	}
+
+	/* An empty directory may produce no archive at all */
+	files := map[string][]byte{}
+	if _, err := br.Peek(1); err == nil {
+		files, err = parseListingArchive(br)
+		if err != nil {
+			return nil, archived, err
+		}
+	} else if archived {
+		return nil, archived, errors.New("the listing has no archive")
+	}
+
+	result = []SSHFileInfo{}
+	for name, data := range files {
+		id, isMetadata := strings.CutSuffix(name, ".metadata")
+		if !isMetadata || validateDocId(id) != nil {
+			continue
+		}
+
+		var metadata SSHMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			continue
+		}
+
+		info := SSHFileInfo{
+			ID:       id,
+			Name:     metadata.VisibleName,
+			IsFolder: metadata.Type == "CollectionType",
+			Parent:   metadata.Parent,
+			Type:     metadata.Type,
+			Path:     xochitlPath(name),
+			ModTime:  stats[name].modTime,
+			Metadata: metadata,
+		}
+
+		if data, ok := files[id+".content"]; ok {
+			var content SSHContent
+			if err := json.Unmarshal(data, &content); err == nil {
+				info.Content = &content
+			}
+		}
+
+		if !info.IsFolder {
+			for _, ext := range documentFileExtensions {
+				if f, ok := stats[id+"."+ext]; ok {
+					info.Size = f.size
+					break
+				}
+			}
+		}
+
+		result = append(result, info)
+	}
+
+	slices.SortFunc(result, func(a, b SSHFileInfo) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return result, archived, nil
+}
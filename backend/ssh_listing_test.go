@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	listingFolderId = "11111111-1111-4111-8111-111111111111"
+	listingDocId    = "22222222-2222-4222-8222-222222222222"
+)
+
+func writeListingTar(t *testing.T, buf *bytes.Buffer, files map[string]string) {
+	w := tar.NewWriter(buf)
+	for name, data := range files {
+		err := w.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(data))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	w.Close()
+}
+
+func TestParseXochitlListing(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("./" + listingFolderId + ".metadata 120 1700000000\n")
+	buf.WriteString("./" + listingDocId + ".metadata 130 1700000100\n")
+	buf.WriteString("./" + listingDocId + ".content 20 1700000100\n")
+	buf.WriteString("./" + listingDocId + ".pdf 4096 1700000100\n")
+	buf.WriteString("./not-an-id.metadata 10 1700000100\n")
+	buf.WriteString("./Copy of notes.txt 7 1700000200\n")
+	buf.WriteString("\n")
+	writeListingTar(t, &buf, map[string]string{
+		listingFolderId + ".metadata": `{"type": "CollectionType", "visibleName": "Books", "parent": ""}`,
+		listingDocId + ".metadata":    `{"type": "DocumentType", "visibleName": "Paper; v2", "parent": "` + listingFolderId + `"}`,
+		listingDocId + ".content":     `{"fileType": "pdf", "pageCount": 12}`,
+		"not-an-id.metadata":          `{"type": "DocumentType", "visibleName": "x"}`,
+	})
+
+	files, archived, err := parseXochitlListing(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !archived || len(files) != 2 {
+		t.Fatalf("expected 2 files, got %v", files)
+	}
+
+	folder, doc := files[0], files[1]
+	if folder.ID != listingFolderId || !folder.IsFolder || folder.Name != "Books" || folder.Content != nil {
+		t.Fatalf("unexpected folder: %+v", folder)
+	}
+	if doc.ID != listingDocId || doc.IsFolder || doc.Name != "Paper; v2" || doc.Parent != listingFolderId {
+		t.Fatalf("unexpected document: %+v", doc)
+	}
+	if doc.Size != 4096 || !doc.ModTime.Equal(time.Unix(1700000100, 0)) {
+		t.Fatalf("unexpected document size or time: %+v", doc)
+	}
+	if doc.Content == nil || doc.Content.FileType != "pdf" || doc.Content.PageCount != 12 {
+		t.Fatalf("unexpected document content: %+v", doc.Content)
+	}
+}
+
+func TestParseXochitlListingEmpty(t *testing.T) {
+	/* Without documents, the directory may still have other files */
+	files, archived, err := parseXochitlListing(bytes.NewBufferString("./notes.txt 7 1700000200\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived || len(files) != 0 {
+		t.Fatalf("expected no files, got %v", files)
+	}
+
+	/* tar failed on a directory with documents */
+	_, _, err = parseXochitlListing(bytes.NewBufferString("./" + listingDocId + ".metadata 130 1700000100\n\n"))
+	if err == nil {
+		t.Errorf("a listing without the archive is accepted")
+	}
+
+	for _, line := range []string{"./notes.txt 7", "7 1700000200", "./notes.txt seven 1700000200"} {
+		if _, _, err := parseXochitlListing(bytes.NewBufferString(line + "\n\n")); err == nil {
+			t.Errorf("invalid stat line %q is accepted", line)
+		}
+	}
+}
+
+/* Runs the listing command with the local shell tools on a fake home directory. */
+func TestXochitlListingCommand(t *testing.T) {
+	for _, tool := range []string{"sh", "find", "stat", "tar"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%v is not available", tool)
+		}
+	}
+
+	home := t.TempDir()
+	dir := filepath.Join(home, filepath.FromSlash(xochitlDir))
+	os.MkdirAll(filepath.Join(dir, listingDocId), 0755)
+	os.WriteFile(filepath.Join(dir, listingDocId+".metadata"), []byte(`{"type": "DocumentType", "visibleName": "Doc"}`), 0644)
+	os.WriteFile(filepath.Join(dir, listingDocId+".content"), []byte(`{"fileType": "epub"}`), 0644)
+	os.WriteFile(filepath.Join(dir, listingDocId+".epub"), make([]byte, 1234), 0644)
+	os.WriteFile(filepath.Join(dir, "notes copy.txt"), []byte("stray"), 0644)
+
+	cmd := exec.Command("sh", "-c", xochitlListingCommand().String())
+	cmd.Dir = home
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _, err := parseXochitlListing(bytes.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "Doc" || files[0].Size != 1234 || files[0].Content.FileType != "epub" {
+		t.Fatalf("unexpected listing: %+v", files)
+	}
+}
@@ -2,28 +2,22 @@ package backend
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/google/uuid"
 )
 
+/* Reads the items from xochitl's directory over SSH. */
 type SSHReader struct {
-	connection *SSHConnection
-	children   map[DocId][]DocInfo
-	docById    map[DocId]DocInfo
-}
+	docTree
 
-func (r *SSHReader) GetDocById(id DocId) (DocInfo, bool) {
-	doc, exists := r.docById[id]
-	return doc, exists
+	connection *SSHConnection
 }
 
 func NewSSHReader(connection *SSHConnection) *SSHReader {
 	return &SSHReader{
+		docTree:    newDocTree(nil),
 		connection: connection,
-		children:   make(map[DocId][]DocInfo),
-		docById:    make(map[DocId]DocInfo),
 	}
 }
 
@@ -31,8 +25,6 @@ func (r *SSHReader) Read() error {
 	// Note: We don't have context here, so we'll use fmt.Printf for now
 	// In a real implementation, we'd pass context through the call chain
 	fmt.Printf("[SSH_READER] Starting to read documents...\n")
-	r.children = make(map[DocId][]DocInfo)
-	r.docById = make(map[DocId]DocInfo)
 
 	fmt.Printf("[SSH_READER] Listing xochitl files...\n")
 	sshFiles, err := r.connection.ListXochitlFiles()
@@ -43,132 +35,191 @@ func (r *SSHReader) Read() error {
 
 	fmt.Printf("[SSH_READER] Found %d files, converting to DocInfo...\n", len(sshFiles))
 	// Convert SSH files to DocInfo
+	docs := []DocInfo{}
 	for _, sshFile := range sshFiles {
-		docInfo := r.convertSSHFileToDocInfo(sshFile)
-		r.children[docInfo.ParentId] = append(r.children[docInfo.ParentId], docInfo)
-		r.docById[docInfo.Id] = docInfo
+		/* Deleted items are kept until the deletion is synced, the tablet doesn't show them */
+		if sshFile.Metadata.Deleted {
+			continue
+		}
+		docs = append(docs, convertSSHFileToDocInfo(sshFile))
 	}
+	r.docTree = newDocTree(docs)
+
+	/* Trashed items are listed in a virtual folder that isn't part of the root */
+	r.docById[trashId] = DocInfo{Id: trashId, IsFolder: true, Name: "Trash"}
 
 	fmt.Printf("[SSH_READER] Successfully processed %d documents\n", len(sshFiles))
 	return nil
 }
 
-func (r *SSHReader) convertSSHFileToDocInfo(sshFile SSHFileInfo) DocInfo {
-	docInfo := DocInfo{
-		Id:       sshFile.ID,
-		ParentId: sshFile.Parent,
-		IsFolder: sshFile.IsFolder,
-		Name:     sshFile.Name,
-	}
-
-	// Parse last modified time from metadata
-	if metadata, err := r.connection.ReadMetadataFile(sshFile.Path); err == nil {
-		if lastModified, err := time.Parse("2006-01-02T15:04:05.000Z", metadata.LastModified); err == nil {
-			docInfo.LastModified = &lastModified
+/* True if the item is in the trash, directly or inside a trashed folder. */
+func (r *SSHReader) isInTrash(id DocId) bool {
+	for range len(r.docById) {
+		doc, exists := r.docById[id]
+		if !exists || doc.ParentId == "" {
+			return false
+		}
+		if doc.ParentId == trashId {
+			return true
 		}
+		id = doc.ParentId
 	}
+	return false
+}
 
-	// Set file type for documents
-	if !sshFile.IsFolder {
-		fileType := "pdf" // Default to PDF, could be determined from file extension
-		docInfo.FileType = &fileType
+/* Moves a trashed item back to the root folder. The tablet doesn't remember where it was. */
+func (r *SSHReader) RestoreFromTrash(id DocId) error {
+	if !r.isInTrash(id) {
+		return fmt.Errorf("item %s is not in the trash", id)
 	}
-
-	return docInfo
+	return r.connection.UpdateMetadata(id, map[string]interface{}{"parent": ""})
 }
 
-func (r *SSHReader) GetFolder(id DocId) []DocInfo {
-	if items, ok := r.children[id]; ok {
-		return items
+/* Permanently removes a trashed item, together with everything inside it if it's a folder. */
+func (r *SSHReader) PurgeFromTrash(id DocId) error {
+	if !r.isInTrash(id) {
+		return fmt.Errorf("item %s is not in the trash", id)
 	}
-	return []DocInfo{}
+	return r.Delete(id)
 }
 
-func (r *SSHReader) GetCheckedFiles(selection *FileSelection) []DocInfo {
-	ids := selection.GetCheckedItems()
-	files := r.getElementsByIds(ids)
-	r.fillPaths(files)
-	return files
+/* Returns an error unless the item is a document or a folder of the tablet. */
+func (r *SSHReader) checkItem(id DocId) error {
+	if _, exists := r.docById[id]; !exists || id == trashId {
+		return fmt.Errorf("item %s not found", id)
+	}
+	return nil
 }
 
-func (r *SSHReader) getElementsByIds(ids []DocId) []DocInfo {
-	result := []DocInfo{}
-	for _, id := range ids {
-		if doc, exists := r.docById[id]; exists {
-			result = append(result, doc)
-		}
+/* Changes the name the tablet shows for a document or folder. */
+func (r *SSHReader) Rename(id DocId, name string) error {
+	if err := r.checkItem(id); err != nil {
+		return err
 	}
-	return result
-}
 
-func (r *SSHReader) GetChildrenMap() map[DocId][]DocInfo {
-	return r.children
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("the name can't be empty")
+	}
+	return r.connection.UpdateMetadata(id, map[string]interface{}{"visibleName": name})
 }
 
-func (r *SSHReader) fillPaths(items []DocInfo) {
-	for i, item := range items {
-		p := r.getDisplayPath(item)
-		items[i].DisplayPath = &p
-		items[i].TabletPath = r.getTabletPath(item)
+/*
+Returns an error unless the item can be moved into the parent: the root ("") or a folder outside the trash,
+which isn't the item itself or inside it.
+*/
+func (r *SSHReader) checkMove(id, parent DocId) error {
+	if err := r.checkItem(id); err != nil {
+		return err
+	}
+	if parent == "" {
+		return nil
 	}
-}
 
-func (r *SSHReader) getTabletPath(item DocInfo) []string {
-	id := item.Id
-	path := []string{}
+	folder, exists := r.docById[parent]
+	if !exists || !folder.IsFolder || parent == trashId || r.isInTrash(parent) {
+		return fmt.Errorf("folder %s not found", parent)
+	}
 
-	for id != "" {
-		if doc, exists := r.docById[id]; exists {
-			path = append(path, doc.Name)
-			id = doc.ParentId
-		} else {
+	for range len(r.docById) {
+		if parent == id {
+			return fmt.Errorf("a folder can't be moved into itself")
+		}
+		if parent = r.docById[parent].ParentId; parent == "" {
 			break
 		}
 	}
+	return nil
+}
 
-	// Reverse the path to get correct order
-	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
-		path[i], path[j] = path[j], path[i]
+/* Moves a document or folder into another folder, "" for the root. */
+func (r *SSHReader) Move(id, parent DocId) error {
+	if err := r.checkMove(id, parent); err != nil {
+		return err
 	}
+	if r.docById[id].ParentId == parent {
+		return nil
+	}
+	return r.connection.UpdateMetadata(id, map[string]interface{}{"parent": parent})
+}
 
-	return path
+/* Moves a document or folder to the trash, the tablet can restore it from there. */
+func (r *SSHReader) MoveToTrash(id DocId) error {
+	if err := r.checkItem(id); err != nil {
+		return err
+	}
+	if r.isInTrash(id) {
+		return fmt.Errorf("item %s is already in the trash", id)
+	}
+	return r.connection.UpdateMetadata(id, map[string]interface{}{"parent": trashId})
 }
 
-func (r *SSHReader) getDisplayPath(item DocInfo) string {
-	tabletPath := r.getTabletPath(item)
-	for i, x := range tabletPath {
-		if strings.Contains(x, "/") {
-			tabletPath[i] = "'" + x + "'"
+/* Permanently removes a document or folder with everything inside it, including the files xochitl keeps next to it. */
+func (r *SSHReader) Delete(id DocId) error {
+	if err := r.checkItem(id); err != nil {
+		return err
+	}
+
+	for _, item := range r.getSubtree(id) {
+		err := r.connection.RemoveDocumentFiles(item)
+		if err != nil {
+			return err
 		}
 	}
-	return strings.Join(tabletPath, "/")
+	return nil
 }
 
-// UploadFile uploads a file using the SSH importer and returns the generated UUID
-func (r *SSHReader) UploadFile(localPath, fileName, parentId string) (string, error) {
-	importer := NewSSHImporter(r.connection)
-	return importer.UploadFile(localPath, fileName, parentId)
-}
+func convertSSHFileToDocInfo(sshFile SSHFileInfo) DocInfo {
+	docInfo := DocInfo{
+		Id:         sshFile.ID,
+		ParentId:   sshFile.Parent,
+		IsFolder:   sshFile.IsFolder,
+		Name:       sshFile.Name,
+		Bookmarked: sshFile.Metadata.Pinned,
+	}
 
-func (r *SSHReader) CreateFolder(folderName, parentId string) error {
-	// Generate a new UUID for the folder
-	uuidStr := uuid.New().String()
+	if lastModified, ok := parseXochitlTime(sshFile.Metadata.LastModified); ok {
+		docInfo.LastModified = &lastModified
+	}
 
-	// Create metadata file for the folder
-	err := r.connection.CreateMetadataFile(uuidStr, folderName, parentId, true)
-	if err != nil {
-		return fmt.Errorf("failed to create folder metadata: %v", err)
+	if sshFile.IsFolder {
+		return docInfo
 	}
 
-	// Create empty content file for folder
-	err = r.connection.CreateContentFile(uuidStr, "")
-	if err != nil {
-		return fmt.Errorf("failed to create folder content: %v", err)
+	if content := sshFile.Content; content != nil {
+		/* Notebooks created by older firmware have an empty file type */
+		fileType := content.FileType
+		if fileType == "" {
+			fileType = "notebook"
+		}
+		docInfo.FileType = &fileType
+
+		if content.PageCount > 0 {
+			pageCount := content.PageCount
+			docInfo.PageCount = &pageCount
+		}
+
+		docInfo.Tags, docInfo.PageTags = contentTags(content)
 	}
 
-	return nil
+	if sshFile.Size > 0 {
+		size := sshFile.Size
+		docInfo.Size = &size
+	}
+
+	return docInfo
 }
 
-func (r *SSHReader) RestartXochitl() error {
-	return r.connection.RestartXochitl()
+/*
+Parses a timestamp of a .metadata file.
+xochitl writes Unix time in milliseconds as a string, older files may have an ISO date.
+*/
+func parseXochitlTime(s string) (time.Time, bool) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), true
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
 }
@@ -0,0 +1,152 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConvertSSHFileToDocInfo(t *testing.T) {
+	const notebookId = "33333333-3333-4333-8333-333333333333"
+
+	var buf bytes.Buffer
+	buf.WriteString("./" + listingDocId + ".pdf 4096 1700000100\n")
+	buf.WriteString("\n")
+	writeListingTar(t, &buf, map[string]string{
+		listingDocId + ".metadata": `{"type": "DocumentType", "visibleName": "Paper", "lastModified": "1700000000123", "pinned": true}`,
+		listingDocId + ".content":  `{"fileType": "pdf", "pageCount": 12, "textScale": 1.2, "transform": {"m11": 0.5}}`,
+		notebookId + ".metadata":   `{"type": "DocumentType", "visibleName": "Notes", "lastModified": "2024-01-02T03:04:05.000Z"}`,
+		notebookId + ".content":    `{"fileType": "", "pageCount": 0}`,
+	})
+
+	files, _, err := parseXochitlListing(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %v", files)
+	}
+
+	doc := convertSSHFileToDocInfo(files[0])
+	if doc.LastModified == nil || !doc.LastModified.Equal(time.UnixMilli(1700000000123)) {
+		t.Errorf("unexpected last modified: %v", doc.LastModified)
+	}
+	if doc.FileType == nil || *doc.FileType != "pdf" {
+		t.Errorf("unexpected file type: %v", doc.FileType)
+	}
+	if doc.PageCount == nil || *doc.PageCount != 12 {
+		t.Errorf("unexpected page count: %v", doc.PageCount)
+	}
+	if doc.Size == nil || *doc.Size != 4096 {
+		t.Errorf("unexpected size: %v", doc.Size)
+	}
+	if !doc.Bookmarked {
+		t.Errorf("pinned document isn't bookmarked")
+	}
+
+	notebook := convertSSHFileToDocInfo(files[1])
+	if notebook.LastModified == nil || !notebook.LastModified.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected last modified: %v", notebook.LastModified)
+	}
+	if notebook.FileType == nil || *notebook.FileType != "notebook" {
+		t.Errorf("unexpected file type: %v", notebook.FileType)
+	}
+	if notebook.PageCount != nil || notebook.Size != nil || notebook.Bookmarked {
+		t.Errorf("unexpected notebook: %+v", notebook)
+	}
+}
+
+func TestSSHReaderTrash(t *testing.T) {
+	r := NewSSHReader(nil)
+	r.docTree = newDocTree([]DocInfo{
+		{Id: "folder", ParentId: trashId, IsFolder: true, Name: "Old"},
+		{Id: "inner", ParentId: "folder", Name: "Inner"},
+		{Id: "doc", ParentId: "", Name: "Doc"},
+	})
+
+	if !r.isInTrash("folder") || !r.isInTrash("inner") {
+		t.Errorf("trashed items aren't in the trash")
+	}
+	if r.isInTrash("doc") || r.isInTrash(trashId) || r.isInTrash("missing") {
+		t.Errorf("unexpected item in the trash")
+	}
+	if len(r.GetFolder("")) != 1 {
+		t.Errorf("trashed items are listed in the root: %v", r.GetFolder(""))
+	}
+	if got := r.getSubtree("folder"); len(got) != 2 || got[0] != "inner" || got[1] != "folder" {
+		t.Errorf("unexpected subtree: %v", got)
+	}
+	if err := r.RestoreFromTrash("doc"); err == nil {
+		t.Errorf("restored an item that isn't in the trash")
+	}
+}
+
+func TestSSHReaderCheckMove(t *testing.T) {
+	r := NewSSHReader(nil)
+	r.docTree = newDocTree([]DocInfo{
+		{Id: "books", ParentId: "", IsFolder: true, Name: "Books"},
+		{Id: "novels", ParentId: "books", IsFolder: true, Name: "Novels"},
+		{Id: "doc", ParentId: "books", Name: "Doc"},
+		{Id: "old", ParentId: trashId, IsFolder: true, Name: "Old"},
+	})
+	r.docById[trashId] = DocInfo{Id: trashId, IsFolder: true, Name: "Trash"}
+
+	tests := []struct {
+		id, parent DocId
+		valid      bool
+	}{
+		{"doc", "", true},
+		{"doc", "novels", true},
+		{"novels", "", true},
+		{"books", "books", false},  // into itself
+		{"books", "novels", false}, // into its own subfolder
+		{"doc", "doc", false},      // not a folder
+		{"doc", trashId, false},    // MoveToTrash() does that
+		{"doc", "old", false},      // a trashed folder
+		{"doc", "missing", false},
+		{trashId, "", false},
+	}
+	for _, test := range tests {
+		err := r.checkMove(test.id, test.parent)
+		if (err == nil) != test.valid {
+			t.Errorf("moving %s into %q: unexpected result %v", test.id, test.parent, err)
+		}
+	}
+
+	/* Moving into the same folder doesn't change anything */
+	if err := r.Move("doc", "books"); err != nil {
+		t.Error(err)
+	}
+	if err := r.Rename("doc", "  "); err == nil {
+		t.Errorf("renamed to an empty name")
+	}
+	if err := r.MoveToTrash("old"); err == nil {
+		t.Errorf("trashed an item that is already in the trash")
+	}
+	if err := r.Delete("missing"); err == nil {
+		t.Errorf("deleted a missing item")
+	}
+}
+
+func TestUpdateMetadataJSON(t *testing.T) {
+	data := []byte(`{"parent": "trash", "version": 12345678901234567, "visibleName": "Doc", "newField": {"a": 1}}`)
+	updated, err := updateMetadataJSON(data, map[string]interface{}{"parent": ""}, time.UnixMilli(1700000000123))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var metadata SSHMetadata
+	if err := json.Unmarshal(updated, &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Parent != "" || metadata.VisibleName != "Doc" || metadata.LastModified != "1700000000123" || !metadata.MetadataModified {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	for _, kept := range []string{`"version": 12345678901234567`, `"newField"`} {
+		if !strings.Contains(string(updated), kept) {
+			t.Errorf("%s is lost: %s", kept, updated)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+/*
+Staging directories are next to xochitl's directory, on the same filesystem,
+so that moving the files into place is a rename. xochitl doesn't look at them.
+*/
+var stagingParentDir = path.Dir(xochitlDir)
+
+const stagingPrefix = ".rm-importer-staging-"
+
+/* Staging directories and temporary files older than this are left from an interrupted import, in minutes */
+const staleStagingAge = 60
+
+/* What the staging needs from the connection, SSHConnection in the app. */
+type stagingTarget interface {
+	UploadFile(localPath, remotePath string) error
+	WriteRemoteFile(path, content string) error
+	MkdirAll(remotePath string) error
+	Stat(remotePath string) (RemoteFileInfo, error)
+	Rename(oldPath, newPath string) error
+	RemoveAll(remotePath string) error
+}
+
+/*
+Collects the files of an import in a temporary remote directory. The files appear in xochitl's
+directory only when commit() moves them all into place; a failure or a cancellation before that
+leaves xochitl's directory as it was.
+
+The .metadata files are moved last, xochitl ignores a document without one.
+*/
+type remoteStage struct {
+	target stagingTarget
+	dir    string
+	names  []string        // entries of the stage, files and directories, relative to xochitl's directory
+	exists map[string]bool // entries that replace existing ones
+	closed bool            // the staging directory was removed
+}
+
+func newRemoteStage(target stagingTarget) (*remoteStage, error) {
+	stage := &remoteStage{
+		target: target,
+		dir:    path.Join(stagingParentDir, stagingPrefix+uuid.New().String()),
+		exists: map[string]bool{},
+	}
+
+	err := target.MkdirAll(stage.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	return stage, nil
+}
+
+func (s *remoteStage) path(name string) string {
+	return path.Join(s.dir, name)
+}
+
+func (s *remoteStage) add(name string) {
+	if !slices.Contains(s.names, name) {
+		s.names = append(s.names, name)
+	}
+}
+
+/* Stages a local file as the file 'name' of xochitl's directory. */
+func (s *remoteStage) upload(localPath, name string) error {
+	err := s.target.UploadFile(localPath, s.path(name))
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %v", name, err)
+	}
+	s.add(name)
+	return nil
+}
+
+/* Stages a file with the content, name may be in a subdirectory, e.g. "<id>/<page>.rm". */
+func (s *remoteStage) write(name string, content []byte) error {
+	if dir := path.Dir(name); dir != "." {
+		err := s.target.MkdirAll(s.path(dir))
+		if err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", dir, err)
+		}
+	}
+
+	err := s.target.WriteRemoteFile(s.path(name), string(content))
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %v", name, err)
+	}
+	s.add(topLevelName(name))
+	return nil
+}
+
+func (s *remoteStage) mkdir(name string) error {
+	err := s.target.MkdirAll(s.path(name))
+	if err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", name, err)
+	}
+	s.add(name)
+	return nil
+}
+
+/* Marks a staged file as the replacement of an existing file, which commit() puts back on failure. */
+func (s *remoteStage) replaces(name string) {
+	s.exists[name] = true
+}
+
+func topLevelName(name string) string {
+	top, _, _ := strings.Cut(name, "/")
+	return top
+}
+
+/* The entries in the order they are moved into place: the .metadata files last. */
+func (s *remoteStage) commitOrder() []string {
+	names := slices.Clone(s.names)
+	slices.SortStableFunc(names, func(a, b string) int {
+		aMeta, bMeta := strings.HasSuffix(a, ".metadata"), strings.HasSuffix(b, ".metadata")
+		switch {
+		case aMeta == bMeta:
+			return 0
+		case aMeta:
+			return 1
+		default:
+			return -1
+		}
+	})
+	return names
+}
+
+/*
+Moves the staged entries into xochitl's directory, unless ctx is cancelled. If a move fails,
+the entries that were already moved are removed and the replaced files are put back.
+The staging directory is removed in any case, one that can't be is removed as a stale one later.
+*/
+func (s *remoteStage) commit(ctx context.Context) error {
+	defer s.abort()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	/* The replaced files wait in the stage until everything is in place */
+	backups := path.Join(s.dir, ".replaced")
+	if len(s.exists) > 0 {
+		if err := s.target.MkdirAll(backups); err != nil {
+			return fmt.Errorf("failed to create directory for the replaced files: %v", err)
+		}
+	}
+
+	moved := []string{}
+	backedUp := []string{}
+	rollback := func() {
+		for _, name := range moved {
+			s.target.RemoveAll(xochitlPath(name))
+		}
+		for _, name := range backedUp {
+			s.target.Rename(path.Join(backups, name), xochitlPath(name))
+		}
+	}
+
+	for _, name := range s.commitOrder() {
+		if s.exists[name] {
+			err := s.target.Rename(xochitlPath(name), path.Join(backups, name))
+			if err == nil {
+				backedUp = append(backedUp, name)
+			} else if _, statErr := s.target.Stat(xochitlPath(name)); !errors.Is(statErr, fs.ErrNotExist) {
+				rollback()
+				return fmt.Errorf("failed to move %s aside: %v", name, err)
+			}
+		}
+
+		err := s.target.Rename(s.path(name), xochitlPath(name))
+		if err != nil {
+			rollback()
+			return fmt.Errorf("failed to move %s into place: %v", name, err)
+		}
+		moved = append(moved, name)
+	}
+	return nil
+}
+
+/* Removes the staging directory with everything in it, does nothing after commit(). */
+func (s *remoteStage) abort() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := s.target.RemoveAll(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to remove staging directory: %v", err)
+	}
+	return nil
+}
+
+/*
+Removes the staging directories and the temporary files of writeFileAtomic() left by imports
+that were interrupted, e.g. by a lost connection.
+*/
+func (s *SSHConnection) RemoveStaleStaging() error {
+	command := newRemoteCommand("find").path(stagingParentDir, xochitlDir).
+		arg("-maxdepth", "1", "(", "-name", stagingPrefix+"*", "-o", "-name", ".*"+atomicTempMarker+"*", ")",
+			"-mmin", fmt.Sprintf("+%d", staleStagingAge), "-exec", "rm", "-rf", "{}", "+")
+	_, err := s.executeSSHCommand(command)
+	if err != nil {
+		return fmt.Errorf("failed to remove stale staging directories: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+/* A remote filesystem in memory, directories are the entries without content. */
+type fakeStagingTarget struct {
+	files   map[string]string
+	renames []string // destinations, in order
+	failOn  string   // Rename from this path fails
+}
+
+func newFakeStagingTarget(files map[string]string) *fakeStagingTarget {
+	return &fakeStagingTarget{files: files}
+}
+
+func (f *fakeStagingTarget) UploadFile(localPath, remotePath string) error {
+	f.files[remotePath] = "uploaded " + localPath
+	return nil
+}
+
+func (f *fakeStagingTarget) WriteRemoteFile(path, content string) error {
+	f.files[path] = content
+	return nil
+}
+
+func (f *fakeStagingTarget) MkdirAll(remotePath string) error {
+	f.files[remotePath] = ""
+	return nil
+}
+
+func (f *fakeStagingTarget) Stat(remotePath string) (RemoteFileInfo, error) {
+	if _, ok := f.files[remotePath]; !ok {
+		return RemoteFileInfo{}, fs.ErrNotExist
+	}
+	return RemoteFileInfo{}, nil
+}
+
+func (f *fakeStagingTarget) Rename(oldPath, newPath string) error {
+	if oldPath == f.failOn {
+		return errors.New("no space left on device")
+	}
+	if _, ok := f.files[oldPath]; !ok {
+		return fs.ErrNotExist
+	}
+
+	f.RemoveAll(newPath)
+	for name, content := range f.files {
+		if name == oldPath || strings.HasPrefix(name, oldPath+"/") {
+			delete(f.files, name)
+			f.files[newPath+strings.TrimPrefix(name, oldPath)] = content
+		}
+	}
+	f.renames = append(f.renames, path.Base(newPath))
+	return nil
+}
+
+func (f *fakeStagingTarget) RemoveAll(remotePath string) error {
+	for name := range f.files {
+		if name == remotePath || strings.HasPrefix(name, remotePath+"/") {
+			delete(f.files, name)
+		}
+	}
+	return nil
+}
+
+func (f *fakeStagingTarget) paths() []string {
+	return slices.Sorted(maps.Keys(f.files))
+}
+
+func stageDocument(t *testing.T, target *fakeStagingTarget) *remoteStage {
+	stage, err := newRemoteStage(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stage.write("doc.metadata", []byte("metadata")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stage.upload("paper.pdf", "doc.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := stage.write("doc/page.rm", []byte("lines")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stage.mkdir("doc.thumbnails"); err != nil {
+		t.Fatal(err)
+	}
+	return stage
+}
+
+func TestRemoteStageCommit(t *testing.T) {
+	target := newFakeStagingTarget(map[string]string{})
+	stage := stageDocument(t, target)
+
+	if err := stage.commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		xochitlPath("doc"),
+		xochitlPath("doc.metadata"),
+		xochitlPath("doc.pdf"),
+		xochitlPath("doc.thumbnails"),
+		xochitlPath("doc/page.rm"),
+	}
+	if diff := cmp.Diff(want, target.paths()); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"doc.pdf", "doc", "doc.thumbnails", "doc.metadata"}, target.renames); diff != "" {
+		t.Errorf("the .metadata isn't moved last (-want +got):\n%s", diff)
+	}
+}
+
+func TestRemoteStageRollback(t *testing.T) {
+	target := newFakeStagingTarget(map[string]string{
+		xochitlPath("doc.pdf"):     "old pdf",
+		xochitlPath("doc.content"): "old content",
+	})
+	before := maps.Clone(target.files)
+
+	stage, err := newRemoteStage(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage.upload("paper.pdf", "doc.pdf")
+	stage.replaces("doc.pdf")
+	stage.write("doc.content", []byte("new content"))
+	stage.replaces("doc.content")
+
+	target.failOn = stage.path("doc.content")
+	if err := stage.commit(context.Background()); err == nil {
+		t.Fatal("expected the commit to fail")
+	}
+	if diff := cmp.Diff(before, target.files); diff != "" {
+		t.Errorf("the replaced files weren't restored (-want +got):\n%s", diff)
+	}
+}
+
+func TestRemoteStageCancelled(t *testing.T) {
+	target := newFakeStagingTarget(map[string]string{})
+	stage := stageDocument(t, target)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := stage.commit(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancellation, got %v", err)
+	}
+	if len(target.files) != 0 || len(target.renames) != 0 {
+		t.Errorf("a cancelled stage left files: %v", target.paths())
+	}
+
+	/* abort() after commit() does nothing */
+	if err := stage.abort(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,171 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+/* A tag of a document in its .content file */
+type SSHTag struct {
+	Name      string      `json:"name"`
+	Timestamp json.Number `json:"timestamp,omitempty"` // when it was added, Unix time in milliseconds
+}
+
+/* A tag of a page, the page is identified by its UUID from the .content file */
+type SSHPageTag struct {
+	Name      string      `json:"name"`
+	PageId    string      `json:"pageId"`
+	Timestamp json.Number `json:"timestamp,omitempty"`
+}
+
+/* Returns the names of the document's tags and of its pages' tags, sorted and without repetitions. */
+func contentTags(content *SSHContent) (tags []string, pageTags []string) {
+	if content == nil {
+		return nil, nil
+	}
+	for _, tag := range content.Tags {
+		tags = append(tags, tag.Name)
+	}
+	for _, tag := range content.PageTags {
+		pageTags = append(pageTags, tag.Name)
+	}
+	slices.Sort(tags)
+	slices.Sort(pageTags)
+	return slices.Compact(tags), slices.Compact(pageTags)
+}
+
+func validateTagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("the tag can't be empty")
+	}
+	return name, nil
+}
+
+/*
+Adds document tags to a .content file and removes tags from the document and from its pages.
+The tags that stay keep their timestamps, fields the app doesn't know about are kept as they are.
+*/
+func updateTagsJSON(data []byte, add, remove []string, now time.Time) ([]byte, error) {
+	content := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&content)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []SSHTag
+	var pageTags []SSHPageTag
+	if err := convertJSON(content["tags"], &tags); err != nil {
+		return nil, fmt.Errorf("invalid tags: %v", err)
+	}
+	if err := convertJSON(content["pageTags"], &pageTags); err != nil {
+		return nil, fmt.Errorf("invalid page tags: %v", err)
+	}
+
+	tags = slices.DeleteFunc(tags, func(tag SSHTag) bool { return slices.Contains(remove, tag.Name) })
+	pageTags = slices.DeleteFunc(pageTags, func(tag SSHPageTag) bool { return slices.Contains(remove, tag.Name) })
+
+	timestamp := json.Number(fmt.Sprintf("%d", now.UnixMilli()))
+	for _, name := range add {
+		if !slices.ContainsFunc(tags, func(tag SSHTag) bool { return tag.Name == name }) {
+			tags = append(tags, SSHTag{Name: name, Timestamp: timestamp})
+		}
+	}
+
+	/* xochitl writes empty lists rather than leaving the fields out */
+	content["tags"] = append([]SSHTag{}, tags...)
+	content["pageTags"] = append([]SSHPageTag{}, pageTags...)
+	return json.MarshalIndent(content, "", "    ")
+}
+
+/* Converts a decoded JSON value to another type, nil leaves v as it is. */
+func convertJSON(value interface{}, v interface{}) error {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+/* Changes the tags in a document's .content file and marks the document as modified. */
+func (s *SSHConnection) UpdateTags(id string, add, remove []string) error {
+	if err := validateDocId(id); err != nil {
+		return err
+	}
+
+	contentPath := xochitlPath(id + ".content")
+	data, err := s.ReadRemoteFile(contentPath)
+	if err != nil {
+		return fmt.Errorf("failed to read content of %s: %w", id, err)
+	}
+
+	updated, err := updateTagsJSON(data, add, remove, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update tags of %s: %v", id, err)
+	}
+
+	err = s.WriteRemoteFile(contentPath, string(updated))
+	if err != nil {
+		return fmt.Errorf("failed to write content of %s: %v", id, err)
+	}
+	return s.UpdateMetadata(id, map[string]interface{}{})
+}
+
+/* Pins a document or folder to the tablet's favorites, or unpins it. */
+func (r *SSHReader) SetPinned(id DocId, pinned bool) error {
+	if err := r.checkItem(id); err != nil {
+		return err
+	}
+	if r.docById[id].Bookmarked == pinned {
+		return nil
+	}
+	return r.connection.UpdateMetadata(id, map[string]interface{}{"pinned": pinned})
+}
+
+/* Adds a tag to a document, or removes it from the document and from its pages. */
+func (r *SSHReader) SetTag(id DocId, name string, tagged bool) error {
+	if err := r.checkItem(id); err != nil {
+		return err
+	}
+	if r.docById[id].IsFolder {
+		return fmt.Errorf("folders can't be tagged")
+	}
+
+	name, err := validateTagName(name)
+	if err != nil {
+		return err
+	}
+
+	doc := r.docById[id]
+	if tagged {
+		if slices.Contains(doc.Tags, name) {
+			return nil
+		}
+		return r.connection.UpdateTags(id, []string{name}, nil)
+	}
+
+	if !slices.Contains(doc.Tags, name) && !slices.Contains(doc.PageTags, name) {
+		return nil
+	}
+	return r.connection.UpdateTags(id, nil, []string{name})
+}
+
+/* Returns the names of all the tags on the tablet, of documents and of pages, sorted. */
+func (r *SSHReader) GetTags() []string {
+	tags := []string{}
+	for _, doc := range r.docById {
+		tags = append(tags, doc.Tags...)
+		tags = append(tags, doc.PageTags...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
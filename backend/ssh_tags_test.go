@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpdateTagsJSON(t *testing.T) {
+	data := []byte(`{
+		"fileType": "pdf",
+		"tags": [{"name": "keep", "timestamp": 1600000000000}, {"name": "old", "timestamp": 1600000000001}],
+		"pageTags": [{"name": "old", "pageId": "p1", "timestamp": 1}, {"name": "page", "pageId": "p2", "timestamp": 2}],
+		"newField": {"a": 1}
+	}`)
+
+	updated, err := updateTagsJSON(data, []string{"keep", "new"}, []string{"old"}, time.UnixMilli(1700000000123))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var content SSHContent
+	if err := json.Unmarshal(updated, &content); err != nil {
+		t.Fatal(err)
+	}
+	wantTags := []SSHTag{{"keep", "1600000000000"}, {"new", "1700000000123"}}
+	if diff := cmp.Diff(wantTags, content.Tags); diff != "" {
+		t.Errorf("tags mismatch (-want +got):\n%s", diff)
+	}
+	wantPageTags := []SSHPageTag{{"page", "p2", "2"}}
+	if diff := cmp.Diff(wantPageTags, content.PageTags); diff != "" {
+		t.Errorf("page tags mismatch (-want +got):\n%s", diff)
+	}
+
+	fields := map[string]interface{}{}
+	json.Unmarshal(updated, &fields)
+	if fields["newField"] == nil || fields["fileType"] != "pdf" {
+		t.Errorf("other fields were lost: %s", updated)
+	}
+
+	/* A file without tags gets the empty lists xochitl writes */
+	updated, err = updateTagsJSON([]byte(`{"fileType": "epub"}`), nil, []string{"old"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(updated, &fields)
+	if tags, ok := fields["tags"].([]interface{}); !ok || len(tags) != 0 {
+		t.Errorf("unexpected tags: %s", updated)
+	}
+
+	if _, err := updateTagsJSON([]byte(`{"tags": "invalid"}`), nil, nil, time.Now()); err == nil {
+		t.Errorf("invalid tags were accepted")
+	}
+}
+
+func TestSSHReaderTags(t *testing.T) {
+	file := SSHFileInfo{
+		ID: "doc", Name: "Doc",
+		Content: &SSHContent{
+			Tags:     []SSHTag{{Name: "work"}, {Name: "books"}},
+			PageTags: []SSHPageTag{{Name: "todo", PageId: "p1"}, {Name: "todo", PageId: "p2"}, {Name: "work", PageId: "p2"}},
+		},
+	}
+	doc := convertSSHFileToDocInfo(file)
+	if diff := cmp.Diff([]string{"books", "work"}, doc.Tags); diff != "" {
+		t.Errorf("tags mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"todo", "work"}, doc.PageTags); diff != "" {
+		t.Errorf("page tags mismatch (-want +got):\n%s", diff)
+	}
+
+	r := NewSSHReader(nil)
+	r.docTree = newDocTree([]DocInfo{doc, {Id: "folder", IsFolder: true, Name: "Folder", Bookmarked: true}})
+	if diff := cmp.Diff([]string{"books", "todo", "work"}, r.GetTags()); diff != "" {
+		t.Errorf("tablet tags mismatch (-want +got):\n%s", diff)
+	}
+
+	/* Nothing to change, the connection isn't used */
+	if err := r.SetTag("doc", " work ", true); err != nil {
+		t.Error(err)
+	}
+	if err := r.SetTag("doc", "other", false); err != nil {
+		t.Error(err)
+	}
+	if err := r.SetPinned("folder", true); err != nil {
+		t.Error(err)
+	}
+
+	if r.SetTag("folder", "work", true) == nil || r.SetTag("doc", " ", true) == nil {
+		t.Errorf("invalid tag change was accepted")
+	}
+}
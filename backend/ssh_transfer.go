@@ -0,0 +1,503 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/sftp"
+)
+
+/* Describes a file or a directory on the tablet. */
+type RemoteFileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+/*
+File operations on the tablet used by SSHConnection.
+
+Two implementations exist: sftpTransfer talks to the tablet's sftp-server,
+execTransfer emulates the same operations with plain shell commands
+for tablets that don't have sftp-server installed.
+
+Remote paths starting with "~/" are relative to the user's home directory.
+Errors for missing files wrap fs.ErrNotExist.
+*/
+type fileTransfer interface {
+	Stat(remotePath string) (RemoteFileInfo, error)
+	ReadDir(remotePath string) ([]RemoteFileInfo, error)
+	/* Writes the remote file to w, skipping the first 'offset' bytes. */
+	Download(remotePath string, offset int64, w io.Writer) error
+	/* Writes r to the remote file starting at 'offset'. Offset 0 truncates the file. */
+	Upload(r io.Reader, remotePath string, offset int64) error
+	Chmod(remotePath string, perm os.FileMode) error
+	/* Renames the file, replacing newPath if it exists. */
+	Rename(oldPath, newPath string) error
+	Remove(remotePath string) error
+	MkdirAll(remotePath string) error
+	Close() error
+}
+
+/*
+sftp-server doesn't expand '~', but it starts in the home directory,
+so the paths relative to home just need the prefix removed.
+*/
+func sftpPath(p string) string {
+	if p == "~" {
+		return "."
+	}
+	return strings.TrimPrefix(p, "~/")
+}
+
+type sftpTransfer struct {
+	client *sftp.Client
+}
+
+func (t *sftpTransfer) Stat(remotePath string) (RemoteFileInfo, error) {
+	info, err := t.client.Stat(sftpPath(remotePath))
+	if err != nil {
+		return RemoteFileInfo{}, err
+	}
+	return RemoteFileInfo{info.Name(), info.Size(), info.ModTime(), info.IsDir()}, nil
+}
+
+func (t *sftpTransfer) ReadDir(remotePath string) ([]RemoteFileInfo, error) {
+	infos, err := t.client.ReadDir(sftpPath(remotePath))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []RemoteFileInfo{}
+	for _, info := range infos {
+		result = append(result, RemoteFileInfo{info.Name(), info.Size(), info.ModTime(), info.IsDir()})
+	}
+	return result, nil
+}
+
+func (t *sftpTransfer) Download(remotePath string, offset int64, w io.Writer) error {
+	f, err := t.client.Open(sftpPath(remotePath))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if offset > 0 {
+		_, err = f.Seek(offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (t *sftpTransfer) Upload(r io.Reader, remotePath string, offset int64) error {
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := t.client.OpenFile(sftpPath(remotePath), flags)
+	if err != nil {
+		return err
+	}
+
+	if offset > 0 {
+		_, err = f.Seek(offset, io.SeekStart)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (t *sftpTransfer) Chmod(remotePath string, perm os.FileMode) error {
+	return t.client.Chmod(sftpPath(remotePath), perm)
+}
+
+func (t *sftpTransfer) Rename(oldPath, newPath string) error {
+	oldPath, newPath = sftpPath(oldPath), sftpPath(newPath)
+
+	/* posix-rename replaces the target atomically, but it's an OpenSSH extension. Its errors are
+	   returned as they are: removing the target after e.g. a missing source would lose the file. */
+	if _, ok := t.client.HasExtension("posix-rename@openssh.com"); ok {
+		return t.client.PosixRename(oldPath, newPath)
+	}
+
+	/* Plain SFTP rename fails if the target exists, so the target is removed first */
+	err := t.client.Remove(newPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return t.client.Rename(oldPath, newPath)
+}
+
+func (t *sftpTransfer) Remove(remotePath string) error {
+	return t.client.Remove(sftpPath(remotePath))
+}
+
+func (t *sftpTransfer) MkdirAll(remotePath string) error {
+	return t.client.MkdirAll(sftpPath(remotePath))
+}
+
+func (t *sftpTransfer) Close() error {
+	return t.client.Close()
+}
+
+/* Fallback transfer that runs shell commands over the SSH connection. */
+type execTransfer struct {
+	connection *SSHConnection
+}
+
+/* Converts the output of a failed command into an error, recognizing a missing file. */
+func execError(output string, err error) error {
+	if strings.Contains(output, "No such file") {
+		return fmt.Errorf("%w: %s", fs.ErrNotExist, strings.TrimSpace(output))
+	}
+	return fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+}
+
+/* Format for `stat -c`: size, modification time, file type and name */
+const statFormat = "%s %Y %F|%n"
+
+/* Parses a line of `stat -c statFormat` output. */
+func parseStatLine(line string) (RemoteFileInfo, error) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return RemoteFileInfo{}, fmt.Errorf("unexpected stat output: %q", line)
+	}
+
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return RemoteFileInfo{}, fmt.Errorf("unexpected stat output: %q", line)
+	}
+
+	mtime, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return RemoteFileInfo{}, fmt.Errorf("unexpected stat output: %q", line)
+	}
+
+	/* %F is either "directory", "regular file", "regular empty file", etc.
+	   The name follows it after a '|' separator. */
+	kind, name, _ := strings.Cut(fields[2], "|")
+
+	return RemoteFileInfo{
+		Name:    path.Base(name),
+		Size:    size,
+		ModTime: time.Unix(mtime, 0),
+		IsDir:   kind == "directory",
+	}, nil
+}
+
+func (t *execTransfer) Stat(remotePath string) (RemoteFileInfo, error) {
+	output, err := t.connection.executeSSHCommand(newRemoteCommand("stat", "-c", statFormat).path(remotePath))
+	if err != nil {
+		return RemoteFileInfo{}, execError(output, err)
+	}
+	return parseStatLine(strings.TrimSpace(output))
+}
+
+func (t *execTransfer) ReadDir(remotePath string) ([]RemoteFileInfo, error) {
+	command := newRemoteCommand("find").path(remotePath).
+		arg("-mindepth", "1", "-maxdepth", "1", "-exec", "stat", "-c", statFormat, "{}", "+")
+	output, err := t.connection.executeSSHCommand(command)
+	if err != nil {
+		return nil, execError(output, err)
+	}
+
+	result := []RemoteFileInfo{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		info, err := parseStatLine(line)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func (t *execTransfer) Download(remotePath string, offset int64, w io.Writer) error {
+	session, err := t.connection.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdout = w
+	session.Stderr = &stderr
+
+	/* tail -c +N starts at the N-th byte, counting from 1 */
+	err = session.Run(newRemoteCommand("tail", "-c", fmt.Sprintf("+%d", offset+1)).path(remotePath).String())
+	if err != nil {
+		return execError(stderr.String(), err)
+	}
+	return nil
+}
+
+func (t *execTransfer) Upload(r io.Reader, remotePath string, offset int64) error {
+	session, err := t.connection.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = r
+	session.Stderr = &stderr
+
+	command := newRemoteCommand("cat").writeTo(remotePath)
+	if offset > 0 {
+		/* The caller resumes from the current end of the file */
+		command = newRemoteCommand("cat").appendTo(remotePath)
+	}
+
+	err = session.Run(command.String())
+	if err != nil {
+		return execError(stderr.String(), err)
+	}
+	return nil
+}
+
+func (t *execTransfer) Chmod(remotePath string, perm os.FileMode) error {
+	output, err := t.connection.executeSSHCommand(newRemoteCommand("chmod", fmt.Sprintf("%o", perm.Perm())).path(remotePath))
+	if err != nil {
+		return execError(output, err)
+	}
+	return nil
+}
+
+func (t *execTransfer) Rename(oldPath, newPath string) error {
+	output, err := t.connection.executeSSHCommand(newRemoteCommand("mv", "-f").path(oldPath, newPath))
+	if err != nil {
+		return execError(output, err)
+	}
+	return nil
+}
+
+func (t *execTransfer) Remove(remotePath string) error {
+	output, err := t.connection.executeSSHCommand(newRemoteCommand("rm").path(remotePath))
+	if err != nil {
+		return execError(output, err)
+	}
+	return nil
+}
+
+func (t *execTransfer) MkdirAll(remotePath string) error {
+	output, err := t.connection.executeSSHCommand(newRemoteCommand("mkdir", "-p").path(remotePath))
+	if err != nil {
+		return execError(output, err)
+	}
+	return nil
+}
+
+func (t *execTransfer) Close() error {
+	return nil
+}
+
+/* Marks the temporary files of writeFileAtomic(), so that leftovers can be found. */
+const atomicTempMarker = ".rm-importer-tmp-"
+
+/*
+Writes data to a remote file, replacing it atomically: the data goes to a hidden temporary file
+in the same directory, which is renamed to remotePath once it's complete. Readers see either
+the old file or the new one. xochitl doesn't pick up the temporary file, its name doesn't end
+with a document suffix.
+*/
+func writeFileAtomic(t fileTransfer, remotePath string, data []byte, perm os.FileMode) error {
+	tempPath := path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+atomicTempMarker+uuid.New().String())
+
+	err := t.Upload(bytes.NewReader(data), tempPath, 0)
+	if err == nil {
+		err = checkUploadedSize(t, tempPath, int64(len(data)))
+	}
+	if err == nil {
+		err = t.Chmod(tempPath, perm)
+	}
+	if err == nil {
+		err = t.Rename(tempPath, remotePath)
+	}
+	if err != nil {
+		t.Remove(tempPath)
+		return fmt.Errorf("failed to write %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+func checkUploadedSize(t fileTransfer, remotePath string, size int64) error {
+	info, err := t.Stat(remotePath)
+	if err != nil {
+		return err
+	}
+	if info.Size != size {
+		return fmt.Errorf("wrote %d bytes, expected %d", info.Size, size)
+	}
+	return nil
+}
+
+/*
+Identifies the version of a file whose data is in a .part file, so that a transfer only resumes
+where the same file stopped. It's kept next to the .part file, in "<path>.part.stamp".
+*/
+func transferStamp(size int64, modTime time.Time) string {
+	return fmt.Sprintf("%d %d", size, modTime.Unix())
+}
+
+/*
+Downloads a file to "<localPath>.part" and renames it into place once its size matches, returning
+the offset the download resumed from. The .part file is kept only when the transfer itself was
+interrupted, on other errors it's removed, so that a broken file isn't resumed later.
+*/
+func downloadFile(t fileTransfer, remotePath, localPath string) (int64, error) {
+	remote, err := t.Stat(remotePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat remote file %s: %w", remotePath, err)
+	}
+
+	partPath, stampPath := localPath+".part", localPath+".part.stamp"
+	stamp := transferStamp(remote.Size, remote.ModTime)
+	discard := func() {
+		os.Remove(partPath)
+		os.Remove(stampPath)
+	}
+
+	var offset int64
+	if data, err := os.ReadFile(stampPath); err == nil && string(data) == stamp {
+		if info, err := os.Stat(partPath); err == nil && info.Size() <= remote.Size {
+			offset = info.Size()
+		}
+	}
+	if offset == 0 {
+		if err := os.WriteFile(stampPath, []byte(stamp), 0644); err != nil {
+			return 0, fmt.Errorf("failed to create local file: %v", err)
+		}
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	localFile, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		discard()
+		return offset, fmt.Errorf("failed to create local file: %v", err)
+	}
+
+	err = t.Download(remotePath, offset, localFile)
+	closeErr := localFile.Close()
+	if err != nil {
+		return offset, fmt.Errorf("failed to download %s: %w", remotePath, err)
+	}
+	if closeErr != nil {
+		discard()
+		return offset, fmt.Errorf("failed to write local file: %v", closeErr)
+	}
+
+	info, err := os.Stat(partPath)
+	if err != nil {
+		discard()
+		return offset, fmt.Errorf("failed to stat local file: %v", err)
+	}
+	if info.Size() != remote.Size {
+		discard()
+		return offset, fmt.Errorf("downloaded %d bytes of %s, expected %d", info.Size(), remotePath, remote.Size)
+	}
+
+	if err := os.Rename(partPath, localPath); err != nil {
+		discard()
+		return offset, fmt.Errorf("failed to move downloaded file into place: %v", err)
+	}
+	os.Remove(stampPath)
+	return offset, nil
+}
+
+/*
+Uploads a file to "<remotePath>.part", sets its permissions and renames it into place once its size
+matches, returning the offset the upload resumed from. Like in downloadFile(), the .part file is
+kept only when the transfer itself was interrupted.
+*/
+func uploadFile(t fileTransfer, localPath, remotePath string, perm os.FileMode) (int64, error) {
+	if err := t.MkdirAll(path.Dir(remotePath)); err != nil {
+		return 0, fmt.Errorf("failed to create remote directory: %v", err)
+	}
+
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open local file: %v", err)
+	}
+	defer localFile.Close()
+
+	local, err := localFile.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat local file: %v", err)
+	}
+
+	partPath, stampPath := remotePath+".part", remotePath+".part.stamp"
+	stamp := transferStamp(local.Size(), local.ModTime())
+	discard := func() {
+		t.Remove(partPath)
+		t.Remove(stampPath)
+	}
+
+	var offset int64
+	var previous bytes.Buffer
+	if err := t.Download(stampPath, 0, &previous); err == nil && previous.String() == stamp {
+		if info, err := t.Stat(partPath); err == nil && info.Size <= local.Size() {
+			offset = info.Size
+		}
+	}
+	if offset == 0 {
+		if err := t.Upload(strings.NewReader(stamp), stampPath, 0); err != nil {
+			return 0, fmt.Errorf("failed to upload %s: %w", localPath, err)
+		}
+	}
+
+	if _, err := localFile.Seek(offset, io.SeekStart); err != nil {
+		discard()
+		return offset, fmt.Errorf("failed to seek local file: %v", err)
+	}
+	if err := t.Upload(localFile, partPath, offset); err != nil {
+		return offset, fmt.Errorf("failed to upload %s: %w", localPath, err)
+	}
+
+	remote, err := t.Stat(partPath)
+	if err != nil {
+		return offset, fmt.Errorf("failed to stat uploaded file: %w", err)
+	}
+	if remote.Size != local.Size() {
+		discard()
+		return offset, fmt.Errorf("uploaded %d bytes of %s, expected %d", remote.Size, localPath, local.Size())
+	}
+
+	if err := t.Chmod(partPath, perm); err != nil {
+		discard()
+		return offset, fmt.Errorf("failed to set permissions: %v", err)
+	}
+	if err := t.Rename(partPath, remotePath); err != nil {
+		discard()
+		return offset, fmt.Errorf("failed to move uploaded file into place: %v", err)
+	}
+	t.Remove(stampPath)
+	return offset, nil
+}
@@ -0,0 +1,416 @@
+package backend
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+/* Remote files in memory, the operations used by writeFileAtomic() */
+type fakeTransfer struct {
+	files       map[string]string
+	modes       map[string]os.FileMode
+	modTimes    map[string]time.Time
+	renameErr   error
+	uploadLimit int      // bytes written by Upload, 0 for all of them
+	uploads     []string // uploaded paths, in order
+	downloadErr error    // returned by Download after it wrote half of the data
+	offsets     []int64  // offsets of the downloads and uploads of files other than stamps
+}
+
+func newFakeTransfer(files map[string]string) *fakeTransfer {
+	return &fakeTransfer{files: files, modes: map[string]os.FileMode{}, modTimes: map[string]time.Time{}}
+}
+
+func (f *fakeTransfer) Stat(remotePath string) (RemoteFileInfo, error) {
+	content, ok := f.files[remotePath]
+	if !ok {
+		return RemoteFileInfo{}, fs.ErrNotExist
+	}
+	return RemoteFileInfo{Name: remotePath, Size: int64(len(content)), ModTime: f.modTimes[remotePath]}, nil
+}
+
+func (f *fakeTransfer) ReadDir(remotePath string) ([]RemoteFileInfo, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeTransfer) Download(remotePath string, offset int64, w io.Writer) error {
+	content, ok := f.files[remotePath]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	if !strings.HasSuffix(remotePath, ".stamp") {
+		f.offsets = append(f.offsets, offset)
+	}
+	if f.downloadErr != nil {
+		io.WriteString(w, content[offset:offset+(int64(len(content))-offset)/2])
+		return f.downloadErr
+	}
+	_, err := io.WriteString(w, content[offset:])
+	return err
+}
+
+func (f *fakeTransfer) Upload(r io.Reader, remotePath string, offset int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.uploads = append(f.uploads, remotePath)
+	if !strings.HasSuffix(remotePath, ".stamp") {
+		f.offsets = append(f.offsets, offset)
+	}
+	if f.uploadLimit > 0 {
+		data = data[:f.uploadLimit]
+	}
+	f.files[remotePath] = f.files[remotePath][:offset] + string(data)
+	return nil
+}
+
+func (f *fakeTransfer) Chmod(remotePath string, perm os.FileMode) error {
+	f.modes[remotePath] = perm
+	return nil
+}
+
+func (f *fakeTransfer) Rename(oldPath, newPath string) error {
+	if f.renameErr != nil {
+		return f.renameErr
+	}
+	content, ok := f.files[oldPath]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	delete(f.files, oldPath)
+	f.files[newPath] = content
+	f.modes[newPath] = f.modes[oldPath]
+	return nil
+}
+
+func (f *fakeTransfer) Remove(remotePath string) error {
+	if _, ok := f.files[remotePath]; !ok {
+		return fs.ErrNotExist
+	}
+	delete(f.files, remotePath)
+	return nil
+}
+
+func (f *fakeTransfer) MkdirAll(remotePath string) error {
+	return nil
+}
+
+func (f *fakeTransfer) Close() error {
+	return nil
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	target := xochitlPath("doc.metadata")
+	transfer := newFakeTransfer(map[string]string{target: "old"})
+
+	err := writeFileAtomic(transfer, target, []byte("new"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfer.files) != 1 || transfer.files[target] != "new" || transfer.modes[target] != 0644 {
+		t.Errorf("unexpected remote files: %v, %v", transfer.files, transfer.modes)
+	}
+
+	/* The temporary file is in the same directory, hidden, without a document suffix */
+	if len(transfer.uploads) != 1 || !strings.HasPrefix(transfer.uploads[0], xochitlPath(".doc.metadata"+atomicTempMarker)) {
+		t.Errorf("unexpected temporary file: %v", transfer.uploads)
+	}
+}
+
+func TestWriteFileAtomicFailure(t *testing.T) {
+	target := xochitlPath("doc.metadata")
+
+	tests := []struct {
+		name        string
+		renameErr   error
+		uploadLimit int
+	}{
+		{"rename fails", errors.New("permission denied"), 0},
+		{"short write", nil, 2},
+	}
+
+	for _, test := range tests {
+		transfer := newFakeTransfer(map[string]string{target: "old"})
+		transfer.renameErr = test.renameErr
+		transfer.uploadLimit = test.uploadLimit
+
+		err := writeFileAtomic(transfer, target, []byte("new"), 0644)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+
+		/* The old file is untouched and the temporary file is gone */
+		if len(transfer.files) != 1 || transfer.files[target] != "old" {
+			t.Errorf("%s: unexpected remote files: %v", test.name, transfer.files)
+		}
+	}
+}
+
+/*
+Connects an sftpTransfer to an in-memory SFTP server. With extensions, the client only sees those of
+the server's extensions: the server's version packet is filtered on the way, the package's list stays as it is.
+*/
+func newTestSftpTransfer(t *testing.T, extensions []string) *sftpTransfer {
+	t.Helper()
+
+	serverConn, serverSide := net.Pipe()
+	clientConn, clientSide := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, sftp.InMemHandler())
+	go server.Serve()
+	go io.Copy(serverSide, clientSide)
+	go func() {
+		if extensions != nil {
+			if err := filterSftpExtensions(serverSide, clientSide, extensions); err != nil {
+				clientSide.Close()
+				return
+			}
+		}
+		io.Copy(clientSide, serverSide)
+	}()
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+		serverSide.Close()
+		clientSide.Close()
+	})
+	return &sftpTransfer{client: client}
+}
+
+/* Copies the server's version packet, the first one it sends, with only the extensions that are kept. */
+func filterSftpExtensions(r io.Reader, w io.Writer, keep []string) error {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return err
+	}
+	packet := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return err
+	}
+	if len(packet) < 5 {
+		return errors.New("short version packet")
+	}
+
+	/* Type and version, then pairs of strings: the name and the data of each extension */
+	filtered := slices.Clone(packet[:5])
+	readString := func(data []byte) ([]byte, []byte, error) {
+		if len(data) < 4 || uint32(len(data)-4) < binary.BigEndian.Uint32(data) {
+			return nil, nil, errors.New("invalid extension in the version packet")
+		}
+		n := 4 + binary.BigEndian.Uint32(data)
+		return data[:n], data[n:], nil
+	}
+	for rest := packet[5:]; len(rest) > 0; {
+		name, tail, err := readString(rest)
+		if err != nil {
+			return err
+		}
+		data, tail, err := readString(tail)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(keep, string(name[4:])) {
+			filtered = append(append(filtered, name...), data...)
+		}
+		rest = tail
+	}
+
+	_, err := w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(filtered))))
+	if err == nil {
+		_, err = w.Write(filtered)
+	}
+	return err
+}
+
+func readSftpFile(t *testing.T, transfer *sftpTransfer, remotePath string) string {
+	t.Helper()
+
+	var sb strings.Builder
+	if err := transfer.Download(remotePath, 0, &sb); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestSftpTransferRename(t *testing.T) {
+	for name, extensions := range map[string][]string{"posix-rename": nil, "plain rename": {"statvfs@openssh.com"}} {
+		transfer := newTestSftpTransfer(t, extensions)
+		if _, ok := transfer.client.HasExtension("posix-rename@openssh.com"); ok != (name == "posix-rename") {
+			t.Fatalf("%s: the server advertises posix-rename: %v", name, ok)
+		}
+		for remotePath, content := range map[string]string{"/doc.metadata": "old", "/.doc.metadata.tmp": "new"} {
+			if err := transfer.Upload(strings.NewReader(content), remotePath, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		/* The target is replaced */
+		if err := transfer.Rename("/.doc.metadata.tmp", "/doc.metadata"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if content := readSftpFile(t, transfer, "/doc.metadata"); content != "new" {
+			t.Errorf("%s: unexpected target: %q", name, content)
+		}
+
+		/* A failed rename leaves the target alone */
+		if err := transfer.Rename("/missing.tmp", "/doc.metadata"); err == nil {
+			t.Errorf("%s: renaming a missing file succeeded", name)
+		}
+		if name == "posix-rename" {
+			if content := readSftpFile(t, transfer, "/doc.metadata"); content != "new" {
+				t.Errorf("%s: unexpected target after a failed rename: %q", name, content)
+			}
+		}
+	}
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	remote := xochitlPath("doc.pdf")
+	modTime := time.Unix(1700000000, 0)
+	stamp := transferStamp(9, modTime)
+
+	tests := []struct {
+		name       string
+		part       string // left by a previous download
+		stamp      string
+		wantOffset int64
+	}{
+		{"no part file", "", "", 0},
+		{"same file", "abc", stamp, 3},
+		{"changed file", "xyz", transferStamp(9, modTime.Add(time.Second)), 0},
+		{"part without a stamp", "xyz", "", 0},
+		{"larger part", "abcdefghijk", stamp, 0},
+	}
+	for _, test := range tests {
+		transfer := newFakeTransfer(map[string]string{remote: "abcdefghi"})
+		transfer.modTimes[remote] = modTime
+		local := filepath.Join(t.TempDir(), "doc.pdf")
+		if test.part != "" {
+			os.WriteFile(local+".part", []byte(test.part), 0644)
+		}
+		if test.stamp != "" {
+			os.WriteFile(local+".part.stamp", []byte(test.stamp), 0644)
+		}
+
+		offset, err := downloadFile(transfer, remote, local)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if data, _ := os.ReadFile(local); string(data) != "abcdefghi" || offset != test.wantOffset {
+			t.Errorf("%s: got %q from offset %d", test.name, data, offset)
+		}
+		for _, leftover := range []string{local + ".part", local + ".part.stamp"} {
+			if _, err := os.Stat(leftover); err == nil {
+				t.Errorf("%s: %s is left behind", test.name, leftover)
+			}
+		}
+	}
+}
+
+func TestDownloadFileFailure(t *testing.T) {
+	remote := xochitlPath("doc.pdf")
+	local := filepath.Join(t.TempDir(), "doc.pdf")
+
+	/* An interrupted download is resumed by the next one */
+	transfer := newFakeTransfer(map[string]string{remote: "abcdefghi"})
+	transfer.downloadErr = errors.New("connection lost")
+	if _, err := downloadFile(transfer, remote, local); err == nil {
+		t.Fatal("expected an error")
+	}
+	transfer.downloadErr = nil
+	if offset, err := downloadFile(transfer, remote, local); err != nil || offset != 4 {
+		t.Errorf("download didn't resume: offset %d, %v", offset, err)
+	}
+
+	/* A download of the wrong size leaves nothing to resume */
+	os.Remove(local)
+	if _, err := downloadFile(&shortTransfer{transfer}, remote, local); err == nil {
+		t.Fatal("a short download succeeded")
+	}
+	for _, leftover := range []string{local + ".part", local + ".part.stamp"} {
+		if _, err := os.Stat(leftover); err == nil {
+			t.Errorf("%s of a failed download is left behind", leftover)
+		}
+	}
+}
+
+/* Leaves out the last byte of the downloads, without an error */
+type shortTransfer struct {
+	*fakeTransfer
+}
+
+func (s *shortTransfer) Download(remotePath string, offset int64, w io.Writer) error {
+	var sb strings.Builder
+	if err := s.fakeTransfer.Download(remotePath, offset, &sb); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, sb.String()[:max(sb.Len()-1, 0)])
+	return err
+}
+
+func TestUploadFileResume(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "doc.pdf")
+	if err := os.WriteFile(local, []byte("abcdefghi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := xochitlPath("doc.pdf")
+	stamp := transferStamp(info.Size(), info.ModTime())
+
+	tests := []struct {
+		name       string
+		part       string
+		stamp      string
+		wantOffset int64
+	}{
+		{"same file", "abc", stamp, 3},
+		{"other file", "xyz", transferStamp(9, time.Unix(1, 0)), 0},
+		{"part without a stamp", "xyz", "", 0},
+	}
+	for _, test := range tests {
+		transfer := newFakeTransfer(map[string]string{remote + ".part": test.part})
+		if test.stamp != "" {
+			transfer.files[remote+".part.stamp"] = test.stamp
+		}
+
+		offset, err := uploadFile(transfer, local, remote, 0644)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if transfer.files[remote] != "abcdefghi" || offset != test.wantOffset {
+			t.Errorf("%s: got %q from offset %d", test.name, transfer.files[remote], offset)
+		}
+		if len(transfer.files) != 1 {
+			t.Errorf("%s: unexpected remote files: %v", test.name, transfer.files)
+		}
+	}
+
+	/* A failed rename leaves nothing to resume */
+	transfer := newFakeTransfer(map[string]string{})
+	transfer.renameErr = errors.New("permission denied")
+	if _, err := uploadFile(transfer, local, remote, 0644); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(transfer.files) != 0 {
+		t.Errorf("unexpected remote files: %v", transfer.files)
+	}
+}
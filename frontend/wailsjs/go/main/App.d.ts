@@ -2,11 +2,15 @@
 // This file is automatically generated. DO NOT EDIT
 import {backend} from '../models';
 
-export function ConnectSSH(arg1:string,arg2:string,arg3:string):Promise<void>;
+export function CancelUploadSSH():Promise<void>;
 
-export function ConnectSSHForUploads(arg1:string,arg2:string,arg3:string):Promise<void>;
+export function ConnectSSH(arg1:string,arg2:string,arg3:backend.SSHAuthOptions):Promise<void>;
 
-export function CreateFolderSSH(arg1:string,arg2:string):Promise<void>;
+export function ConnectSSHForUploads(arg1:string,arg2:string,arg3:backend.SSHAuthOptions):Promise<void>;
+
+export function CreateFolderSSH(arg1:string,arg2:string):Promise<string>;
+
+export function DeleteItemsSSH(arg1:Array<string>):Promise<void>;
 
 export function DirectoryDialog():Promise<string>;
 
@@ -16,6 +20,10 @@ export function Export():Promise<void>;
 
 export function FileDialog():Promise<string>;
 
+export function FilesDialog():Promise<Array<string>>;
+
+export function ForgetHostKey(arg1:string):Promise<void>;
+
 export function GetAppVersion():Promise<string>;
 
 export function GetCheckedFiles():Promise<Array<backend.DocInfo>>;
@@ -30,26 +38,54 @@ export function GetFolderSelection(arg1:string):Promise<Array<backend.SelectionI
 
 export function GetItemSelection(arg1:string):Promise<backend.SelectionInfo>;
 
+export function GetPendingChanges():Promise<number>;
+
+export function GetRestartPolicy():Promise<string>;
+
 export function GetSafeMode():Promise<boolean>;
 
+export function GetTagsSSH():Promise<Array<string>>;
+
+export function ImportRmdocSSH(arg1:string,arg2:string,arg3:boolean):Promise<string>;
+
 export function InitExport():Promise<void>;
 
 export function IsIpValid(arg1:string):Promise<boolean>;
 
+export function IsListedOverSSH():Promise<boolean>;
+
 export function IsSSHMode():Promise<boolean>;
 
+export function KeyFileDialog():Promise<string>;
+
+export function MoveItemsSSH(arg1:Array<string>,arg2:string):Promise<void>;
+
 export function OnItemSelect(arg1:string,arg2:boolean):Promise<void>;
 
+export function PurgeFromTrash(arg1:string):Promise<void>;
+
 export function ReadDocs(arg1:string):Promise<void>;
 
-export function RestartXochitlSSH():Promise<void>;
+export function RenameItemSSH(arg1:string,arg2:string):Promise<void>;
+
+export function RestartXochitlSSH():Promise<boolean>;
+
+export function RestoreFromTrash(arg1:string):Promise<void>;
 
 export function SetExportOptions(arg1:backend.RmExportOptions):Promise<void>;
 
-export function SetHybridMode(arg1:boolean):Promise<void>;
+export function SetPinnedSSH(arg1:boolean):Promise<void>;
+
+export function SetRestartPolicy(arg1:string):Promise<void>;
 
 export function SetSafeMode(arg1:boolean):Promise<void>;
 
-export function TestSSHConnection(arg1:string,arg2:string,arg3:string):Promise<void>;
+export function SetTagSSH(arg1:string,arg2:boolean):Promise<void>;
+
+export function TestSSHConnection(arg1:string,arg2:string,arg3:backend.SSHAuthOptions):Promise<void>;
+
+export function TrashItemsSSH(arg1:Array<string>):Promise<void>;
+
+export function UploadBatchSSH(arg1:Array<string>,arg2:string,arg3:string):Promise<void>;
 
-export function UploadFileSSH(arg1:string,arg2:string,arg3:string):Promise<string>;
+export function UploadFileSSH(arg1:string,arg2:string,arg3:string,arg4:string):Promise<backend.UploadResult>;
@@ -2,6 +2,10 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function CancelUploadSSH() {
+  return window['go']['main']['App']['CancelUploadSSH']();
+}
+
 export function ConnectSSH(arg1, arg2, arg3) {
   return window['go']['main']['App']['ConnectSSH'](arg1, arg2, arg3);
 }
@@ -14,6 +18,10 @@ export function CreateFolderSSH(arg1, arg2) {
   return window['go']['main']['App']['CreateFolderSSH'](arg1, arg2);
 }
 
+export function DeleteItemsSSH(arg1) {
+  return window['go']['main']['App']['DeleteItemsSSH'](arg1);
+}
+
 export function DirectoryDialog() {
   return window['go']['main']['App']['DirectoryDialog']();
 }
@@ -30,6 +38,14 @@ export function FileDialog() {
   return window['go']['main']['App']['FileDialog']();
 }
 
+export function FilesDialog() {
+  return window['go']['main']['App']['FilesDialog']();
+}
+
+export function ForgetHostKey(arg1) {
+  return window['go']['main']['App']['ForgetHostKey'](arg1);
+}
+
 export function GetAppVersion() {
   return window['go']['main']['App']['GetAppVersion']();
 }
@@ -58,10 +74,26 @@ export function GetItemSelection(arg1) {
   return window['go']['main']['App']['GetItemSelection'](arg1);
 }
 
+export function GetPendingChanges() {
+  return window['go']['main']['App']['GetPendingChanges']();
+}
+
+export function GetRestartPolicy() {
+  return window['go']['main']['App']['GetRestartPolicy']();
+}
+
 export function GetSafeMode() {
   return window['go']['main']['App']['GetSafeMode']();
 }
 
+export function GetTagsSSH() {
+  return window['go']['main']['App']['GetTagsSSH']();
+}
+
+export function ImportRmdocSSH(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ImportRmdocSSH'](arg1, arg2, arg3);
+}
+
 export function InitExport() {
   return window['go']['main']['App']['InitExport']();
 }
@@ -70,38 +102,78 @@ export function IsIpValid(arg1) {
   return window['go']['main']['App']['IsIpValid'](arg1);
 }
 
+export function IsListedOverSSH() {
+  return window['go']['main']['App']['IsListedOverSSH']();
+}
+
 export function IsSSHMode() {
   return window['go']['main']['App']['IsSSHMode']();
 }
 
+export function KeyFileDialog() {
+  return window['go']['main']['App']['KeyFileDialog']();
+}
+
+export function MoveItemsSSH(arg1, arg2) {
+  return window['go']['main']['App']['MoveItemsSSH'](arg1, arg2);
+}
+
 export function OnItemSelect(arg1, arg2) {
   return window['go']['main']['App']['OnItemSelect'](arg1, arg2);
 }
 
+export function PurgeFromTrash(arg1) {
+  return window['go']['main']['App']['PurgeFromTrash'](arg1);
+}
+
 export function ReadDocs(arg1) {
   return window['go']['main']['App']['ReadDocs'](arg1);
 }
 
+export function RenameItemSSH(arg1, arg2) {
+  return window['go']['main']['App']['RenameItemSSH'](arg1, arg2);
+}
+
 export function RestartXochitlSSH() {
   return window['go']['main']['App']['RestartXochitlSSH']();
 }
 
+export function RestoreFromTrash(arg1) {
+  return window['go']['main']['App']['RestoreFromTrash'](arg1);
+}
+
 export function SetExportOptions(arg1) {
   return window['go']['main']['App']['SetExportOptions'](arg1);
 }
 
-export function SetHybridMode(arg1) {
-  return window['go']['main']['App']['SetHybridMode'](arg1);
+export function SetPinnedSSH(arg1) {
+  return window['go']['main']['App']['SetPinnedSSH'](arg1);
+}
+
+export function SetRestartPolicy(arg1) {
+  return window['go']['main']['App']['SetRestartPolicy'](arg1);
 }
 
 export function SetSafeMode(arg1) {
   return window['go']['main']['App']['SetSafeMode'](arg1);
 }
 
+export function SetTagSSH(arg1, arg2) {
+  return window['go']['main']['App']['SetTagSSH'](arg1, arg2);
+}
+
 export function TestSSHConnection(arg1, arg2, arg3) {
   return window['go']['main']['App']['TestSSHConnection'](arg1, arg2, arg3);
 }
 
-export function UploadFileSSH(arg1, arg2, arg3) {
-  return window['go']['main']['App']['UploadFileSSH'](arg1, arg2, arg3);
+export function TrashItemsSSH(arg1) {
+  return window['go']['main']['App']['TrashItemsSSH'](arg1);
+}
+
+export function UploadBatchSSH(arg1, arg2, arg3) {
+  return window['go']['main']['App']['UploadBatchSSH'](arg1, arg2, arg3);
+}
+
+export function UploadFileSSH(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['UploadFileSSH'](arg1, arg2, arg3, arg4);
 }
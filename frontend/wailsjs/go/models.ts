@@ -9,6 +9,10 @@ export namespace backend {
 	    // Go type: time
 	    LastModified?: any;
 	    FileType?: string;
+	    PageCount?: number;
+	    Size?: number;
+	    Tags: string[];
+	    PageTags: string[];
 	    DisplayPath?: string;
 	    TabletPath: string[];
 	
@@ -25,6 +29,10 @@ export namespace backend {
 	        this.Bookmarked = source["Bookmarked"];
 	        this.LastModified = this.convertValues(source["LastModified"], null);
 	        this.FileType = source["FileType"];
+	        this.PageCount = source["PageCount"];
+	        this.Size = source["Size"];
+	        this.Tags = source["Tags"];
+	        this.PageTags = source["PageTags"];
 	        this.DisplayPath = source["DisplayPath"];
 	        this.TabletPath = source["TabletPath"];
 	    }
@@ -63,6 +71,26 @@ export namespace backend {
 	        this.Location = source["Location"];
 	    }
 	}
+	export class SSHAuthOptions {
+	    Method: string;
+	    Password: string;
+	    KeyPath: string;
+	    Passphrase: string;
+	    AgentSocket: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new SSHAuthOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.Method = source["Method"];
+	        this.Password = source["Password"];
+	        this.KeyPath = source["KeyPath"];
+	        this.Passphrase = source["Passphrase"];
+	        this.AgentSocket = source["AgentSocket"];
+	    }
+	}
 	export class SelectionInfo {
 	    Id: string;
 	    Status: number;
@@ -77,6 +105,26 @@ export namespace backend {
 	        this.Status = source["Status"];
 	    }
 	}
+	export class UploadResult {
+	    Id: string;
+	    Name: string;
+	    Action: string;
+	    DuplicateOf: string;
+	    SameContent: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new UploadResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.Id = source["Id"];
+	        this.Name = source["Name"];
+	        this.Action = source["Action"];
+	        this.DuplicateOf = source["DuplicateOf"];
+	        this.SameContent = source["SameContent"];
+	    }
+	}
 
 }
 